@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+// DefaultLogExcludePaths are the path prefixes left out of the access log when LOG_EXCLUDE_PATHS is not set.
+// The debug endpoints are polled by hand while testing, and would bury the federation traffic.
+var DefaultLogExcludePaths = []string{"/debug/"}
+
+// parseLogExcludePaths parses LOG_EXCLUDE_PATHS, a comma separated list of path prefixes.
+// DefaultLogExcludePaths is used if it is empty, and "none" logs every request.
+func parseLogExcludePaths(s string) []string {
+	switch strings.TrimSpace(s) {
+	case "":
+		return DefaultLogExcludePaths
+	case "none":
+		return nil
+	}
+
+	var prefixes []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes
+}
+
+// accessLogger is middleware.Logger that skips the requests to the paths under the prefixes.
+func accessLogger(excludes []string) echo.MiddlewareFunc {
+	return middleware.LoggerWithConfig(middleware.LoggerConfig{
+		Skipper: func(c echo.Context) bool {
+			path := c.Request().URL.Path
+			for _, p := range excludes {
+				if strings.HasPrefix(path, p) {
+					return true
+				}
+			}
+			return false
+		},
+	})
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// PublicAudience is the special collection that means the activity is public.
+const PublicAudience = "https://www.w3.org/ns/activitystreams#Public"
+
+// The JSON-LD contexts of the documents. SecurityContext is added where keys appear.
+const (
+	ActivityStreamsContext = "https://www.w3.org/ns/activitystreams"
+	SecurityContext        = "https://w3id.org/security/v1"
+	MultikeyContext        = "https://w3id.org/security/multikey/v1"
+)
+
+var (
+	errEmptyBody     = errors.New("empty request body")
+	errMalformedJSON = errors.New("malformed JSON")
+	errTooDeepJSON   = errors.New("JSON is nested too deep")
+	errBodyTooLarge  = errors.New("request body is too large")
+)
+
+// DefaultMaxJSONDepth is the nesting limit of incoming activities when Handler.MaxJSONDepth is zero.
+const DefaultMaxJSONDepth = 32
+
+// DefaultMaxBodySize is the size limit in bytes of incoming activities when Handler.MaxBodySize is zero.
+const DefaultMaxBodySize = 1 << 20
+
+// Activity is an incoming activity.
+// The fields that may be either a single value or an array, or either an IRI or an embedded object, are normalized.
+type Activity struct {
+	Context   any
+	ID        string
+	Type      []string
+	Actor     string
+	Object    ObjectRef
+	To        []string
+	Cc        []string
+	Published string
+
+	// Raw is the whole decoded activity, for the fields that are not covered above.
+	Raw map[string]any
+}
+
+func (a *Activity) UnmarshalJSON(data []byte) error {
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*a = Activity{
+		Context: raw["@context"],
+		Type:    typesOf(raw["type"]),
+		Actor:   idOf(raw["actor"]),
+		Object:  refOf(raw["object"]),
+		To:      audienceOf(raw["to"]),
+		Cc:      audienceOf(raw["cc"]),
+		Raw:     raw,
+	}
+	a.ID, _ = raw["id"].(string)
+	a.Published, _ = raw["published"].(string)
+
+	return nil
+}
+
+func (a Activity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.Raw)
+}
+
+// HasType reports whether the activity has the type.
+func (a *Activity) HasType(t string) bool {
+	for _, x := range a.Type {
+		if x == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ObjectRef is a reference to an object, which is either a bare IRI or an embedded object.
+type ObjectRef struct {
+	ID string
+
+	// Embedded is the object if it was embedded, or nil if it was a bare IRI.
+	Embedded map[string]any
+}
+
+func refOf(v any) ObjectRef {
+	ref := ObjectRef{ID: idOf(v)}
+	ref.Embedded, _ = v.(map[string]any)
+	return ref
+}
+
+// errUnsupportedObject is returned by objectOf for an object that is neither an IRI nor an embedded object with an id.
+var errUnsupportedObject = errors.New("object must be an IRI or an embedded object with id")
+
+// objectOf extracts the object of an activity such as Like or Announce, which is either a bare IRI or an embedded object.
+// Embedded is set only if the object was embedded.
+func objectOf(v any) (ObjectRef, error) {
+	switch x := v.(type) {
+	case string:
+		if x == "" {
+			return ObjectRef{}, errUnsupportedObject
+		}
+		return ObjectRef{ID: x}, nil
+	case map[string]any:
+		ref := refOf(x)
+		if ref.ID == "" {
+			return ObjectRef{}, errUnsupportedObject
+		}
+		return ref, nil
+	default:
+		return ObjectRef{}, errUnsupportedObject
+	}
+}
+
+// Type returns the type of the embedded object, or an empty string for a bare IRI.
+func (r ObjectRef) Type() string {
+	return typeOf(r.Embedded)
+}
+
+// readActivity reads the request body and decodes it as an activity.
+// Bodies larger than maxSize bytes are rejected with errBodyTooLarge without reading the rest,
+// and bodies nested deeper than maxDepth are rejected before decoding.
+// The raw body is returned even if decoding failed, so that it can be logged.
+func readActivity(r *http.Request, maxDepth int, maxSize int64) (*Activity, []byte, error) {
+	raw, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, maxSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, nil, errBodyTooLarge
+	} else if err != nil {
+		return nil, nil, errors.New("failed to read request body")
+	}
+
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, raw, errEmptyBody
+	}
+
+	if err := checkJSONDepth(raw, maxDepth); err != nil {
+		return nil, raw, err
+	}
+
+	var activity Activity
+	if err := json.Unmarshal(raw, &activity); err != nil {
+		return nil, raw, errMalformedJSON
+	}
+
+	return &activity, raw, nil
+}
+
+// checkJSONDepth scans the JSON without decoding it, and fails if objects and arrays are nested deeper than maxDepth.
+// encoding/json does not bound the depth by itself.
+func checkJSONDepth(raw []byte, maxDepth int) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errMalformedJSON
+		}
+
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+			if depth > maxDepth {
+				return errTooDeepJSON
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+}
+
+// typesOf returns the types of an object. JSON-LD allows type to be either a string or an array of strings.
+func typesOf(v any) []string {
+	switch x := v.(type) {
+	case string:
+		return []string{x}
+	case []any:
+		var types []string
+		for _, t := range x {
+			if s, ok := t.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	default:
+		return nil
+	}
+}
+
+// idOf returns the id of v, which is either a bare IRI or an embedded object.
+func idOf(v any) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case map[string]any:
+		id, _ := x["id"].(string)
+		return id
+	default:
+		return ""
+	}
+}
+
+// typeOf returns the type of an embedded object, or an empty string for a bare IRI.
+func typeOf(v any) string {
+	if x, ok := v.(map[string]any); ok {
+		if types := typesOf(x["type"]); len(types) > 0 {
+			return types[0]
+		}
+	}
+	return ""
+}
+
+// parsePublished parses a timestamp such as published, which must be RFC3339. The returned time is in UTC.
+func parsePublished(v any) (time.Time, error) {
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("published must be a string: %v", v)
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("published must be RFC3339: %q", s)
+	}
+	return t.UTC(), nil
+}
+
+// audienceOf returns the ids in an addressing field such as to or cc, which may be a single value or an array.
+// It also accepts []string, for the activities built by us.
+func audienceOf(v any) []string {
+	var xs []any
+	switch x := v.(type) {
+	case []any:
+		xs = x
+	case []string:
+		return append([]string{}, x...)
+	default:
+		xs = []any{v}
+	}
+
+	ids := []string{}
+	for _, x := range xs {
+		if id := idOf(x); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// isPublic reports whether the audience includes the public collection, in any of its compacted forms.
+func isPublic(audience []string) bool {
+	for _, id := range audience {
+		switch id {
+		case PublicAudience, "as:Public", "Public":
+			return true
+		}
+	}
+	return false
+}
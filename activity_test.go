@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadActivity(t *testing.T) {
+	tests := []struct {
+		Name string
+		Body string
+		Err  error
+	}{
+		{"empty", "", errEmptyBody},
+		{"whitespace", " \r\n\t", errEmptyBody},
+		{"malformed", `{"type":`, errMalformedJSON},
+		{"not JSON", "<html></html>", errMalformedJSON},
+		{"not an object", `["Create"]`, errMalformedJSON},
+		{"too deep", strings.Repeat("[", 5) + strings.Repeat("]", 5), errTooDeepJSON},
+		{"activity", `{"type":"Create","actor":"https://remote.example/users/carol"}`, nil},
+		{"too large", `{"type":"Create","actor":"https://remote.example/users/carol","content":"` + strings.Repeat("a", 40) + `"}`, errBodyTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/inbox", strings.NewReader(tt.Body))
+			activity, raw, err := readActivity(req, 4, 100)
+			if err != tt.Err {
+				t.Fatalf("expected %v but got %v", tt.Err, err)
+			}
+			// The raw body is returned even on errors, so that it can be logged, except the ones too large to read.
+			if tt.Err == errBodyTooLarge {
+				if raw != nil {
+					t.Errorf("unexpected raw body: %q", raw)
+				}
+			} else if string(raw) != tt.Body {
+				t.Errorf("unexpected raw body: %q", raw)
+			}
+			if err == nil && (activity == nil || activity.Actor != "https://remote.example/users/carol") {
+				t.Errorf("unexpected activity: %+v", activity)
+			}
+		})
+	}
+}
+
+func TestTypesOf(t *testing.T) {
+	tests := []struct {
+		Value any
+		Types []string
+	}{
+		{nil, nil},
+		{"Create", []string{"Create"}},
+		{[]any{"Create", "as:Create"}, []string{"Create", "as:Create"}},
+		{[]any{"Create", 1, nil}, []string{"Create"}},
+		{42.0, nil},
+	}
+
+	for _, tt := range tests {
+		if got := typesOf(tt.Value); !reflect.DeepEqual(got, tt.Types) {
+			t.Errorf("typesOf(%#v): expected %q but got %q", tt.Value, tt.Types, got)
+		}
+	}
+}
+
+func TestObjectOf(t *testing.T) {
+	embedded := map[string]any{"id": "https://remote.example/notes/1", "type": "Note"}
+
+	tests := []struct {
+		Name     string
+		Value    any
+		ID       string
+		Embedded bool
+		Err      bool
+	}{
+		{"IRI", "https://remote.example/notes/1", "https://remote.example/notes/1", false, false},
+		{"embedded", embedded, "https://remote.example/notes/1", true, false},
+		{"empty IRI", "", "", false, true},
+		{"embedded without id", map[string]any{"type": "Note"}, "", false, true},
+		{"number", 1.0, "", false, true},
+		{"array", []any{"https://remote.example/notes/1"}, "", false, true},
+		{"missing", nil, "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			ref, err := objectOf(tt.Value)
+			if tt.Err {
+				if err != errUnsupportedObject {
+					t.Fatalf("expected %v but got %v", errUnsupportedObject, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if ref.ID != tt.ID || (ref.Embedded != nil) != tt.Embedded {
+				t.Errorf("unexpected reference: %+v", ref)
+			}
+		})
+	}
+}
+
+func TestParsePublished(t *testing.T) {
+	tests := []struct {
+		Input any
+		Want  string
+		OK    bool
+	}{
+		{"2024-01-02T03:04:05Z", "2024-01-02T03:04:05Z", true},
+		{"2024-01-02T12:04:05+09:00", "2024-01-02T03:04:05Z", true},
+		{"2024-01-02T03:04:05.5Z", "2024-01-02T03:04:05.5Z", true},
+		{"2024-01-02 03:04:05", "", false},
+		{"yesterday", "", false},
+		{"", "", false},
+		{float64(1704164645), "", false},
+		{nil, "", false},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePublished(tt.Input)
+		if (err == nil) != tt.OK {
+			t.Errorf("%#v: unexpected error: %v", tt.Input, err)
+			continue
+		}
+		if !tt.OK {
+			continue
+		}
+		if got.Location() != time.UTC {
+			t.Errorf("%#v: expected UTC but got %s", tt.Input, got.Location())
+		}
+		if s := got.Format(time.RFC3339Nano); s != tt.Want {
+			t.Errorf("%#v: expected %s but got %s", tt.Input, tt.Want, s)
+		}
+	}
+}
+
+func TestCheckJSONDepth(t *testing.T) {
+	nested := func(depth int) []byte {
+		return []byte(strings.Repeat(`{"a":`, depth-1) + `[]` + strings.Repeat(`}`, depth-1))
+	}
+
+	tests := []struct {
+		Name  string
+		Raw   []byte
+		Limit int
+		Err   error
+	}{
+		{"flat", []byte(`{"type":"Create","to":["a","b"]}`), 1, errTooDeepJSON},
+		{"flat within the limit", []byte(`{"type":"Create","to":["a","b"]}`), 2, nil},
+		{"at the limit", nested(DefaultMaxJSONDepth), DefaultMaxJSONDepth, nil},
+		{"over the limit", nested(DefaultMaxJSONDepth + 1), DefaultMaxJSONDepth, errTooDeepJSON},
+		{"brackets in strings", []byte(`{"content":"[[[[[[[[{{{{{{{{"}`), 2, nil},
+		{"malformed", []byte(`{"a":[}`), DefaultMaxJSONDepth, errMalformedJSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			if err := checkJSONDepth(tt.Raw, tt.Limit); err != tt.Err {
+				t.Errorf("expected %v but got %v", tt.Err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-fed/activity/streams"
+	"github.com/go-fed/activity/streams/vocab"
+)
+
+// These tests parse the documents with github.com/go-fed/activity, an ActivityStreams implementation used by real servers,
+// to catch documents that this sandbox can read but others cannot. It is a test-only dependency.
+
+// roundTrip fetches the document at the path, and parses and serializes it with go-fed.
+func roundTrip(t *testing.T, h *Handler, path string) (vocab.Type, map[string]any, map[string]any) {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("Accept", "application/activity+json")
+	rec := serve(h, req)
+	if rec.Code != 200 {
+		t.Fatalf("GET %s: expected 200 but got %d: %s", path, rec.Code, rec.Body)
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	typ, err := streams.ToType(context.Background(), doc)
+	if err != nil {
+		t.Fatalf("GET %s: go-fed failed to parse: %s\n%s", path, err, rec.Body)
+	}
+	serialized, err := streams.Serialize(typ)
+	if err != nil {
+		t.Fatalf("GET %s: go-fed failed to serialize: %s", path, err)
+	}
+	return typ, doc, serialized
+}
+
+// assertSameProperties checks that the properties are kept through the round trip.
+// Values are compared as JSON with arrays of one item unwrapped, because go-fed serializes them as the item.
+func assertSameProperties(t *testing.T, original, serialized map[string]any, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		if _, ok := original[name]; !ok {
+			t.Errorf("%s is missing in the original document", name)
+			continue
+		}
+		if !reflect.DeepEqual(normalizeJSON(t, original[name]), normalizeJSON(t, serialized[name])) {
+			t.Errorf("%s changed through go-fed: %#v -> %#v", name, original[name], serialized[name])
+		}
+	}
+}
+
+// normalizeJSON decodes the value as JSON, so that numbers are float64, and unwraps the arrays of one item.
+func normalizeJSON(t *testing.T, v any) any {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded any
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return unwrapSingletons(decoded)
+}
+
+func unwrapSingletons(v any) any {
+	switch v := v.(type) {
+	case []any:
+		if len(v) == 1 {
+			return unwrapSingletons(v[0])
+		}
+		for i := range v {
+			v[i] = unwrapSingletons(v[i])
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = unwrapSingletons(v[k])
+		}
+	}
+	return v
+}
+
+func newRoundTripHandler(t *testing.T) *Handler {
+	h := newTestHandler(t, "alice")
+	h.Posts.Add(&Post{Username: "alice", Content: "<p>hello</p>", Published: time.Now()})
+	h.Followers.Add("alice", testRemoteActor)
+	return h
+}
+
+func TestActivityStreams_actor(t *testing.T) {
+	h := newRoundTripHandler(t)
+	typ, doc, serialized := roundTrip(t, h, "/@alice")
+
+	person, ok := typ.(vocab.ActivityStreamsPerson)
+	if !ok {
+		t.Fatalf("expected a Person but got %s", typ.GetTypeName())
+	}
+	if id := person.GetJSONLDId().Get().String(); id != h.userURL("alice") {
+		t.Errorf("unexpected id: %s", id)
+	}
+	if inbox := person.GetActivityStreamsInbox().GetIRI().String(); inbox != h.userURL("alice")+"/inbox" {
+		t.Errorf("unexpected inbox: %s", inbox)
+	}
+	if name := person.GetActivityStreamsPreferredUsername().GetXMLSchemaString(); name != "alice" {
+		t.Errorf("unexpected preferredUsername: %s", name)
+	}
+
+	keys := person.GetW3IDSecurityV1PublicKey()
+	if keys == nil || keys.Len() != 1 || !keys.At(0).IsW3IDSecurityV1PublicKey() {
+		t.Fatal("publicKey is not parsed as a key")
+	}
+	key := keys.At(0).Get()
+	if owner := key.GetW3IDSecurityV1Owner().Get().String(); owner != h.userURL("alice") {
+		t.Errorf("unexpected owner of the key: %s", owner)
+	}
+	if key.GetW3IDSecurityV1PublicKeyPem().Get() == "" {
+		t.Error("publicKeyPem is empty")
+	}
+
+	assertSameProperties(t, doc, serialized, "id", "type", "inbox", "outbox", "followers", "following", "preferredUsername", "url", "publicKey")
+}
+
+func TestActivityStreams_collections(t *testing.T) {
+	h := newRoundTripHandler(t)
+
+	for _, path := range []string{"/@alice/outbox", "/@alice/followers"} {
+		t.Run(path, func(t *testing.T) {
+			typ, doc, serialized := roundTrip(t, h, path)
+			collection, ok := typ.(vocab.ActivityStreamsOrderedCollection)
+			if !ok {
+				t.Fatalf("expected an OrderedCollection but got %s", typ.GetTypeName())
+			}
+			if n := collection.GetActivityStreamsTotalItems().Get(); n != 1 {
+				t.Errorf("expected 1 item but got %d", n)
+			}
+			assertSameProperties(t, doc, serialized, "id", "type", "totalItems", "first")
+
+			first := collection.GetActivityStreamsFirst().GetIRI().String()
+			typ, doc, serialized = roundTrip(t, h, first[len(h.baseURL()):])
+			page, ok := typ.(vocab.ActivityStreamsOrderedCollectionPage)
+			if !ok {
+				t.Fatalf("expected an OrderedCollectionPage but got %s", typ.GetTypeName())
+			}
+			if items := page.GetActivityStreamsOrderedItems(); items == nil || items.Len() != 1 {
+				t.Fatal("expected 1 ordered item")
+			}
+			assertSameProperties(t, doc, serialized, "id", "type", "partOf", "orderedItems")
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo"
+)
+
+// bearerAuth makes a middleware that requires "Authorization: Bearer <token>".
+// If token is empty, every request is rejected so that the admin API is disabled by default.
+func bearerAuth(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth := c.Request().Header.Get("Authorization")
+			given := strings.TrimPrefix(auth, "Bearer ")
+
+			if token == "" || given == auth || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				c.Response().Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				return c.JSON(401, map[string]string{
+					"error": "unauthorized",
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// DefaultMaxContentLength is the limit of the post content when Handler.MaxContentLength is zero.
+// It is the same number as Mastodon, but counted in bytes by default, which is never looser than characters.
+const DefaultMaxContentLength = 500
+
+// checkContentLength rejects content longer than MaxContentLength, counted in bytes or in runes by CountRunes.
+func (h *Handler) checkContentLength(content string) error {
+	limit := h.MaxContentLength
+	if limit <= 0 {
+		limit = DefaultMaxContentLength
+	}
+
+	n, unit := len(content), "bytes"
+	if h.CountRunes {
+		n, unit = utf8.RuneCountInString(content), "characters"
+	}
+	if n > limit {
+		return fmt.Errorf("content is too long: %d %s, the limit is %d", n, unit, limit)
+	}
+	return nil
+}
+
+// PostAdminPosts creates a Note, or a Question if poll is given.
+func (h *Handler) PostAdminPosts(c echo.Context) error {
+	var req struct {
+		Username string   `json:"username"`
+		Content  string   `json:"content"`
+		Mentions []string `json:"mentions"`
+
+		// Published backdates the post. It is the current time if omitted.
+		Published any `json:"published"`
+
+		// Summary is the content warning. Summary and Sensitive are decoded as any, so that a wrong type is reported as 422 rather than as malformed JSON.
+		Summary   any `json:"summary"`
+		Sensitive any `json:"sensitive"`
+
+		Poll *struct {
+			Options  []string  `json:"options"`
+			Multiple bool      `json:"multiple"`
+			EndTime  time.Time `json:"endTime"`
+		} `json:"poll"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(400, map[string]string{
+			"error": "malformed JSON",
+		})
+	}
+
+	user, ok := h.lookupUser(req.Username)
+	if !ok {
+		return c.JSON(404, map[string]string{
+			"error": "user not found",
+		})
+	}
+
+	if err := h.checkContentLength(req.Content); err != nil {
+		return c.JSON(422, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	published := time.Now()
+	if req.Published != nil {
+		t, err := parsePublished(req.Published)
+		if err != nil {
+			return c.JSON(422, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		published = t
+	}
+
+	post := &Post{
+		Username:  user.Name,
+		Content:   req.Content,
+		Mentions:  req.Mentions,
+		Published: published,
+	}
+	if req.Summary != nil {
+		summary, ok := req.Summary.(string)
+		if !ok {
+			return c.JSON(422, map[string]string{
+				"error": "summary must be a string",
+			})
+		}
+		post.Summary = summary
+	}
+	if req.Sensitive != nil {
+		sensitive, ok := req.Sensitive.(bool)
+		if !ok {
+			return c.JSON(422, map[string]string{
+				"error": "sensitive must be a bool",
+			})
+		}
+		post.Sensitive = sensitive
+	}
+
+	if req.Poll != nil {
+		if len(req.Poll.Options) < 2 {
+			return c.JSON(422, map[string]string{
+				"error": "poll needs at least two options",
+			})
+		}
+		if !req.Poll.EndTime.After(time.Now()) {
+			return c.JSON(422, map[string]string{
+				"error": "endTime of poll must be in the future",
+			})
+		}
+		if !req.Poll.EndTime.After(post.Published) {
+			return c.JSON(422, map[string]string{
+				"error": "endTime of poll must be after published",
+			})
+		}
+
+		post.Poll = &Poll{
+			Multiple: req.Poll.Multiple,
+			EndTime:  req.Poll.EndTime,
+		}
+		for _, name := range req.Poll.Options {
+			post.Poll.Options = append(post.Poll.Options, PollOption{Name: name})
+		}
+	}
+
+	h.Posts.Add(post)
+
+	go h.fanOut(post.Username, withContext(h.createActivity(post)))
+
+	return h.activityJSON(c, 201, withContext(h.postObject(post)))
+}
+
+// DeleteAdminPost deletes a post and delivers the Delete to the audience of the post.
+// The post is served as a Tombstone, and its Create in the outbox is replaced by the Delete. It is also routed as POST /admin/posts/:username/:id/delete.
+func (h *Handler) DeleteAdminPost(c echo.Context) error {
+	var post *Post
+	user, ok := h.lookupUser(c.Param("username"))
+	if ok {
+		post, ok = h.Posts.Delete(user.Name, c.Param("id"), time.Now())
+	}
+	if !ok {
+		return c.JSON(404, map[string]string{
+			"error": "post not found",
+		})
+	}
+
+	go h.fanOut(post.Username, withContext(h.deleteActivity(post)))
+
+	return h.activityJSON(c, 200, withContext(h.tombstoneObject(post)))
+}
+
+// PostAdminFollows sends a Follow from the local user to a remote actor.
+// The actor is added to the following collection when it sends back the Accept.
+func (h *Handler) PostAdminFollows(c echo.Context) error {
+	var req struct {
+		Username string `json:"username"`
+		Actor    string `json:"actor"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(400, map[string]string{
+			"error": "malformed JSON",
+		})
+	}
+	if req.Actor == "" {
+		return c.JSON(400, map[string]string{
+			"error": "actor is required",
+		})
+	}
+
+	user, ok := h.lookupUser(req.Username)
+	if !ok {
+		return c.JSON(404, map[string]string{
+			"error": "user not found",
+		})
+	}
+
+	follow, err := h.sendFollow(c.Request().Context(), user.Name, req.Actor)
+	if err != nil {
+		return c.JSON(502, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return h.activityJSON(c, 202, follow)
+}
+
+// sendFollow delivers a Follow from the local user to the remote actor, and remembers it until the actor answers.
+// The Follow is sent to the id in the actor document, which the Accept comes back with, rather than the given one.
+func (h *Handler) sendFollow(ctx context.Context, username, actor string) (map[string]any, error) {
+	inbox, actor, err := h.resolveInbox(ctx, actor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve inbox: %w", err)
+	}
+
+	follow := h.newActivity(username, map[string]any{
+		"@context": ActivityStreamsContext,
+		"type":     "Follow",
+		"actor":    h.userURL(username),
+		"object":   actor,
+	})
+	h.OutgoingFollows.Add(OutgoingFollow{
+		Username: username,
+		Actor:    actor,
+		FollowID: follow["id"].(string),
+		SentAt:   time.Now(),
+	})
+
+	if err := h.deliver(username, inbox, follow); err != nil {
+		h.OutgoingFollows.Take(follow["id"].(string), actor)
+		return nil, fmt.Errorf("failed to send Follow: %w", err)
+	}
+	return follow, nil
+}
+
+// GetAdminFollows lists the Follows sent by the local users that are not answered yet.
+func (h *Handler) GetAdminFollows(c echo.Context) error {
+	return c.JSON(200, h.OutgoingFollows.List())
+}
+
+// GetAdminPendingFollows lists the follow requests waiting for approval.
+func (h *Handler) GetAdminPendingFollows(c echo.Context) error {
+	return c.JSON(200, h.PendingFollows.List())
+}
+
+// PostAdminAcceptFollow accepts a pending follow request.
+func (h *Handler) PostAdminAcceptFollow(c echo.Context) error {
+	return h.resolvePendingFollow(c, FollowAccept)
+}
+
+// PostAdminRejectFollow rejects a pending follow request.
+func (h *Handler) PostAdminRejectFollow(c echo.Context) error {
+	return h.resolvePendingFollow(c, FollowReject)
+}
+
+// resolvePendingFollow sends the answer to the pending follow request of :id.
+// The request is put back if the answer cannot be delivered, so that it can be retried.
+func (h *Handler) resolvePendingFollow(c echo.Context, decision FollowDecision) error {
+	pending, ok := h.PendingFollows.Take(c.Param("id"))
+	if !ok {
+		return c.JSON(404, map[string]string{
+			"error": "pending follow not found",
+		})
+	}
+
+	if err := h.answerFollow(c.Request().Context(), pending.Username, pending.Follow, decision); err != nil {
+		c.Logger().Printf("failed to answer follow from %s: %s", pending.Actor, err)
+		h.PendingFollows.Restore(pending)
+		return c.JSON(502, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	if decision == FollowReject {
+		return c.JSON(200, map[string]string{
+			"status": "rejected",
+		})
+	}
+	return c.JSON(200, map[string]string{
+		"status": "accepted",
+	})
+}
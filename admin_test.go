@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postAdmin sends the JSON body to the admin API with the admin token of the handler.
+func postAdmin(t *testing.T, h *Handler, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", path, strings.NewReader(string(b)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+h.AdminToken)
+	return serve(h, req)
+}
+
+func TestPostAdminPosts_contentLength(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Limit   int
+		Runes   bool
+		Content string
+		Code    int
+	}{
+		{"default limit", 0, false, strings.Repeat("a", DefaultMaxContentLength), 201},
+		{"over default limit", 0, false, strings.Repeat("a", DefaultMaxContentLength+1), 422},
+		{"at limit", 5, false, "hello", 201},
+		{"over limit", 5, false, "hello!", 422},
+		{"multibyte in bytes", 5, false, "あいう", 422},
+		{"multibyte in runes", 5, true, "あいうえお", 201},
+		{"over limit in runes", 5, true, "あいうえおか", 422},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := newTestHandler(t, "alice")
+			h.AdminToken = "secret"
+			h.MaxContentLength = tt.Limit
+			h.CountRunes = tt.Runes
+
+			rec := postAdmin(t, h, "/admin/posts", map[string]any{"username": "alice", "content": tt.Content})
+			if rec.Code != tt.Code {
+				t.Fatalf("expected %d but got %d: %s", tt.Code, rec.Code, rec.Body)
+			}
+			if tt.Code == 422 && !strings.Contains(rec.Body.String(), "content is too long") {
+				t.Errorf("unexpected error: %s", rec.Body)
+			}
+			if n := len(h.Posts.List("alice")); (n == 1) != (tt.Code == 201) {
+				t.Errorf("unexpected number of posts: %d", n)
+			}
+		})
+	}
+}
+
+func TestPostAdminPosts_published(t *testing.T) {
+	tests := []struct {
+		Published any
+		Code      int
+		Want      string
+	}{
+		{"2024-01-02T12:04:05+09:00", 201, "2024-01-02T03:04:05Z"},
+		{"2024-01-02", 422, ""},
+		{12345, 422, ""},
+	}
+
+	for _, tt := range tests {
+		h := newTestHandler(t, "alice")
+		h.AdminToken = "secret"
+
+		rec := postAdmin(t, h, "/admin/posts", map[string]any{"username": "alice", "content": "hello", "published": tt.Published})
+		if rec.Code != tt.Code {
+			t.Errorf("%v: expected %d but got %d: %s", tt.Published, tt.Code, rec.Code, rec.Body)
+			continue
+		}
+
+		posts := h.Posts.List("alice")
+		if tt.Code != 201 {
+			if len(posts) != 0 {
+				t.Errorf("%v: the post was stored", tt.Published)
+			}
+			continue
+		}
+		if len(posts) != 1 || posts[0].Published.Format(time.RFC3339) != tt.Want || posts[0].Published.Location() != time.UTC {
+			t.Errorf("%v: unexpected posts: %v", tt.Published, posts)
+		}
+	}
+}
+
+func TestPostAdminPosts_pollEndTime(t *testing.T) {
+	now := time.Now().UTC()
+	backdated := now.Add(-48 * time.Hour).Format(time.RFC3339)
+	postdated := now.Add(48 * time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		Name      string
+		Published any
+		EndTime   time.Time
+		Code      int
+	}{
+		{"future", nil, now.Add(time.Hour), 201},
+		{"past", nil, now.Add(-time.Hour), 422},
+		{"backdated post with a future end", backdated, now.Add(time.Hour), 201},
+		{"backdated post with a past end", backdated, now.Add(-time.Hour), 422},
+		{"postdated post ending before it", postdated, now.Add(time.Hour), 422},
+	}
+
+	for _, tt := range tests {
+		h := newTestHandler(t, "alice")
+		h.AdminToken = "secret"
+
+		body := map[string]any{"username": "alice", "content": "which?", "poll": map[string]any{"options": []string{"yes", "no"}, "endTime": tt.EndTime}}
+		if tt.Published != nil {
+			body["published"] = tt.Published
+		}
+		if rec := postAdmin(t, h, "/admin/posts", body); rec.Code != tt.Code {
+			t.Errorf("%s: expected %d but got %d: %s", tt.Name, tt.Code, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestDeleteAdminPost_federation(t *testing.T) {
+	for _, method := range []string{"DELETE", "POST"} {
+		t.Run(method, func(t *testing.T) {
+			h := newTestHandler(t, "alice")
+			h.AdminToken = "secret"
+			remote := newFakeRemote(t, h)
+			h.Followers.Add("alice", remote.actor("carol"))
+			h.Posts.Add(&Post{Username: "alice", Content: "hello", Published: time.Now()})
+			postID := h.userURL("alice") + "/posts/1"
+
+			path := "/admin/posts/alice/1"
+			if method == "POST" {
+				path += "/delete"
+			}
+			req := httptest.NewRequest(method, path, nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			if rec := serve(h, req); rec.Code != 200 {
+				t.Fatalf("failed to delete: %d %s", rec.Code, rec.Body)
+			}
+
+			var received []map[string]any
+			for deadline := time.Now().Add(5 * time.Second); len(received) == 0 && time.Now().Before(deadline); {
+				time.Sleep(10 * time.Millisecond)
+				received = remote.Received()
+			}
+			if len(received) != 1 {
+				t.Fatalf("expected a Delete but got %v", received)
+			}
+			activity := received[0]
+			object, _ := activity["object"].(map[string]any)
+			if activity["type"] != "Delete" || activity["actor"] != h.userURL("alice") || object["type"] != "Tombstone" || object["id"] != postID {
+				t.Errorf("unexpected activity: %v", activity)
+			}
+
+			items := getJSON(t, h, "/@alice/outbox?page=0")["orderedItems"].([]any)
+			if len(items) != 1 || items[0].(map[string]any)["type"] != "Delete" {
+				t.Errorf("unexpected outbox: %v", items)
+			}
+		})
+	}
+}
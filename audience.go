@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ActorResolver fetches remote actor documents for delivery.
+type ActorResolver interface {
+	ResolveActor(ctx context.Context, id string) (*remoteActor, error)
+}
+
+// ActorResolverFunc adapts a function to ActorResolver.
+type ActorResolverFunc func(ctx context.Context, id string) (*remoteActor, error)
+
+func (f ActorResolverFunc) ResolveActor(ctx context.Context, id string) (*remoteActor, error) {
+	return f(ctx, id)
+}
+
+// DefaultActorCacheTTL is how long a fetched actor is reused when Handler.ActorCacheTTL is zero.
+const DefaultActorCacheTTL = time.Hour
+
+// actorResolver returns the ActorResolver, which fetches the actors over HTTP through ActorCache unless Handler.Actors is set.
+func (h *Handler) actorResolver() ActorResolver {
+	if h.Actors != nil {
+		return h.Actors
+	}
+	return ActorResolverFunc(h.cachedActor)
+}
+
+// cachedActor returns the actor from ActorCache, or fetches it if it is not cached or is older than ActorCacheTTL.
+func (h *Handler) cachedActor(ctx context.Context, id string) (*remoteActor, error) {
+	ttl := h.ActorCacheTTL
+	if ttl <= 0 {
+		ttl = DefaultActorCacheTTL
+	}
+
+	if actor, ok := h.ActorCache.Get(id, ttl, time.Now()); ok {
+		return actor, nil
+	}
+
+	actor, err := h.fetchActor(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	h.ActorCache.Put(id, actor, time.Now())
+	return actor, nil
+}
+
+// resolveDeliveryTargets returns the inboxes to deliver an activity of the user to.
+// The followers collection of the user in to and cc is expanded, the other actors are resolved, and the public collection and local actors are skipped.
+// Shared inboxes are preferred for activities addressed to the public or the followers, so that each instance receives the activity once.
+// The actors that could not be resolved are skipped and reported in the error, alongside the inboxes of the others.
+func (h *Handler) resolveDeliveryTargets(ctx context.Context, username string, activity map[string]any) ([]string, error) {
+	followers := h.userURL(username) + "/followers"
+
+	var actors []string
+	seenActor := make(map[string]bool)
+	add := func(id string) {
+		if !seenActor[id] {
+			seenActor[id] = true
+			actors = append(actors, id)
+		}
+	}
+
+	shared := false
+	for _, id := range append(audienceOf(activity["to"]), audienceOf(activity["cc"])...) {
+		switch {
+		case isPublic([]string{id}):
+			shared = true
+		case id == followers:
+			shared = true
+			for _, f := range h.Followers.List(username) {
+				add(f)
+			}
+		default:
+			add(id)
+		}
+	}
+
+	var inboxes []string
+	var errs []error
+	seenInbox := make(map[string]bool)
+	for _, id := range actors {
+		if _, ok := h.localUsername(id); ok {
+			continue
+		}
+
+		actor, err := h.actorResolver().ResolveActor(ctx, id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to resolve inbox of %s: %w", id, err))
+			continue
+		}
+
+		inbox := actor.Inbox
+		if shared && actor.Endpoints.SharedInbox != "" {
+			inbox = actor.Endpoints.SharedInbox
+		}
+		if !seenInbox[inbox] {
+			seenInbox[inbox] = true
+			inboxes = append(inboxes, inbox)
+		}
+	}
+	return inboxes, errors.Join(errs...)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testResolver resolves the actors of one.example to the shared inbox of the instance, and the others to their own inboxes only.
+func testResolver() ActorResolver {
+	return ActorResolverFunc(func(ctx context.Context, id string) (*remoteActor, error) {
+		actors := map[string]string{
+			"https://one.example/users/carol": "https://one.example/inbox",
+			"https://one.example/users/dave":  "https://one.example/inbox",
+			"https://two.example/users/erin":  "",
+		}
+		shared, ok := actors[id]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+		a := &remoteActor{ID: id, Inbox: id + "/inbox"}
+		a.Endpoints.SharedInbox = shared
+		return a, nil
+	})
+}
+
+func TestResolveDeliveryTargets(t *testing.T) {
+	h := newTestHandler(t, "alice", "bob")
+	h.Actors = testResolver()
+	h.Followers.Add("alice", "https://one.example/users/carol")
+	h.Followers.Add("alice", "https://one.example/users/dave")
+	followers := h.userURL("alice") + "/followers"
+
+	tests := []struct {
+		Name    string
+		To      []any
+		Cc      []any
+		Inboxes []string
+		Err     bool
+	}{
+		{
+			"public with mentions",
+			[]any{PublicAudience},
+			[]any{followers, "https://one.example/users/dave", "https://two.example/users/erin"},
+			[]string{"https://one.example/inbox", "https://two.example/users/erin/inbox"},
+			false,
+		},
+		{
+			"followers only",
+			[]any{followers},
+			nil,
+			[]string{"https://one.example/inbox"},
+			false,
+		},
+		{
+			"direct to a follower",
+			[]any{"https://one.example/users/dave"},
+			nil,
+			[]string{"https://one.example/users/dave/inbox"},
+			false,
+		},
+		{
+			"direct to the same actor twice",
+			[]any{"https://two.example/users/erin"},
+			[]any{"https://two.example/users/erin"},
+			[]string{"https://two.example/users/erin/inbox"},
+			false,
+		},
+		{
+			"local and unknown actors",
+			[]any{h.userURL("bob"), "https://three.example/users/frank", "https://two.example/users/erin"},
+			nil,
+			[]string{"https://two.example/users/erin/inbox"},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			activity := map[string]any{"type": "Create", "to": tt.To, "cc": tt.Cc}
+			inboxes, err := h.resolveDeliveryTargets(context.Background(), "alice", activity)
+			if (err != nil) != tt.Err {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(inboxes, tt.Inboxes) {
+				t.Errorf("expected %q but got %q", tt.Inboxes, inboxes)
+			}
+		})
+	}
+}
+
+func TestFanOut_sharedInbox(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	remote := newFakeRemote(t, h)
+
+	var resolved []string
+	h.Actors = ActorResolverFunc(func(ctx context.Context, id string) (*remoteActor, error) {
+		resolved = append(resolved, id)
+		a := &remoteActor{ID: id, Inbox: id + "/inbox"}
+		a.Endpoints.SharedInbox = remote.URL + "/inbox"
+		return a, nil
+	})
+	h.Followers.Add("alice", remote.actor("carol"))
+	h.Followers.Add("alice", remote.actor("dave"))
+
+	h.Posts.Add(&Post{Username: "alice", Content: "hello", Published: time.Now()})
+	h.fanOut("alice", withContext(h.createActivity(h.Posts.List("alice")[0])))
+	waitDelivery(t, h)
+
+	if !reflect.DeepEqual(resolved, []string{remote.actor("carol"), remote.actor("dave")}) {
+		t.Errorf("unexpected resolved actors: %q", resolved)
+	}
+	if received := remote.Received(); len(received) != 1 || received[0]["type"] != "Create" {
+		t.Errorf("expected one Create to the shared inbox but got %v", received)
+	}
+}
+
+func TestResolveDeliveryTargets_cache(t *testing.T) {
+	h := newTestHandler(t, "alice")
+
+	var mu sync.Mutex
+	fetched := make(map[string]int)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched[r.URL.Path]++
+		mu.Unlock()
+
+		actor := map[string]any{"id": "https://" + r.Host + r.URL.Path, "type": "Person"}
+		if r.URL.Path != "/users/noinbox" {
+			actor["inbox"] = actor["id"].(string) + "/inbox"
+		}
+		w.Header().Set("Content-Type", "application/activity+json")
+		json.NewEncoder(w).Encode(actor)
+	}))
+	t.Cleanup(srv.Close)
+	h.Client = srv.Client()
+	fetchedCarol := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return fetched["/users/carol"]
+	}
+
+	activity := map[string]any{"type": "Create", "to": []any{srv.URL + "/users/carol", srv.URL + "/users/noinbox"}}
+	resolve := func() {
+		t.Helper()
+
+		inboxes, err := h.resolveDeliveryTargets(context.Background(), "alice", activity)
+		if !reflect.DeepEqual(inboxes, []string{srv.URL + "/users/carol/inbox"}) {
+			t.Errorf("unexpected inboxes: %q", inboxes)
+		}
+		if err == nil || !strings.Contains(err.Error(), "has no inbox") {
+			t.Errorf("the actor without an inbox is not reported: %v", err)
+		}
+	}
+
+	resolve()
+	resolve()
+	if n := fetchedCarol(); n != 1 {
+		t.Errorf("expected carol to be fetched once but fetched %d times", n)
+	}
+
+	h.ActorCacheTTL = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	resolve()
+	if n := fetchedCarol(); n != 2 {
+		t.Errorf("expected carol to be fetched again after the TTL but fetched %d times", n)
+	}
+}
+
+func TestActorCache(t *testing.T) {
+	var c ActorCache
+	now := time.Now()
+
+	if _, ok := c.Get("https://remote.example/users/carol", time.Hour, now); ok {
+		t.Error("an empty cache returned an actor")
+	}
+
+	actor := &remoteActor{ID: "https://remote.example/users/carol", Inbox: "https://remote.example/users/carol/inbox"}
+	c.Put(actor.ID, actor, now)
+
+	if got, ok := c.Get(actor.ID, time.Hour, now.Add(time.Hour)); !ok || got != actor {
+		t.Error("the actor expired before the TTL")
+	}
+	if _, ok := c.Get(actor.ID, time.Hour, now.Add(time.Hour+time.Second)); ok {
+		t.Error("the actor did not expire after the TTL")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// DefaultCacheMaxAge is the max-age of the cacheable documents when Handler.CacheMaxAge is zero.
+const DefaultCacheMaxAge = 5 * time.Minute
+
+// DefaultKeyRotationMaxAge and DefaultKeyRotationWindow are used when Handler.KeyRotationMaxAge and Handler.KeyRotationWindow are zero.
+const (
+	DefaultKeyRotationMaxAge = 30 * time.Second
+	DefaultKeyRotationWindow = time.Hour
+)
+
+func (h *Handler) keyRotationMaxAge() time.Duration {
+	if h.KeyRotationMaxAge > 0 {
+		return h.KeyRotationMaxAge
+	}
+	return DefaultKeyRotationMaxAge
+}
+
+func (h *Handler) keyRotationWindow() time.Duration {
+	if h.KeyRotationWindow > 0 {
+		return h.KeyRotationWindow
+	}
+	return DefaultKeyRotationWindow
+}
+
+func (h *Handler) cacheMaxAge() time.Duration {
+	if h.CacheMaxAge > 0 {
+		return h.CacheMaxAge
+	}
+	return DefaultCacheMaxAge
+}
+
+// cacheControl is a middleware that sets Cache-Control with CacheMaxAge on success, and no-store on errors.
+// Cacheable responses also get Vary: Accept, because the documents behind it are negotiated between HTML and ActivityStreams.
+func (h *Handler) cacheControl(next echo.HandlerFunc) echo.HandlerFunc {
+	return h.cacheControlWith(func(echo.Context) time.Duration {
+		return h.cacheMaxAge()
+	})(next)
+}
+
+// actorCacheControl is cacheControl for the actor, whose max-age drops to KeyRotationMaxAge for KeyRotationWindow after the key of the user is rotated,
+// so that remote servers pick up the new key soon.
+func (h *Handler) actorCacheControl(next echo.HandlerFunc) echo.HandlerFunc {
+	return h.cacheControlWith(func(c echo.Context) time.Duration {
+		return h.actorMaxAge(c.Param("username"), time.Now())
+	})(next)
+}
+
+// actorMaxAge returns the max-age of the actor of the user at now.
+func (h *Handler) actorMaxAge(username string, now time.Time) time.Duration {
+	maxAge := h.cacheMaxAge()
+	if t, ok := h.KeyRotations.RotatedAt(username); ok && now.Sub(t) < h.keyRotationWindow() && h.keyRotationMaxAge() < maxAge {
+		return h.keyRotationMaxAge()
+	}
+	return maxAge
+}
+
+// cacheControlWith is a middleware that sets Cache-Control with the max-age returned by maxAge on success, and no-store on errors.
+// maxAge is called when the status code is decided, so it sees what the handler did, such as the canonical username set by requireUser.
+func (h *Handler) cacheControlWith(maxAge func(echo.Context) time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			res := c.Response()
+			res.Writer = &cacheControlWriter{
+				ResponseWriter: res.Writer,
+				maxAge: func() string {
+					return fmt.Sprintf("max-age=%d", int(maxAge(c).Seconds()))
+				},
+			}
+			return next(c)
+		}
+	}
+}
+
+// cacheControlWriter sets Cache-Control, and Vary for the cacheable responses, when the status code is decided.
+type cacheControlWriter struct {
+	http.ResponseWriter
+	maxAge func() string
+}
+
+func (w *cacheControlWriter) WriteHeader(code int) {
+	if code >= 400 {
+		w.Header().Set("Cache-Control", "no-store")
+	} else {
+		w.Header().Set("Cache-Control", w.maxAge())
+		w.Header().Add("Vary", "Accept")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
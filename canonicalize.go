@@ -0,0 +1,43 @@
+package main
+
+import "fmt"
+
+// Canonicalizer converts a request body into the bytes that the Digest header covers.
+type Canonicalizer interface {
+	Canonicalize(body []byte) ([]byte, error)
+}
+
+// CanonicalizerFunc adapts a function to Canonicalizer.
+type CanonicalizerFunc func(body []byte) ([]byte, error)
+
+func (f CanonicalizerFunc) Canonicalize(body []byte) ([]byte, error) {
+	return f(body)
+}
+
+// RawBytes digests the body as is, which is what Mastodon and most implementations do.
+var RawBytes = CanonicalizerFunc(func(body []byte) ([]byte, error) {
+	return body, nil
+})
+
+// canonicalizers are the Canonicalizers selectable by DIGEST_CANONICALIZATION.
+// Implementations with extra dependencies register themselves from files behind build tags.
+var canonicalizers = map[string]Canonicalizer{
+	"":    RawBytes,
+	"raw": RawBytes,
+}
+
+// lookupCanonicalizer finds the Canonicalizer by name.
+func lookupCanonicalizer(name string) (Canonicalizer, error) {
+	c, ok := canonicalizers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown canonicalization %q; it may need a build tag such as -tags jsonld", name)
+	}
+	return c, nil
+}
+
+func (h *Handler) canonicalizer() Canonicalizer {
+	if h.Canonicalizer != nil {
+		return h.Canonicalizer
+	}
+	return RawBytes
+}
@@ -0,0 +1,35 @@
+//go:build jsonld
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/piprate/json-gold/ld"
+)
+
+// This file is built only with `go build -tags jsonld`, which is needed to use DIGEST_CANONICALIZATION=jsonld,
+// so that the default build does not link github.com/piprate/json-gold.
+
+func init() {
+	canonicalizers["jsonld"] = CanonicalizerFunc(canonicalizeJSONLD)
+}
+
+// canonicalizeJSONLD normalizes the body into N-Quads with the URDNA2015 algorithm.
+func canonicalizeJSONLD(body []byte) ([]byte, error) {
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+
+	options := ld.NewJsonLdOptions("")
+	options.Format = "application/n-quads"
+	options.Algorithm = "URDNA2015"
+
+	normalized, err := ld.NewJsonLdProcessor().Normalize(doc, options)
+	if err != nil {
+		return nil, err
+	}
+	s, _ := normalized.(string)
+	return []byte(s), nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// injectDelay is a middleware that sleeps for a random duration between DelayMin and DelayMax before responding.
+// It does nothing if DelayMax is zero.
+func (h *Handler) injectDelay(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if h.DelayMax > 0 {
+			d := h.DelayMin
+			if h.DelayMax > h.DelayMin {
+				d += time.Duration(rand.Int63n(int64(h.DelayMax - h.DelayMin)))
+			}
+
+			select {
+			case <-time.After(d):
+			case <-c.Request().Context().Done():
+				return c.Request().Context().Err()
+			}
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// DefaultPageSize is the number of items in a collection page, which is the same as Mastodon.
+const DefaultPageSize = 20
+
+func (h *Handler) pageSize() int {
+	if h.PageSize > 0 {
+		return h.PageSize
+	}
+	return DefaultPageSize
+}
+
+// parsePage parses the page query parameter. It returns false if the value is not a non-negative integer.
+func parsePage(s string) (int, bool) {
+	n, err := strconv.Atoi(s)
+	return n, err == nil && n >= 0
+}
+
+// pageURL returns the URL of a page of the collection, which may already have a query string.
+func pageURL(id string, page int) string {
+	if strings.Contains(id, "?") {
+		return fmt.Sprintf("%s&page=%d", id, page)
+	}
+	return fmt.Sprintf("%s?page=%d", id, page)
+}
+
+// cursorURL returns the URL of a page of the collection that starts from a cursor such as max_id.
+func cursorURL(id, key, cursor string) string {
+	if strings.Contains(id, "?") {
+		return fmt.Sprintf("%s&%s=%s", id, key, cursor)
+	}
+	return fmt.Sprintf("%s?%s=%s", id, key, cursor)
+}
+
+// orderedCollection builds the summary of an OrderedCollection that has total items.
+func (h *Handler) orderedCollection(id string, total int) map[string]any {
+	last := 0
+	if total > 0 {
+		last = (total - 1) / h.pageSize()
+	}
+
+	return map[string]any{
+		"@context":   ActivityStreamsContext,
+		"id":         id,
+		"type":       "OrderedCollection",
+		"totalItems": total,
+		"first":      pageURL(id, 0),
+		"last":       pageURL(id, last),
+	}
+}
+
+// orderedCollectionOf builds the summary of an OrderedCollection of the items.
+// The items are embedded as orderedItems instead of the first and last pages if there are fewer than InlineItemsBelow.
+func orderedCollectionOf[T any](h *Handler, id string, items []T) map[string]any {
+	if len(items) >= h.InlineItemsBelow {
+		return h.orderedCollection(id, len(items))
+	}
+	if items == nil {
+		items = []T{}
+	}
+	return map[string]any{
+		"@context":     ActivityStreamsContext,
+		"id":           id,
+		"type":         "OrderedCollection",
+		"totalItems":   len(items),
+		"orderedItems": items,
+	}
+}
+
+// hiddenCollection builds the summary of a collection whose items are hidden. It has totalItems only, without pages.
+func hiddenCollection(id string, total int) map[string]any {
+	return map[string]any{
+		"@context":   ActivityStreamsContext,
+		"id":         id,
+		"type":       "OrderedCollection",
+		"totalItems": total,
+	}
+}
+
+// orderedCollectionPage builds a page of an OrderedCollection without @context.
+func orderedCollectionPage[T any](h *Handler, id string, items []T, page int) map[string]any {
+	size := h.pageSize()
+
+	start := page * size
+	if start > len(items) {
+		start = len(items)
+	}
+	end := start + size
+	if end > len(items) {
+		end = len(items)
+	}
+
+	p := map[string]any{
+		"id":           pageURL(id, page),
+		"type":         "OrderedCollectionPage",
+		"partOf":       id,
+		"orderedItems": items[start:end],
+	}
+	if end < len(items) {
+		p["next"] = pageURL(id, page+1)
+	}
+	if page > 0 {
+		p["prev"] = pageURL(id, page-1)
+	}
+	return p
+}
+
+var actorListTemplate = template.Must(template.New("actors").Parse(`<!DOCTYPE html>
+<title>{{.Title}}</title>
+<h1>{{.Title}}</h1>
+<ul>
+{{- range .Actors}}
+<li><a href="{{.ID}}">{{.Handle}}</a></li>
+{{- else}}
+<li>nobody yet.</li>
+{{- end}}
+</ul>
+`))
+
+// actorListHTML renders a list of actors as HTML, for browsing the collections.
+func actorListHTML(c echo.Context, title string, actors []string) error {
+	type entry struct {
+		ID     string
+		Handle string
+	}
+	data := struct {
+		Title  string
+		Actors []entry
+	}{Title: title}
+	for _, id := range actors {
+		data.Actors = append(data.Actors, entry{ID: id, Handle: handleOf(id)})
+	}
+
+	var buf bytes.Buffer
+	if err := actorListTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+	return c.HTMLBlob(200, buf.Bytes())
+}
+
+// handleOf guesses the "@user@host" handle from an actor id, such as "https://example.com/users/alice".
+// It returns the id as is if it does not look like a URL.
+func handleOf(id string) string {
+	u, err := url.Parse(id)
+	if err != nil || u.Host == "" {
+		return id
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	name := strings.TrimPrefix(path[strings.LastIndex(path, "/")+1:], "@")
+	if name == "" {
+		return id
+	}
+	return "@" + name + "@" + u.Host
+}
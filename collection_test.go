@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectionPages(t *testing.T) {
+	const total = 7
+
+	h := newTestHandler(t, "alice")
+	h.PageSize = 3
+	for i := 0; i < total; i++ {
+		actor := fmt.Sprintf("https://remote.example/users/%d", i)
+		h.Followers.Add("alice", actor)
+		h.Following.Add("alice", actor)
+		h.Posts.Add(&Post{Username: "alice", Content: fmt.Sprint(i), Published: time.Now()})
+	}
+
+	for _, name := range []string{"followers", "following", "outbox"} {
+		t.Run(name, func(t *testing.T) {
+			id := h.userURL("alice") + "/" + name
+			collection := getJSON(t, h, "/@alice/"+name)
+			if collection["totalItems"] != float64(total) {
+				t.Errorf("unexpected totalItems: %v", collection["totalItems"])
+			}
+			if collection["first"] != id+"?page=0" || collection["last"] != id+"?page=2" {
+				t.Errorf("unexpected first and last: %v, %v", collection["first"], collection["last"])
+			}
+
+			seen := map[string]bool{}
+			next, _ := collection["first"].(string)
+			prev := ""
+			for pages := 0; next != ""; pages++ {
+				if pages > 3 {
+					t.Fatal("too many pages")
+				}
+				page := getJSON(t, h, strings.TrimPrefix(next, h.baseURL()))
+				if page["id"] != next || page["partOf"] != id {
+					t.Errorf("unexpected id or partOf of %s: %v, %v", next, page["id"], page["partOf"])
+				}
+				if p, _ := page["prev"].(string); p != prev {
+					t.Errorf("unexpected prev of %s: %q", next, p)
+				}
+
+				items, _ := page["orderedItems"].([]any)
+				want := h.PageSize
+				if next == collection["last"] {
+					want = total % h.PageSize
+					if _, ok := page["next"]; ok {
+						t.Errorf("the last page has next: %v", page["next"])
+					}
+				}
+				if len(items) != want {
+					t.Errorf("expected %d items in %s but got %d", want, next, len(items))
+				}
+				for _, item := range items {
+					key := idOf(item)
+					if seen[key] {
+						t.Errorf("%s appears on two pages", key)
+					}
+					seen[key] = true
+				}
+
+				prev = next
+				next, _ = page["next"].(string)
+			}
+			if len(seen) != total {
+				t.Errorf("expected %d items through the pages but got %d", total, len(seen))
+			}
+		})
+	}
+}
+
+func TestCollectionContext(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	h.Posts.Add(&Post{Username: "alice", Content: "hello", Published: time.Now()})
+	h.Followers.Add("alice", testRemoteActor)
+
+	for _, path := range []string{
+		"/@alice/outbox",
+		"/@alice/outbox?page=0",
+		"/@alice/outbox?type=Create",
+		"/@alice/outbox?type=Create&page=0",
+		"/@alice/followers",
+		"/@alice/followers?page=0",
+		"/@alice/following",
+		"/@alice/following?page=0",
+		"/@alice/collections/tags",
+	} {
+		// Collections of types outside ActivityStreams, such as Hashtag, extend the context after it.
+		doc := getJSON(t, h, path)
+		context := doc["@context"]
+		if xs, ok := context.([]any); ok && len(xs) > 0 {
+			context = xs[0]
+		}
+		if context != ActivityStreamsContext {
+			t.Errorf("GET %s: unexpected @context: %#v", path, doc["@context"])
+		}
+	}
+}
+
+func TestInlineItemsBelow(t *testing.T) {
+	tests := []struct {
+		Threshold int
+		Items     int
+		Inlined   bool
+	}{
+		{0, 0, false},
+		{0, 2, false},
+		{3, 0, true},
+		{3, 2, true},
+		{3, 3, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d items below %d", tt.Items, tt.Threshold), func(t *testing.T) {
+			h := newTestHandler(t, "alice")
+			h.InlineItemsBelow = tt.Threshold
+			for i := 0; i < tt.Items; i++ {
+				actor := fmt.Sprintf("https://remote.example/users/%d", i)
+				h.Followers.Add("alice", actor)
+				h.Following.Add("alice", actor)
+				h.Posts.Add(&Post{Username: "alice", Content: fmt.Sprint(i), Published: time.Now()})
+			}
+
+			for _, name := range []string{"outbox", "followers", "following"} {
+				collection := getJSON(t, h, "/@alice/"+name)
+				if collection["totalItems"] != float64(tt.Items) {
+					t.Errorf("%s: expected %d totalItems but got %v", name, tt.Items, collection["totalItems"])
+				}
+
+				items, inlined := collection["orderedItems"].([]any)
+				_, paged := collection["first"]
+				if inlined != tt.Inlined || paged == tt.Inlined {
+					t.Errorf("%s: unexpected form: %v", name, collection)
+					continue
+				}
+				if inlined && len(items) != tt.Items {
+					t.Errorf("%s: expected %d items but got %d", name, tt.Items, len(items))
+				}
+			}
+		})
+	}
+}
+
+func TestHideNetwork(t *testing.T) {
+	h := newTestHandler(t)
+	h.Users = []*User{{Name: "alice"}, {Name: "bob", HideNetwork: HideNetworkCount}, {Name: "carol", HideNetwork: HideNetworkForbidden}}
+	for _, u := range h.Users {
+		h.Followers.Add(u.Name, testRemoteActor)
+		h.Following.Add(u.Name, testRemoteActor)
+	}
+
+	for _, name := range []string{"followers", "following"} {
+		t.Run(name, func(t *testing.T) {
+			shown := getJSON(t, h, "/@alice/"+name)
+			if _, ok := shown["first"]; !ok {
+				t.Errorf("the collection of alice is hidden: %v", shown)
+			}
+
+			hidden := getJSON(t, h, "/@bob/"+name)
+			if hidden["id"] != h.userURL("bob")+"/"+name || hidden["type"] != "OrderedCollection" || hidden["totalItems"] != float64(1) {
+				t.Errorf("the hidden collection is not well-formed: %v", hidden)
+			}
+			for _, key := range []string{"first", "last", "orderedItems"} {
+				if _, ok := hidden[key]; ok {
+					t.Errorf("the hidden collection has %s: %v", key, hidden)
+				}
+			}
+			page := getJSON(t, h, "/@bob/"+name+"?page=0")
+			if _, ok := page["orderedItems"]; ok {
+				t.Errorf("a page of the hidden collection is served: %v", page)
+			}
+
+			req := httptest.NewRequest("GET", "/@carol/"+name, nil)
+			req.Header.Set("Accept", "application/activity+json")
+			if rec := serve(h, req); rec.Code != 403 {
+				t.Errorf("expected 403 but got %d", rec.Code)
+			}
+		})
+	}
+}
+
+func TestHideCounts(t *testing.T) {
+	h := newTestHandler(t)
+	h.Users = []*User{{Name: "alice"}, {Name: "bob", HideCounts: true}}
+	for _, u := range h.Users {
+		h.Followers.Add(u.Name, testRemoteActor)
+		h.Following.Add(u.Name, testRemoteActor)
+	}
+
+	for _, name := range []string{"followers", "following"} {
+		t.Run(name, func(t *testing.T) {
+			if shown := getJSON(t, h, "/@alice/"+name); shown["totalItems"] != float64(1) {
+				t.Errorf("the count of alice is not shown: %v", shown)
+			}
+
+			collection := getJSON(t, h, "/@bob/"+name)
+			if _, ok := collection["totalItems"]; ok {
+				t.Errorf("the collection of bob has totalItems: %v", collection)
+			}
+			first, _ := collection["first"].(string)
+			if first == "" {
+				t.Fatalf("the collection of bob has no first page: %v", collection)
+			}
+
+			page := getJSON(t, h, strings.TrimPrefix(first, h.baseURL()))
+			if items, _ := page["orderedItems"].([]any); len(items) != 1 || items[0] != testRemoteActor {
+				t.Errorf("unexpected items of bob: %v", page["orderedItems"])
+			}
+		})
+	}
+}
+
+func TestLoadConfig_hideNetwork(t *testing.T) {
+	tests := []struct {
+		HideNetwork string
+		OK          bool
+	}{
+		{"", true},
+		{HideNetworkCount, true},
+		{HideNetworkForbidden, true},
+		{"hidden", false},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "config.json")
+		conf := fmt.Sprintf(`{"hosts": [{"hostname": "local.example", "users": [{"name": "alice", "hideNetwork": %q}]}]}`, tt.HideNetwork)
+		if err := os.WriteFile(path, []byte(conf), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := loadConfig(path); (err == nil) != tt.OK {
+			t.Errorf("hideNetwork %q: unexpected result: %v", tt.HideNetwork, err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import "fmt"
+
+// Compactor rewrites an outgoing ActivityStreams document before it is served.
+type Compactor interface {
+	Compact(doc any) (any, error)
+}
+
+// CompactorFunc adapts a function to Compactor.
+type CompactorFunc func(doc any) (any, error)
+
+func (f CompactorFunc) Compact(doc any) (any, error) {
+	return f(doc)
+}
+
+// compactors are the Compactors selectable by JSONLD_COMPACTION. The empty name serves the hand-built documents as is.
+// Implementations with extra dependencies register themselves from files behind build tags.
+var compactors = map[string]Compactor{
+	"":     nil,
+	"none": nil,
+}
+
+// lookupCompactor finds the Compactor by name.
+func lookupCompactor(name string) (Compactor, error) {
+	c, ok := compactors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown compaction %q; it may need a build tag such as -tags jsonld", name)
+	}
+	return c, nil
+}
@@ -0,0 +1,40 @@
+//go:build jsonld
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/piprate/json-gold/ld"
+)
+
+// This file is built only with `go build -tags jsonld`, which is needed to use JSONLD_COMPACTION=jsonld,
+// so that the default build does not link github.com/piprate/json-gold.
+
+// jsonLDDocumentLoader loads the remote contexts referred by the documents.
+var jsonLDDocumentLoader ld.DocumentLoader = ld.NewDefaultDocumentLoader(nil)
+
+func init() {
+	compactors["jsonld"] = CompactorFunc(compactJSONLD)
+}
+
+// compactJSONLD compacts the document with its own @context, so that the property names and their forms are the ones that the context declares.
+func compactJSONLD(doc any) (any, error) {
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+
+	context, ok := m["@context"]
+	if !ok {
+		return m, nil
+	}
+
+	options := ld.NewJsonLdOptions("")
+	options.DocumentLoader = jsonLDDocumentLoader
+	return ld.NewJsonLdProcessor().Compact(m, map[string]any{"@context": context}, options)
+}
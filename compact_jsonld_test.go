@@ -0,0 +1,80 @@
+//go:build jsonld
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/piprate/json-gold/ld"
+)
+
+// testContexts are the parts of the remote contexts that the actor uses, so that the test does not fetch them.
+var testContexts = map[string]string{
+	ActivityStreamsContext: `{"@context": {
+		"as": "https://www.w3.org/ns/activitystreams#",
+		"ldp": "http://www.w3.org/ns/ldp#",
+		"xsd": "http://www.w3.org/2001/XMLSchema#",
+		"id": "@id",
+		"type": "@type",
+		"Person": "as:Person",
+		"Image": "as:Image",
+		"name": "as:name",
+		"preferredUsername": "as:preferredUsername",
+		"summary": "as:summary",
+		"mediaType": "as:mediaType",
+		"published": {"@id": "as:published", "@type": "xsd:dateTime"},
+		"icon": {"@id": "as:icon", "@type": "@id"},
+		"image": {"@id": "as:image", "@type": "@id"},
+		"url": {"@id": "as:url", "@type": "@id"},
+		"inbox": {"@id": "ldp:inbox", "@type": "@id"},
+		"outbox": {"@id": "as:outbox", "@type": "@id"},
+		"followers": {"@id": "as:followers", "@type": "@id"},
+		"following": {"@id": "as:following", "@type": "@id"},
+		"endpoints": {"@id": "as:endpoints", "@type": "@id"},
+		"sharedInbox": {"@id": "as:sharedInbox", "@type": "@id"}
+	}}`,
+	SecurityContext: `{"@context": {
+		"id": "@id",
+		"type": "@type",
+		"sec": "https://w3id.org/security#",
+		"publicKey": {"@id": "sec:publicKey", "@type": "@id"},
+		"owner": {"@id": "sec:owner", "@type": "@id"},
+		"publicKeyPem": "sec:publicKeyPem"
+	}}`,
+}
+
+func TestCompactJSONLD_actor(t *testing.T) {
+	loader := ld.NewCachingDocumentLoader(ld.NewDefaultDocumentLoader(nil))
+	for u, doc := range testContexts {
+		var v any
+		if err := json.Unmarshal([]byte(doc), &v); err != nil {
+			t.Fatalf("%s: %s", u, err)
+		}
+		loader.AddDocument(u, v)
+	}
+	defer func(l ld.DocumentLoader) { jsonLDDocumentLoader = l }(jsonLDDocumentLoader)
+	jsonLDDocumentLoader = loader
+
+	h := newTestHandler(t, "alice")
+	raw, err := h.userActor("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := compactJSONLD(raw)
+	if err != nil {
+		t.Fatalf("failed to compact: %s", err)
+	}
+	compacted := doc.(map[string]any)
+
+	var expected map[string]any
+	b, _ := json.Marshal(raw)
+	json.Unmarshal(b, &expected)
+
+	for _, key := range []string{"@context", "id", "type", "preferredUsername", "inbox", "outbox", "followers", "following", "endpoints", "publicKey", "icon"} {
+		if !reflect.DeepEqual(compacted[key], expected[key]) {
+			t.Errorf("%s changed by compaction:\nraw:       %v\ncompacted: %v", key, expected[key], compacted[key])
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+func TestLookupCompactor(t *testing.T) {
+	for _, name := range []string{"", "none"} {
+		c, err := lookupCompactor(name)
+		if err != nil || c != nil {
+			t.Errorf("%q: expected no compaction but got %v, %v", name, c, err)
+		}
+	}
+
+	if _, err := lookupCompactor("unknown"); err == nil {
+		t.Errorf("unknown compaction is accepted")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHostname is used when no config file is given.
+const DefaultHostname = "oxyfern.blanktar.jp"
+
+// Config is the content of the file specified by the CONFIG environment variable.
+type Config struct {
+	// Hosts are the virtual hosts served by this instance. Each host has its own users and stores.
+	Hosts []HostConfig `json:"hosts"`
+}
+
+type HostConfig struct {
+	Hostname string  `json:"hostname"`
+	Users    []*User `json:"users"`
+
+	// NodeInfoMetadata is served as the metadata of NodeInfo. NODEINFO_METADATA is used if empty.
+	NodeInfoMetadata map[string]json.RawMessage `json:"nodeinfoMetadata"`
+}
+
+// loadConfig reads the config file. If path is empty, a single host that accepts any username is used.
+func loadConfig(path string) (*Config, error) {
+	if path == "" {
+		return &Config{
+			Hosts: []HostConfig{{Hostname: DefaultHostname}},
+		}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var conf Config
+	if err := json.NewDecoder(f).Decode(&conf); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	if len(conf.Hosts) == 0 {
+		return nil, errors.New("no hosts are configured")
+	}
+	seen := make(map[string]bool)
+	for _, host := range conf.Hosts {
+		if host.Hostname == "" {
+			return nil, errors.New("hostname is required for each host")
+		}
+		if seen[host.Hostname] {
+			return nil, fmt.Errorf("hostname is duplicated: %s", host.Hostname)
+		}
+		seen[host.Hostname] = true
+
+		for _, u := range host.Users {
+			switch u.HideNetwork {
+			case "", HideNetworkCount, HideNetworkForbidden:
+			default:
+				return nil, fmt.Errorf("hideNetwork of %s must be %q or %q: %q", u.Name, HideNetworkCount, HideNetworkForbidden, u.HideNetwork)
+			}
+
+			if u.Type != "" && !validActorType(u.Type) {
+				return nil, fmt.Errorf("type of %s must be one of %s: %q", u.Name, strings.Join(ActorTypes, ", "), u.Type)
+			}
+		}
+	}
+
+	return &conf, nil
+}
+
+// newHandler makes a Handler for the host, with the options from the environment variables.
+func newHandler(host HostConfig) (*Handler, error) {
+	h := &Handler{
+		Hostname:    host.Hostname,
+		Users:       host.Users,
+		HeaderImage: os.Getenv("HEADER_IMAGE"),
+		AdminToken:  os.Getenv("ADMIN_TOKEN"),
+
+		SubscribeTemplate: os.Getenv("SUBSCRIBE_TEMPLATE"),
+		WebhookURL:        os.Getenv("WEBHOOK_URL"),
+		Language:          os.Getenv("DEFAULT_LANGUAGE"),
+		RootRedirect:      os.Getenv("ROOT_REDIRECT"),
+
+		Client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+
+		Debug:             os.Getenv("DEBUG") != "",
+		DebugSignatures:   os.Getenv("DEBUG_SIGNATURES") != "",
+		InlineFirstPage:   os.Getenv("INLINE_FIRST_PAGE") != "",
+		StrictAccept:      os.Getenv("STRICT_ACCEPT") != "",
+		StrictContentType: os.Getenv("STRICT_CONTENT_TYPE") != "",
+		Multikey:          os.Getenv("MULTIKEY") != "",
+		UsersPath:         os.Getenv("ACTOR_PATH") == "users",
+		PrettyJSON:        os.Getenv("PRETTY_JSON") != "",
+		CountRunes:        os.Getenv("COUNT_RUNES") != "",
+
+		Retry: DefaultRetryPolicy,
+	}
+	if n, err := strconv.Atoi(os.Getenv("PAGE_SIZE")); err == nil {
+		h.PageSize = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("INLINE_ITEMS_BELOW")); err == nil {
+		h.InlineItemsBelow = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("MAX_CONTENT_LENGTH")); err == nil {
+		h.MaxContentLength = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("DELAY_MIN")); err == nil {
+		h.DelayMin = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("DELAY_MAX")); err == nil {
+		h.DelayMax = d
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("CACHE_MAX_AGE")); err == nil {
+		h.CacheMaxAge = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("KEY_ROTATION_MAX_AGE")); err == nil {
+		h.KeyRotationMaxAge = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("KEY_ROTATION_WINDOW")); err == nil {
+		h.KeyRotationWindow = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("RECEIPT_RETENTION")); err == nil {
+		h.Receipts.Retention = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("REACHABILITY_INTERVAL")); err == nil {
+		h.ReachabilityInterval = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("PRUNE_UNREACHABLE_AFTER")); err == nil {
+		h.PruneUnreachableAfter = n
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("RETRY_BASE")); err == nil {
+		h.Retry.Base = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("RETRY_MAX")); err == nil {
+		h.Retry.Max = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("RETRY_ATTEMPTS")); err == nil {
+		h.Retry.MaxAttempts = n
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("RETRY_JITTER"), 64); err == nil {
+		h.Retry.Jitter = f
+	}
+	if n, err := strconv.Atoi(os.Getenv("DELIVERY_WORKERS")); err == nil {
+		h.DeliveryWorkers = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("FANOUT_BATCH_SIZE")); err == nil {
+		h.FanOutBatchSize = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("FETCH_BUDGET")); err == nil {
+		h.FetchBudget = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("FETCH_MAX_DEPTH")); err == nil {
+		h.FetchMaxDepth = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("FETCH_MAX_REDIRECTS")); err == nil {
+		h.FetchMaxRedirects = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("MAX_JSON_DEPTH")); err == nil {
+		h.MaxJSONDepth = n
+	}
+	if n, err := strconv.ParseInt(os.Getenv("MAX_BODY_SIZE"), 10, 64); err == nil {
+		h.MaxBodySize = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("ACTOR_CACHE_TTL")); err == nil {
+		h.ActorCacheTTL = d
+	}
+
+	if keyPEM := os.Getenv("PRIVATE_KEY_PEM"); keyPEM != "" {
+		keys, err := NewStaticKeyStore(keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("PRIVATE_KEY_PEM: %w", err)
+		}
+		h.Keys = keys
+	} else {
+		dir := os.Getenv("KEYS_DIR")
+		if dir == "" {
+			dir = "keys"
+		}
+		// Unknown usernames are accepted if no users are configured, so they share one key instead of generating their own.
+		h.Keys = &FileKeyStore{Dir: filepath.Join(dir, host.Hostname), Shared: len(host.Users) == 0}
+
+		// The keys directory is the persistent state of the host, so the pending follow requests are saved next to the keys.
+		h.PendingFollows.Path = filepath.Join(dir, host.Hostname, "pending-follows.json")
+		if err := h.PendingFollows.Load(); err != nil {
+			return nil, fmt.Errorf("failed to load the pending follow requests: %w", err)
+		}
+	}
+
+	if types := os.Getenv("ACKNOWLEDGED_TYPES"); types != "" {
+		h.AcknowledgedTypes = []string{}
+		for _, t := range strings.Split(types, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				h.AcknowledgedTypes = append(h.AcknowledgedTypes, t)
+			}
+		}
+	}
+
+	h.NodeInfoMetadata = host.NodeInfoMetadata
+	if raw := os.Getenv("NODEINFO_METADATA"); raw != "" && len(h.NodeInfoMetadata) == 0 {
+		if err := json.Unmarshal([]byte(raw), &h.NodeInfoMetadata); err != nil {
+			return nil, fmt.Errorf("NODEINFO_METADATA must be a JSON object: %w", err)
+		}
+	}
+	limits, err := parseReceivedLimits(os.Getenv("RECEIVED_LIMITS"))
+	if err != nil {
+		return nil, fmt.Errorf("RECEIVED_LIMITS: %w", err)
+	}
+	h.Notes.Limit = limits["Note"]
+	h.DirectMessages.Limit = limits["Note"]
+	h.Reactions.Limits = limits
+
+	if err := validateNodeInfoMetadata(h.NodeInfoMetadata); err != nil {
+		return nil, fmt.Errorf("nodeinfo metadata of %s: %w", h.Hostname, err)
+	}
+
+	if cidrs := os.Getenv("INSECURE_SKIP_VERIFY_FROM"); cidrs != "" {
+		nets, err := parseCIDRList(cidrs)
+		if err != nil {
+			return nil, fmt.Errorf("INSECURE_SKIP_VERIFY_FROM: %w", err)
+		}
+		h.InsecureSkipVerifyFrom = nets
+		log.Printf("WARNING: signature verification is skipped for requests from %s. Never use this in production.", cidrs)
+	}
+
+	policy, err := lookupFollowPolicy(os.Getenv("FOLLOW_POLICY"))
+	if err != nil {
+		return nil, fmt.Errorf("FOLLOW_POLICY: %w", err)
+	}
+	h.FollowPolicy = policy
+
+	switch h.SuspendedActor = os.Getenv("SUSPENDED_ACTOR"); h.SuspendedActor {
+	case "", SuspendedActorMinimal, SuspendedActorForbidden:
+	default:
+		return nil, fmt.Errorf("SUSPENDED_ACTOR must be %q or %q: %q", SuspendedActorMinimal, SuspendedActorForbidden, h.SuspendedActor)
+	}
+
+	switch h.SelfFollow = os.Getenv("SELF_FOLLOW"); h.SelfFollow {
+	case "", SelfFollowReject, SelfFollowError, SelfFollowAccept:
+	default:
+		return nil, fmt.Errorf("SELF_FOLLOW must be %q, %q or %q: %q", SelfFollowReject, SelfFollowError, SelfFollowAccept, h.SelfFollow)
+	}
+	h.FollowMoves = os.Getenv("FOLLOW_MOVES") != ""
+	h.FeaturedTags = os.Getenv("FEATURED_TAGS") != ""
+
+	canonicalizer, err := lookupCanonicalizer(os.Getenv("DIGEST_CANONICALIZATION"))
+	if err != nil {
+		return nil, fmt.Errorf("DIGEST_CANONICALIZATION: %w", err)
+	}
+	h.Canonicalizer = canonicalizer
+
+	if s := os.Getenv("SIGNED_HEADERS"); s != "" {
+		headers, err := parseSignedHeaders(s)
+		if err != nil {
+			return nil, fmt.Errorf("SIGNED_HEADERS: %w", err)
+		}
+		h.SignedHeaders = headers
+	}
+
+	switch h.SignatureFormat = os.Getenv("SIGNATURE_FORMAT"); h.SignatureFormat {
+	case "", SignatureFormatCavage, SignatureFormatRFC9421:
+	default:
+		return nil, fmt.Errorf("SIGNATURE_FORMAT must be %q or %q: %q", SignatureFormatCavage, SignatureFormatRFC9421, h.SignatureFormat)
+	}
+
+	compactor, err := lookupCompactor(os.Getenv("JSONLD_COMPACTION"))
+	if err != nil {
+		return nil, fmt.Errorf("JSONLD_COMPACTION: %w", err)
+	}
+	h.Compactor = compactor
+
+	h.AllowedDomains = parseDomainList(os.Getenv("ALLOWED_DOMAINS"))
+	h.BlockedDomains = parseDomainList(os.Getenv("BLOCKED_DOMAINS"))
+	if len(h.AllowedDomains) > 0 && len(h.BlockedDomains) > 0 {
+		return nil, errors.New("ALLOWED_DOMAINS and BLOCKED_DOMAINS cannot be set at the same time")
+	}
+
+	return h, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+func (h *Handler) GetDebugNotes(c echo.Context) error {
+	return c.JSON(200, h.Notes.List())
+}
+
+// DefaultTimelineLimit and MaxTimelineLimit are the default and the maximum of the limit parameter of /debug/timeline.
+const (
+	DefaultTimelineLimit = 20
+	MaxTimelineLimit     = 200
+)
+
+// GetDebugTimeline lists the received public notes, newest first.
+func (h *Handler) GetDebugTimeline(c echo.Context) error {
+	limit := DefaultTimelineLimit
+	if s := c.QueryParam("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return c.JSON(400, map[string]string{
+				"error": "invalid limit",
+			})
+		}
+		limit = n
+	}
+	if limit > MaxTimelineLimit {
+		limit = MaxTimelineLimit
+	}
+
+	return c.JSON(200, h.Notes.Timeline(limit))
+}
+
+// GetDebugReactions lists the received Likes and Announces, grouped by the object id.
+func (h *Handler) GetDebugReactions(c echo.Context) error {
+	return c.JSON(200, h.Reactions.List())
+}
+
+// GetDebugReactionCounts counts the received reactions of each object, with the emoji reactions counted by the emoji.
+func (h *Handler) GetDebugReactionCounts(c echo.Context) error {
+	return c.JSON(200, h.Reactions.Counts())
+}
+
+// GetDebugDirectMessages lists the direct messages received by the user.
+func (h *Handler) GetDebugDirectMessages(c echo.Context) error {
+	return c.JSON(200, h.DirectMessages.List(c.Param("username")))
+}
+
+// GetDebugKeys lists the key id and the fingerprint of the public key of each known user, to confirm which key is live after rotation and when the rotation was noticed.
+func (h *Handler) GetDebugKeys(c echo.Context) error {
+	type key struct {
+		Username    string     `json:"username"`
+		KeyID       string     `json:"keyId"`
+		Fingerprint string     `json:"fingerprint,omitempty"`
+		RotatedAt   *time.Time `json:"rotatedAt,omitempty"`
+		Error       string     `json:"error,omitempty"`
+	}
+
+	keys := []key{}
+	for _, u := range h.knownUsers() {
+		k := key{
+			Username: u.Name,
+			KeyID:    h.userURL(u.Name) + "#main-key",
+		}
+		if pub, err := h.Keys.PublicKeyPEM(u.Name); err != nil {
+			k.Error = err.Error()
+		} else if k.Fingerprint, err = keyFingerprint(pub); err != nil {
+			k.Error = err.Error()
+		} else {
+			h.KeyRotations.observe(u.Name, pub, time.Now())
+		}
+		if t, ok := h.KeyRotations.RotatedAt(u.Name); ok {
+			k.RotatedAt = &t
+		}
+		keys = append(keys, k)
+	}
+	return c.JSON(200, keys)
+}
+
+// keyFingerprint returns the SHA-256 of the DER of a PEM public key, in colon separated hex such as "AB:CD:...".
+func keyFingerprint(publicKeyPEM string) (string, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return "", errors.New("no PEM block in the public key")
+	}
+
+	sum := sha256.Sum256(block.Bytes)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":"), nil
+}
+
+// GetDebugQueue lists the pending, in-flight and retrying deliveries, and the recently dead-lettered ones with their last error.
+func (h *Handler) GetDebugQueue(c echo.Context) error {
+	return c.JSON(200, h.Deliveries.List())
+}
+
+// GetDebugReachability lists the followers that are failing the reachability check, and the recently pruned ones.
+func (h *Handler) GetDebugReachability(c echo.Context) error {
+	failing, pruned := h.Reachability.List()
+	return c.JSON(200, map[string]any{
+		"failing": failing,
+		"pruned":  pruned,
+	})
+}
+
+// PostDebugParse reports how the inbox would interpret the posted activity, without processing it.
+func (h *Handler) PostDebugParse(c echo.Context) error {
+	activity, raw, err := readActivity(c.Request(), h.maxJSONDepth(), h.maxBodySize())
+	if errors.Is(err, errBodyTooLarge) {
+		return c.JSON(413, map[string]string{
+			"error": err.Error(),
+		})
+	} else if err != nil {
+		return c.JSON(400, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	signature := map[string]any{
+		"verified": true,
+	}
+	key, err := h.verifyRequest(c.Request(), raw)
+	if err == nil {
+		err = checkKeyOwner(activity.Actor, key)
+	}
+	if err != nil {
+		signature["verified"] = false
+		signature["error"] = err.Error()
+		signature["code"] = signatureErrorCode(err)
+	}
+	if key.ID != "" {
+		signature["keyId"] = key.ID
+		signature["headers"] = key.Headers
+	}
+	if err != nil && h.DebugSignatures {
+		signature["signingString"] = key.SigningString
+	}
+
+	return c.JSON(200, map[string]any{
+		"type":  activity.Type,
+		"actor": activity.Actor,
+		"object": map[string]any{
+			"id":   activity.Object.ID,
+			"type": activity.Object.Type(),
+		},
+		"to":        activity.To,
+		"cc":        activity.Cc,
+		"public":    isPublic(append(activity.To, activity.Cc...)),
+		"signature": signature,
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGetDebugKeys(t *testing.T) {
+	h := newTestHandler(t, "alice", "bob")
+	h.Debug = true
+	h.AdminToken = "secret"
+
+	der, err := x509.MarshalPKIXPublicKey(&testKey(t).PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.ReplaceAll(fmt.Sprintf("% X", sha256.Sum256(der)), " ", ":")
+
+	req := httptest.NewRequest("GET", "/debug/keys", nil)
+	if rec := serve(h, req); rec.Code != 401 {
+		t.Errorf("expected 401 without the token but got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/debug/keys", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := serve(h, req)
+	if rec.Code != 200 {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "PRIVATE") {
+		t.Fatalf("private key is exposed: %s", rec.Body)
+	}
+
+	var keys []map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys but got %v", keys)
+	}
+	for _, k := range keys {
+		if k["keyId"] != h.userURL(k["username"])+"#main-key" {
+			t.Errorf("unexpected keyId of %s: %s", k["username"], k["keyId"])
+		}
+		if k["fingerprint"] != want {
+			t.Errorf("expected fingerprint %s but got %s", want, k["fingerprint"])
+		}
+	}
+}
+
+func TestKeyFingerprint(t *testing.T) {
+	publicKey, err := encodePublicKeyPEM(&testKey(t).PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprint, err := keyFingerprint(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[0-9A-F]{2}(:[0-9A-F]{2}){31}$`).MatchString(fingerprint) {
+		t.Errorf("unexpected format: %s", fingerprint)
+	}
+
+	if _, err := keyFingerprint("not a key"); err == nil {
+		t.Error("expected an error for a non-PEM key")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// deliver posts an activity to a remote inbox, signed by the local user.
+func (h *Handler) deliver(username, inbox string, activity map[string]any) error {
+	_, err := h.deliverWithStatus(username, inbox, activity)
+	return err
+}
+
+// deliverWithStatus is deliver that also returns the HTTP status of the response, or zero if there is no response.
+func (h *Handler) deliverWithStatus(username, inbox string, activity map[string]any) (int, error) {
+	if h.isSuspended(username) {
+		return 0, errUserSuspended
+	}
+
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := h.newSignedRequest(username, inbox, body)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	resp, err := h.client().Do(req)
+	if err != nil {
+		log.Printf("delivery of %s to %s failed in %s", activity["id"], inbox, time.Since(start))
+		return 0, err
+	}
+	defer resp.Body.Close()
+	log.Printf("delivery of %s to %s took %s with status %d", activity["id"], inbox, time.Since(start), resp.StatusCode)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// newSignedRequest builds a POST of the activity body to the inbox, signed by the local user in the same way as deliveries.
+func (h *Handler) newSignedRequest(username, inbox string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest("POST", inbox, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/activity+json")
+
+	key, err := h.Keys.PrivateKey(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key: %w", err)
+	}
+	digested, err := h.canonicalizer().Canonicalize(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to canonicalize: %w", err)
+	}
+	sign := signRequest
+	if h.SignatureFormat == SignatureFormatRFC9421 {
+		sign = signRequestRFC9421
+	}
+	if err := sign(req, h.userURL(username)+"#main-key", key, digested, h.SignedHeaders); err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	return req, nil
+}
+
+// remoteActor is the part of a remote actor document that is needed for delivery, and for following its Move.
+type remoteActor struct {
+	ID        string `json:"id"`
+	Inbox     string `json:"inbox"`
+	Endpoints struct {
+		SharedInbox string `json:"sharedInbox"`
+	} `json:"endpoints"`
+
+	AlsoKnownAs []string `json:"alsoKnownAs"`
+}
+
+// fetchActor fetches a remote actor document.
+func (h *Handler) fetchActor(ctx context.Context, id string) (*remoteActor, error) {
+	var actor remoteActor
+	if err := h.fetchJSON(ctx, id, "application/activity+json", &actor); err != nil {
+		return nil, err
+	}
+	if actor.Inbox == "" {
+		return nil, fmt.Errorf("actor has no inbox: %s", id)
+	}
+	return &actor, nil
+}
+
+// resolveInbox returns the inbox of a remote actor, and the id in the fetched actor document.
+// The id may differ from the given one if it was an alias or not canonical, and remote servers refer to the actor by the returned id.
+func (h *Handler) resolveInbox(ctx context.Context, actor string) (inbox, id string, err error) {
+	a, err := h.actorResolver().ResolveActor(ctx, actor)
+	if err != nil {
+		return "", "", err
+	}
+	if a.ID == "" {
+		return a.Inbox, actor, nil
+	}
+	return a.Inbox, a.ID, nil
+}
+
+// fanOut queues an activity of the user for delivery to everyone in its to and cc.
+// At most FanOutBatchSize deliveries of the activity are in flight at once; the rest wait until the earlier ones finish their first attempt.
+// Retries are not counted, because they are spread out by the backoff.
+func (h *Handler) fanOut(username string, activity map[string]any) {
+	if h.isSuspended(username) {
+		log.Printf("%s is not delivered: %s is suspended", activity["id"], username)
+		return
+	}
+
+	inboxes, err := h.resolveDeliveryTargets(context.Background(), username, activity)
+	if err != nil {
+		log.Printf("some recipients of %s are skipped: %s", activity["id"], err)
+	}
+
+	inFlight := make(chan struct{}, h.fanOutBatchSize())
+	for _, inbox := range inboxes {
+		inFlight <- struct{}{}
+		h.enqueue(username, inbox, activity, func() {
+			<-inFlight
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// runDump writes the documents of a user into files, using the same rendering code as the HTTP handlers.
+func runDump(conf *Config, args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	username := fs.String("user", "", "username to dump")
+	hostname := fs.String("host", conf.Hosts[0].Hostname, "configured hostname to render")
+	dir := fs.String("dir", ".", "directory to write files into")
+	fs.Parse(args)
+
+	if *username == "" {
+		return errors.New("-user is required")
+	}
+
+	var h *Handler
+	for _, host := range conf.Hosts {
+		if host.Hostname == *hostname {
+			var err error
+			if h, err = newHandler(host); err != nil {
+				return err
+			}
+		}
+	}
+	if h == nil {
+		return fmt.Errorf("host is not configured: %s", *hostname)
+	}
+	user, ok := h.lookupUser(*username)
+	if !ok {
+		return fmt.Errorf("user is not found: %s", *username)
+	}
+	*username = user.Name
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return err
+	}
+
+	actor, err := h.userActor(*username)
+	if err != nil {
+		return err
+	}
+
+	docs := map[string]map[string]any{
+		"actor.json":           actor,
+		"outbox.json":          h.outboxCollection(*username, ""),
+		"outbox.page0.json":    withContext(h.outboxPage(*username, "", 0)),
+		"followers.json":       h.followersCollection(*username),
+		"followers.page0.json": withContext(h.followersPage(*username, 0)),
+		"following.json":       h.followingCollection(*username),
+		"following.page0.json": withContext(h.followingPage(*username, 0)),
+	}
+	if user.HideNetwork != "" {
+		delete(docs, "followers.page0.json")
+		delete(docs, "following.page0.json")
+	}
+
+	for name, doc := range docs {
+		if err := writeJSONFile(filepath.Join(*dir, name), doc); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func writeJSONFile(path string, v any) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
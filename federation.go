@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// hostOf returns the lower-cased hostname of an id, or an empty string if it is not an absolute URL.
+func hostOf(id string) string {
+	u, err := url.Parse(id)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// matchDomain reports whether host is one of the domains or a subdomain of them.
+func matchDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDomainList parses a comma separated list of domains.
+func parseDomainList(s string) []string {
+	var domains []string
+	for _, d := range strings.Split(s, ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// federationAllowed reports whether the instance of the actor may deliver to the inbox.
+func (h *Handler) federationAllowed(actor string) bool {
+	host := hostOf(actor)
+	if host == "" {
+		return false
+	}
+	if len(h.AllowedDomains) > 0 {
+		return matchDomain(host, h.AllowedDomains)
+	}
+	return !matchDomain(host, h.BlockedDomains)
+}
+
+// parseCIDRList parses a comma separated list of CIDRs. A bare IP address is treated as a single address.
+func parseCIDRList(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, x := range strings.Split(s, ",") {
+		x = strings.TrimSpace(x)
+		if x == "" {
+			continue
+		}
+		if !strings.Contains(x, "/") {
+			ip := net.ParseIP(x)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %q", x)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(x)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// insecureSkipVerify reports whether the request comes directly from InsecureSkipVerifyFrom.
+// It looks at the peer address only, not at X-Forwarded-For, so that the header cannot be used to bypass verification.
+func (h *Handler) insecureSkipVerify(r *http.Request) bool {
+	if len(h.InsecureSkipVerifyFrom) == 0 {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range h.InsecureSkipVerifyFrom {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
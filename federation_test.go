@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseCIDRList(t *testing.T) {
+	nets, err := parseCIDRList(" 127.0.0.1, 10.0.0.0/8,::1 ,")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(nets) != 3 || nets[0].String() != "127.0.0.1/32" || nets[1].String() != "10.0.0.0/8" || nets[2].String() != "::1/128" {
+		t.Errorf("unexpected networks: %v", nets)
+	}
+
+	for _, s := range []string{"localhost", "10.0.0.0/33", "1.2.3"} {
+		if _, err := parseCIDRList(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestNewHandler_insecureSkipVerifyDisabledByDefault(t *testing.T) {
+	t.Setenv("INSECURE_SKIP_VERIFY_FROM", "")
+	t.Setenv("KEYS_DIR", t.TempDir())
+
+	h, err := newHandler(HostConfig{Hostname: "local.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.InsecureSkipVerifyFrom != nil {
+		t.Fatalf("verification is skipped by default: %v", h.InsecureSkipVerifyFrom)
+	}
+
+	req := httptest.NewRequest("POST", "/inbox", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	if h.insecureSkipVerify(req) {
+		t.Error("verification is skipped for loopback by default")
+	}
+}
+
+func TestPostInbox_insecureSkipVerify(t *testing.T) {
+	body := `{"@context":"https://www.w3.org/ns/activitystreams","id":"https://remote.example/listens/1","type":"Listen","actor":"https://remote.example/users/carol"}`
+
+	tests := []struct {
+		Name      string
+		From      string
+		Remote    string
+		Forwarded string
+		Code      int
+	}{
+		{"disabled", "", "127.0.0.1:1234", "", 401},
+		{"allowed", "127.0.0.0/8", "127.0.0.1:1234", "", 202},
+		{"other address", "127.0.0.0/8", "192.0.2.1:1234", "", 401},
+		{"forwarded for an allowed address", "127.0.0.0/8", "192.0.2.1:1234", "127.0.0.1", 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := newTestHandler(t, "alice")
+			if tt.From != "" {
+				nets, err := parseCIDRList(tt.From)
+				if err != nil {
+					t.Fatal(err)
+				}
+				h.InsecureSkipVerifyFrom = nets
+			}
+
+			req := httptest.NewRequest("POST", "/@alice/inbox", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/activity+json")
+			req.RemoteAddr = tt.Remote
+			if tt.Forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.Forwarded)
+			}
+
+			rec, logs := serveWithLog(h, req)
+			if rec.Code != tt.Code {
+				t.Fatalf("expected %d but got %d: %s", tt.Code, rec.Code, rec.Body)
+			}
+			if unverified := strings.Contains(logs, "UNVERIFIED"); unverified != (tt.Code == 202) {
+				t.Errorf("unexpected log: %s", logs)
+			}
+		})
+	}
+}
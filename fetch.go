@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultFetchBudget, DefaultFetchMaxDepth and DefaultFetchMaxRedirects are used when the fields of Handler are zero.
+const (
+	DefaultFetchBudget       = 10
+	DefaultFetchMaxDepth     = 3
+	DefaultFetchMaxRedirects = 3
+)
+
+var (
+	errFetchBudgetExceeded = errors.New("fetch budget of the activity is exceeded")
+	errFetchTooDeep        = errors.New("object references are nested too deep")
+	errFetchCycle          = errors.New("object references itself")
+)
+
+// fetchBudget limits the number of remote fetches made while processing one inbound activity.
+type fetchBudget struct {
+	sync.Mutex
+	remaining int
+}
+
+type fetchBudgetKey struct{}
+
+// withFetchBudget attaches a new fetch budget to the context of an inbound activity.
+func (h *Handler) withFetchBudget(ctx context.Context) context.Context {
+	n := h.FetchBudget
+	if n <= 0 {
+		n = DefaultFetchBudget
+	}
+	return context.WithValue(ctx, fetchBudgetKey{}, &fetchBudget{remaining: n})
+}
+
+// spendFetch consumes one fetch from the budget of the context. Contexts without a budget are unlimited.
+func spendFetch(ctx context.Context) error {
+	b, ok := ctx.Value(fetchBudgetKey{}).(*fetchBudget)
+	if !ok {
+		return nil
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.remaining <= 0 {
+		return errFetchBudgetExceeded
+	}
+	b.remaining--
+	return nil
+}
+
+func (h *Handler) fetchMaxDepth() int {
+	if h.FetchMaxDepth > 0 {
+		return h.FetchMaxDepth
+	}
+	return DefaultFetchMaxDepth
+}
+
+func (h *Handler) fetchMaxRedirects() int {
+	if h.FetchMaxRedirects > 0 {
+		return h.FetchMaxRedirects
+	}
+	return DefaultFetchMaxRedirects
+}
+
+// fetchJSON GETs the URL and decodes the response body. It counts against the fetch budget of the context.
+// Redirects are followed up to FetchMaxRedirects times, and only within the host of the URL,
+// so that a remote cannot make us accept a document of another instance as its own.
+func (h *Handler) fetchJSON(ctx context.Context, u, accept string, v any) error {
+	if err := spendFetch(ctx); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", accept)
+
+	client := *h.client()
+	client.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+		if len(via) > h.fetchMaxRedirects() {
+			return fmt.Errorf("too many redirects from %s", u)
+		}
+		if !strings.EqualFold(next.URL.Host, req.URL.Host) {
+			return fmt.Errorf("%s redirected to another host: %s", u, next.URL)
+		}
+		return nil
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status code from %s: %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// resolveObject returns the object that v refers to, fetching it if v is a bare IRI.
+// If the object is an activity such as Create, its object is resolved as well, up to FetchMaxDepth levels.
+// The returned slice is the chain from v to the innermost object.
+func (h *Handler) resolveObject(ctx context.Context, v any) ([]map[string]any, error) {
+	var chain []map[string]any
+	seen := make(map[string]bool)
+
+	for depth := 0; ; depth++ {
+		if depth >= h.fetchMaxDepth() {
+			return chain, errFetchTooDeep
+		}
+
+		ref, err := objectOf(v)
+		if err != nil {
+			return chain, err
+		}
+		if seen[ref.ID] {
+			return chain, errFetchCycle
+		}
+		seen[ref.ID] = true
+
+		object := ref.Embedded
+		if object == nil {
+			if err := h.fetchJSON(ctx, ref.ID, "application/activity+json", &object); err != nil {
+				return chain, err
+			}
+		}
+		chain = append(chain, object)
+
+		switch typeOf(object) {
+		case "Create", "Announce", "Update":
+			v = object["object"]
+		default:
+			return chain, nil
+		}
+	}
+}
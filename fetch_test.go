@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newObjectServer serves Announces whose object is the path in the object query parameter, and counts the requests.
+// /announce/self refers to itself, and /note is a Note.
+func newObjectServer(t *testing.T, h *Handler) (*httptest.Server, *int32) {
+	var requests int32
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		id := srv.URL + r.URL.String()
+		object := map[string]any{"id": id, "type": "Note"}
+		switch {
+		case r.URL.Path == "/announce/self":
+			object = map[string]any{"id": id, "type": "Announce", "object": id}
+		case strings.HasPrefix(r.URL.Path, "/announce/"):
+			object = map[string]any{"id": id, "type": "Announce", "object": srv.URL + r.URL.Query().Get("object")}
+		case r.URL.Path == "/redirect":
+			http.Redirect(w, r, "https://other.example/note", http.StatusFound)
+			return
+		}
+		json.NewEncoder(w).Encode(object)
+	}))
+	t.Cleanup(srv.Close)
+	h.Client = srv.Client()
+	return srv, &requests
+}
+
+func TestResolveObject(t *testing.T) {
+	h := newTestHandler(t)
+	srv, requests := newObjectServer(t, h)
+
+	// nested returns the URL of an Announce of an Announce ... of the note, nested n times.
+	nested := func(n int) string {
+		path := "/note"
+		for i := 0; i < n; i++ {
+			path = "/announce/" + string(rune('a'+i)) + "?object=" + strings.ReplaceAll(path, "?", "%3F")
+		}
+		return srv.URL + path
+	}
+
+	tests := []struct {
+		Name   string
+		Object any
+		Budget int
+		Chain  int
+		Err    error
+	}{
+		{"note", srv.URL + "/note", 0, 1, nil},
+		{"announce of a note", nested(1), 0, 2, nil},
+		{"self reference", srv.URL + "/announce/self", 0, 1, errFetchCycle},
+		{"embedded self reference", map[string]any{"id": srv.URL + "/x", "type": "Announce", "object": map[string]any{"id": srv.URL + "/x", "type": "Announce"}}, 0, 1, errFetchCycle},
+		{"too deep", nested(DefaultFetchMaxDepth), 0, DefaultFetchMaxDepth, errFetchTooDeep},
+		{"over budget", nested(2), 2, 2, errFetchBudgetExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			h.FetchBudget = tt.Budget
+			before := atomic.LoadInt32(requests)
+
+			chain, err := h.resolveObject(h.withFetchBudget(context.Background()), tt.Object)
+			if !errors.Is(err, tt.Err) {
+				t.Fatalf("expected %v but got %v", tt.Err, err)
+			}
+			if len(chain) != tt.Chain {
+				t.Errorf("expected a chain of %d but got %d", tt.Chain, len(chain))
+			}
+			if n := int(atomic.LoadInt32(requests) - before); tt.Budget > 0 && n > tt.Budget {
+				t.Errorf("%d fetches exceed the budget %d", n, tt.Budget)
+			}
+		})
+	}
+}
+
+func TestFetchJSON_redirectToAnotherHost(t *testing.T) {
+	h := newTestHandler(t)
+	srv, _ := newObjectServer(t, h)
+
+	var v map[string]any
+	err := h.fetchJSON(context.Background(), srv.URL+"/redirect", "application/activity+json", &v)
+	if err == nil || !strings.Contains(err.Error(), "redirected to another host") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFetchJSON_redirect(t *testing.T) {
+	h := newTestHandler(t)
+
+	other := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"id": "https://" + r.Host + r.URL.Path, "type": "Person"})
+	}))
+	t.Cleanup(other.Close)
+
+	// /hops/N redirects to /hops/N-1, and /hops/0 is the actor. /away redirects to the other server.
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/away":
+			http.Redirect(w, r, other.URL+"/users/carol", http.StatusMovedPermanently)
+		case r.URL.Path == "/hops/0":
+			json.NewEncoder(w).Encode(map[string]any{"id": "https://" + r.Host + "/users/carol", "type": "Person"})
+		default:
+			n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hops/"))
+			http.Redirect(w, r, fmt.Sprintf("/hops/%d", n-1), http.StatusFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	h.Client = srv.Client()
+
+	tests := []struct {
+		Path  string
+		Limit int
+		Err   string
+	}{
+		{"/hops/0", 0, ""},
+		{"/hops/3", 0, ""},
+		{"/hops/4", 0, "too many redirects"},
+		{"/hops/5", 5, ""},
+		{"/hops/2", 1, "too many redirects"},
+		{"/away", 0, "redirected to another host"},
+	}
+
+	for _, tt := range tests {
+		h.FetchMaxRedirects = tt.Limit
+
+		var actor map[string]any
+		err := h.fetchJSON(context.Background(), srv.URL+tt.Path, "application/activity+json", &actor)
+		if tt.Err == "" {
+			if err != nil {
+				t.Errorf("%s with limit %d: unexpected error: %s", tt.Path, tt.Limit, err)
+			} else if actor["id"] != srv.URL+"/users/carol" {
+				t.Errorf("%s with limit %d: unexpected actor: %v", tt.Path, tt.Limit, actor)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.Err) {
+			t.Errorf("%s with limit %d: expected %q but got %v", tt.Path, tt.Limit, tt.Err, err)
+		}
+	}
+}
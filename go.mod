@@ -3,8 +3,11 @@ module github.com/macrat/activitypub-sandbox
 go 1.20
 
 require (
+	github.com/go-fed/activity v1.0.0 // only for the tests in activitystreams_test.go
 	github.com/labstack/echo v3.3.10+incompatible
 	github.com/mattn/go-sqlite3 v1.14.17
+	github.com/piprate/json-gold v0.7.0
+	golang.org/x/net v0.10.0
 )
 
 require (
@@ -12,10 +15,10 @@ require (
 	github.com/labstack/gommon v0.4.0 // indirect
 	github.com/mattn/go-colorable v0.1.11 // indirect
 	github.com/mattn/go-isatty v0.0.14 // indirect
+	github.com/pquerna/cachecontrol v0.0.0-20180517163645-1555304b9b35 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.1 // indirect
 	golang.org/x/crypto v0.12.0 // indirect
-	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/sys v0.11.0 // indirect
 	golang.org/x/text v0.12.0 // indirect
 )
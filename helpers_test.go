@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+var (
+	testKeyOnce sync.Once
+	testKeyPair *rsa.PrivateKey
+)
+
+// testKey returns a key pair shared by the tests, because generating one is slow.
+func testKey(t testing.TB) *rsa.PrivateKey {
+	t.Helper()
+
+	testKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("failed to generate key: %s", err)
+		}
+		testKeyPair = key
+	})
+	return testKeyPair
+}
+
+// testRemoteActor is the actor that owns testKey in the tests, and testRemoteKeyID is its key.
+const (
+	testRemoteActor = "https://remote.example/users/carol"
+	testRemoteKeyID = testRemoteActor + "#main-key"
+)
+
+// testLookup is a publicKeyLookup that resolves every key id to testKey owned by testRemoteActor.
+func testLookup(t testing.TB) publicKeyLookup {
+	key := testKey(t)
+	return func(ctx context.Context, keyID string) (*rsa.PublicKey, string, error) {
+		return &key.PublicKey, testRemoteActor, nil
+	}
+}
+
+// newTestHandler builds a handler of local.example with the users, which accepts any username if none are given.
+func newTestHandler(t testing.TB, users ...string) *Handler {
+	t.Helper()
+
+	h := &Handler{
+		Hostname: "local.example",
+		Keys:     &StaticKeyStore{Key: testKey(t)},
+	}
+	for _, name := range users {
+		h.Users = append(h.Users, &User{Name: name})
+	}
+	return h
+}
+
+// newSignedPost builds a POST of the body to the URL, signed by testKey as keyID with the headers.
+func newSignedPost(t testing.TB, keyID, url, body string, headers []string) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/activity+json")
+	if err := signRequest(req, keyID, testKey(t), []byte(body), headers); err != nil {
+		t.Fatalf("failed to sign: %s", err)
+	}
+	return req
+}
+
+// serve sends the request to the routes of the handler and returns the response.
+// Requests to example.com, the default of httptest.NewRequest, are sent to the host of the handler.
+func serve(h *Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec, _ := serveWithLog(h, req)
+	return rec
+}
+
+// serveWithLog is serve that also returns what the handlers logged through echo.
+func serveWithLog(h *Handler, req *http.Request) (*httptest.ResponseRecorder, string) {
+	var logs bytes.Buffer
+	e := echo.New()
+	e.Logger.SetOutput(&logs)
+	h.RegisterRoutes(e)
+
+	if req.Host == "example.com" {
+		req.Host = h.Hostname
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec, logs.String()
+}
+
+// fakeRemote is a remote instance whose actors all have testKey. It records the activities posted to their inboxes.
+type fakeRemote struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	received []map[string]any
+}
+
+// newFakeRemote starts a fakeRemote, and makes the handler trust its certificate.
+func newFakeRemote(t testing.TB, h *Handler) *fakeRemote {
+	t.Helper()
+
+	publicKey, err := encodePublicKeyPEM(&testKey(t).PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &fakeRemote{}
+	r.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		actor := r.URL + strings.TrimSuffix(req.URL.Path, "/inbox")
+
+		if req.Method == "POST" && strings.HasSuffix(req.URL.Path, "/inbox") {
+			var activity map[string]any
+			body, _ := io.ReadAll(req.Body)
+			if err := json.Unmarshal(body, &activity); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			r.mu.Lock()
+			r.received = append(r.received, activity)
+			r.mu.Unlock()
+			w.WriteHeader(202)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/activity+json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"@context": ActivityStreamsContext,
+			"id":       actor,
+			"type":     "Person",
+			"inbox":    actor + "/inbox",
+			"publicKey": map[string]string{
+				"id":           actor + "#main-key",
+				"owner":        actor,
+				"publicKeyPem": publicKey,
+			},
+		})
+	}))
+	t.Cleanup(r.Close)
+
+	h.Client = r.Client()
+	return r
+}
+
+// actor returns the id of the actor of the name.
+func (r *fakeRemote) actor(name string) string {
+	return r.URL + "/users/" + name
+}
+
+// Received returns the activities posted to the inboxes so far.
+func (r *fakeRemote) Received() []map[string]any {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]map[string]any{}, r.received...)
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+func (h *Handler) PostInbox(c echo.Context) error {
+	timing := newStopwatch()
+	c.SetRequest(c.Request().WithContext(h.withFetchBudget(c.Request().Context())))
+
+	if h.StrictContentType && !isActivityContentType(c.Request().Header.Get("Content-Type")) {
+		return c.JSON(415, map[string]string{
+			"error": `Content-Type must be application/activity+json or application/ld+json; profile="https://www.w3.org/ns/activitystreams"`,
+		})
+	}
+
+	activity, raw, err := readActivity(c.Request(), h.maxJSONDepth(), h.maxBodySize())
+	if errors.Is(err, errBodyTooLarge) {
+		return c.JSON(413, map[string]string{
+			"error": err.Error(),
+		})
+	} else if err != nil {
+		if raw != nil {
+			logRequestForDebug(c, string(raw))
+		}
+		return c.JSON(400, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	logRequestForDebug(c, activity.Raw)
+	c.Set("activity", activity)
+
+	timing.Lap("parse")
+	step := "verify"
+	defer func() {
+		timing.Lap(step)
+		c.Logger().Printf("inbox timing of %s from %s: %s", strings.Join(activity.Type, ", "), activity.Actor, timing)
+	}()
+
+	if len(activity.Type) == 0 {
+		return c.JSON(400, map[string]string{
+			"error": "missing activity type",
+		})
+	}
+
+	if !h.federationAllowed(activity.Actor) {
+		return c.JSON(403, map[string]string{
+			"error": "federation with this instance is not allowed",
+		})
+	}
+
+	var verifyErr error
+	defer func() {
+		c.Set("verifyErr", verifyErr)
+		h.fireWebhook(inboxEvent(c.Request(), activity, verifyErr))
+	}()
+
+	c.Set("verifyAttempted", true)
+	key, err := h.verifyRequest(c.Request(), raw)
+	message := "invalid signature"
+	if err != nil {
+		c.Logger().Printf("failed to verify signature by %q: %s", key.ID, err)
+		if h.DebugSignatures {
+			c.Logger().Printf("signature covers %q; reconstructed signing string:\n%s", key.Headers, key.SigningString)
+		}
+	} else if err = checkKeyOwner(activity.Actor, key); err != nil {
+		message = "actor does not match signature"
+		c.Logger().Printf("signer mismatch: %s", err)
+	}
+	if err != nil {
+		verifyErr = err
+		if !h.insecureSkipVerify(c.Request()) {
+			return h.signatureFailure(c, message, err)
+		}
+		c.Logger().Printf("UNVERIFIED: accepting %s from %s without a valid signature, because %s is in INSECURE_SKIP_VERIFY_FROM", strings.Join(activity.Type, ", "), activity.Actor, c.Request().RemoteAddr)
+	}
+
+	timing.Lap(step)
+	step = "process"
+
+	return h.dispatchActivity(c, activity)
+}
+
+// dispatchActivity processes a received activity by its type. The signature must have been verified already.
+func (h *Handler) dispatchActivity(c echo.Context, activity *Activity) error {
+	for _, t := range activity.Type {
+		switch t {
+		case "Follow":
+			return h.PostInboxFollow(c, activity)
+		case "Undo":
+			return h.PostInboxUndo(c, activity)
+		case "Create":
+			return h.PostInboxCreate(c, activity)
+		case "Like", "Announce":
+			return h.PostInboxReaction(c, t, activity)
+		case "EmojiReact":
+			return h.PostInboxEmojiReact(c, activity)
+		case "Accept", "Reject":
+			return h.PostInboxFollowAnswer(c, t, activity)
+		case "Move":
+			return h.PostInboxMove(c, activity)
+		}
+	}
+
+	if h.acknowledged(activity) {
+		c.Logger().Printf("acknowledged %s from %s without processing", strings.Join(activity.Type, ", "), activity.Actor)
+		return c.JSON(202, map[string]string{
+			"status": "acknowledged",
+		})
+	}
+
+	return c.JSON(400, map[string]string{
+		"error": fmt.Sprintf("unsupported type: %q", strings.Join(activity.Type, ", ")),
+	})
+}
+
+// DefaultAcknowledgedTypes are the activity types that are accepted without processing when Handler.AcknowledgedTypes is nil.
+var DefaultAcknowledgedTypes = []string{"Listen", "Read", "View"}
+
+// acknowledged reports whether the activity has one of the types that are accepted but not processed.
+func (h *Handler) acknowledged(activity *Activity) bool {
+	types := h.AcknowledgedTypes
+	if types == nil {
+		types = DefaultAcknowledgedTypes
+	}
+	for _, t := range types {
+		if activity.HasType(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// signatureFailure responds 401 with the code of the failed check, so that the sender can tell what to fix.
+// The challenge lists the headers that the inbox asks for, in the format that this instance signs with.
+func (h *Handler) signatureFailure(c echo.Context, message string, err error) error {
+	headers := h.requiredSignedHeaders()
+	if h.SignatureFormat == SignatureFormatRFC9421 {
+		components := rfc9421Components(headers)
+		for i, name := range components {
+			components[i] = strconv.Quote(name)
+		}
+		c.Response().Header().Set("WWW-Authenticate", `Signature realm="inbox"`)
+		c.Response().Header().Set("Accept-Signature", "sig1=("+strings.Join(components, " ")+")")
+	} else {
+		c.Response().Header().Set("WWW-Authenticate", fmt.Sprintf(`Signature realm="inbox",headers="%s"`, strings.Join(headers, " ")))
+	}
+	return c.JSON(401, map[string]string{
+		"error":  message,
+		"code":   string(signatureErrorCode(err)),
+		"detail": err.Error(),
+	})
+}
+
+// requiredSignedHeaders returns the headers that signatures of POSTs to the inbox should cover.
+// They are the ones this instance signs with, and always include digest because checkDigestSigned requires it.
+func (h *Handler) requiredSignedHeaders() []string {
+	headers := h.SignedHeaders
+	if headers == nil {
+		headers = DefaultSignedHeaders
+	}
+	if !covers(headers, "digest") {
+		headers = append(append([]string{}, headers...), "digest")
+	}
+	return headers
+}
+
+func (h *Handler) PostInboxFollow(c echo.Context, follow *Activity) error {
+	username := c.Param("username")
+	if username == "" {
+		// Delivered to the shared inbox; the followed user is the object.
+		name, ok := h.localUsername(follow.Object.ID)
+		if !ok {
+			return c.JSON(400, map[string]string{
+				"error": "follow object is not a local user",
+			})
+		}
+		username = name
+	}
+
+	actor := follow.Actor
+	if actor == "" {
+		return c.JSON(400, map[string]string{
+			"error": "actor of Follow is missing",
+		})
+	}
+
+	decision := h.followPolicy().Decide(follow)
+	if h.isSelfFollow(username, follow) {
+		switch h.selfFollow() {
+		case SelfFollowError:
+			c.Logger().Printf("refused self-follow of %s", username)
+			return c.JSON(400, map[string]string{
+				"error": "cannot follow yourself",
+			})
+		case SelfFollowReject:
+			decision = FollowReject
+		}
+	}
+	c.Logger().Printf("follow from %s to %s: %s", actor, username, decision)
+
+	if decision == FollowDefer {
+		h.PendingFollows.Add(username, follow, time.Now())
+		return c.JSON(202, map[string]string{
+			"status": "pending",
+		})
+	}
+
+	if err := h.answerFollow(c.Request().Context(), username, follow, decision); err != nil {
+		c.Logger().Printf("failed to answer follow from %s: %s", actor, err)
+		return c.JSON(500, map[string]string{
+			"error": "internal server error",
+		})
+	}
+
+	if decision == FollowReject {
+		return c.JSON(200, map[string]string{
+			"status": "rejected",
+		})
+	}
+	return c.JSON(200, map[string]string{
+		"status": "accepted",
+	})
+}
+
+// answerFollow sends Accept or Reject for the follow request to the user, and adds the follower if accepted.
+func (h *Handler) answerFollow(ctx context.Context, username string, follow *Activity, decision FollowDecision) error {
+	inbox, _, err := h.resolveInbox(ctx, follow.Actor)
+	if err != nil {
+		return fmt.Errorf("failed to resolve inbox: %w", err)
+	}
+
+	typ := "Accept"
+	if decision == FollowReject {
+		typ = "Reject"
+	}
+	if err := h.deliver(username, inbox, h.followResponse(typ, username, follow)); err != nil {
+		return fmt.Errorf("failed to send %s: %w", typ, err)
+	}
+
+	if decision == FollowAccept {
+		h.Followers.Add(username, follow.Actor)
+	}
+	return nil
+}
+
+// followResponse builds an Accept or Reject activity for the follow request.
+func (h *Handler) followResponse(typ, username string, follow *Activity) map[string]any {
+	return h.newActivity(username, map[string]any{
+		"@context": ActivityStreamsContext,
+		"type":     typ,
+		"actor":    h.userURL(username),
+		"object":   follow.Raw,
+	})
+}
+
+// newActivity assigns a unique and dereferenceable id to an activity sent by the user.
+func (h *Handler) newActivity(username string, activity map[string]any) map[string]any {
+	h.Activities.Add(username, activity, h.userURL(username)+"/activities")
+	return activity
+}
+
+func (h *Handler) GetActivity(c echo.Context) error {
+	activity, ok := h.Activities.Get(c.Param("username"), c.Param("id"))
+	if !ok {
+		return c.JSON(404, map[string]string{
+			"error": "not found",
+		})
+	}
+	return h.activityJSON(c, 200, activity)
+}
+
+func (h *Handler) PostInboxUndo(c echo.Context, undo *Activity) error {
+	if object := undo.Object.Embedded; object != nil && undo.Object.Type() == "Follow" {
+		actor := idOf(object["actor"])
+		if username, ok := h.localUsername(idOf(object["object"])); ok && actor == undo.Actor {
+			h.Followers.Remove(username, actor)
+		}
+	}
+
+	return c.JSON(200, map[string]string{
+		"status": "accepted",
+	})
+}
+
+// directRecipients returns the local users that a direct message is addressed to.
+// The audience is direct if it is not empty and consists only of local actors, without the public or any collection.
+func (h *Handler) directRecipients(audience []string) ([]string, bool) {
+	var usernames []string
+	for _, id := range audience {
+		username, ok := h.localUsername(id)
+		if !ok {
+			return nil, false
+		}
+		usernames = append(usernames, username)
+	}
+	return usernames, len(usernames) > 0
+}
+
+func (h *Handler) PostInboxCreate(c echo.Context, create *Activity) error {
+	object := create.Object.Embedded
+	if object == nil {
+		return c.JSON(400, map[string]string{
+			"error": "object of Create must be embedded",
+		})
+	}
+
+	actor := create.Actor
+	typ := create.Object.Type()
+
+	switch typ {
+	case "Note":
+		if voted, err := h.recordVote(actor, object); voted {
+			if err != nil {
+				return c.JSON(422, map[string]string{
+					"error": err.Error(),
+				})
+			}
+			return c.JSON(200, map[string]string{
+				"status": "accepted",
+			})
+		}
+	case "Question":
+	default:
+		return c.JSON(200, map[string]string{
+			"status": "ignored",
+		})
+	}
+
+	note := ReceivedNote{
+		Type:        typ,
+		Options:     receivedPollOptions(object),
+		Attachments: receivedAttachments(object),
+		Tags:        receivedTags(object),
+		ReceivedAt:  time.Now(),
+	}
+	note.ID = create.Object.ID
+	note.Actor = actor
+	note.Content, _ = object["content"].(string)
+	note.Summary, _ = object["summary"].(string)
+	note.Sensitive, _ = object["sensitive"].(bool)
+	note.Context = idOf(object["context"])
+	if note.Context == "" {
+		note.Context = idOf(object["conversation"])
+	}
+	if v, ok := object["published"]; ok {
+		if published, err := parsePublished(v); err != nil {
+			c.Logger().Printf("WARNING: ignored the published of %s: %s", note.ID, err)
+		} else {
+			note.Published = published.Format(time.RFC3339)
+		}
+	}
+	note.Public = isPublic(append(create.To, create.Cc...))
+
+	if recipients, ok := h.directRecipients(append(create.To, create.Cc...)); ok {
+		for _, username := range recipients {
+			h.DirectMessages.Add(username, note)
+		}
+	} else {
+		h.Notes.Add(note)
+	}
+
+	return c.JSON(200, map[string]string{
+		"status": "accepted",
+	})
+}
+
+// PostInboxFollowAnswer handles an Accept or Reject of a Follow sent by a local user.
+// The inner Follow may be either its id or the embedded activity. It is matched with the unanswered follows by the id and the sender.
+func (h *Handler) PostInboxFollowAnswer(c echo.Context, typ string, activity *Activity) error {
+	object, err := objectOf(activity.Raw["object"])
+	if err != nil {
+		return c.JSON(400, map[string]string{
+			"error": fmt.Sprintf("invalid object of %s: %s", typ, err),
+		})
+	}
+	if t := object.Type(); t != "" && t != "Follow" {
+		c.Logger().Printf("ignored %s of %s from %s", typ, t, activity.Actor)
+		return c.JSON(202, map[string]string{
+			"status": "ignored",
+		})
+	}
+
+	follow, ok := h.OutgoingFollows.Take(object.ID, activity.Actor)
+	if !ok {
+		c.Logger().Printf("%s from %s does not match any follow: %s", typ, activity.Actor, object.ID)
+		return c.JSON(202, map[string]string{
+			"status": "ignored",
+		})
+	}
+
+	if typ == "Reject" {
+		return c.JSON(200, map[string]string{
+			"status": "rejected",
+		})
+	}
+	h.Following.Add(follow.Username, follow.Actor)
+	return c.JSON(200, map[string]string{
+		"status": "accepted",
+	})
+}
+
+// PostInboxReaction records a Like or Announce. The object may be either a bare IRI or an embedded object.
+func (h *Handler) PostInboxReaction(c echo.Context, typ string, activity *Activity) error {
+	object, err := objectOf(activity.Raw["object"])
+	if err != nil {
+		return c.JSON(400, map[string]string{
+			"error": fmt.Sprintf("invalid object of %s: %s", typ, err),
+		})
+	}
+
+	// Announces usually carry a bare IRI. Fetch it so that the boosted object can be inspected in /debug/reactions.
+	if typ == "Announce" && object.Embedded == nil {
+		chain, err := h.resolveObject(c.Request().Context(), object.ID)
+		if err != nil {
+			c.Logger().Printf("failed to resolve the object of %s: %s", activity.ID, err)
+		}
+		if len(chain) > 0 {
+			object.Embedded = chain[0]
+		}
+	}
+
+	h.Reactions.Add(Reaction{
+		Type:       typ,
+		ID:         activity.ID,
+		Actor:      activity.Actor,
+		Object:     object.ID,
+		ReceivedAt: time.Now(),
+		Embedded:   object.Embedded,
+	})
+
+	return c.JSON(200, map[string]string{
+		"status": "accepted",
+	})
+}
+
+// PostInboxEmojiReact records an emoji reaction of Pleroma and others.
+// The content is either a unicode emoji, or a shortcode such as ":blobcat:" with an Emoji of the same name in the tag.
+func (h *Handler) PostInboxEmojiReact(c echo.Context, activity *Activity) error {
+	object, err := objectOf(activity.Raw["object"])
+	if err != nil {
+		return c.JSON(400, map[string]string{
+			"error": fmt.Sprintf("invalid object of EmojiReact: %s", err),
+		})
+	}
+
+	reaction := Reaction{
+		Type:       "EmojiReact",
+		ID:         activity.ID,
+		Actor:      activity.Actor,
+		Object:     object.ID,
+		ReceivedAt: time.Now(),
+		Embedded:   object.Embedded,
+	}
+	reaction.Content, _ = activity.Raw["content"].(string)
+	for _, t := range receivedTags(activity.Raw) {
+		if t.Type != "Emoji" {
+			continue
+		}
+		// Some servers omit the content of custom emoji reactions, leaving only the tag.
+		if reaction.Content == "" || strings.Trim(t.Name, ":") == strings.Trim(reaction.Content, ":") {
+			t := t
+			reaction.Content = ":" + strings.Trim(t.Name, ":") + ":"
+			reaction.Emoji = &t
+			break
+		}
+	}
+	if reaction.Content == "" {
+		return c.JSON(400, map[string]string{
+			"error": "emoji of EmojiReact is missing",
+		})
+	}
+
+	h.Reactions.Add(reaction)
+
+	return c.JSON(202, map[string]string{
+		"status": "accepted",
+	})
+}
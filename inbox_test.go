@@ -0,0 +1,808 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckKeyOwner(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Actor string
+		Key   verifiedKey
+		OK    bool
+	}{
+		{"owner", "https://remote.example/users/carol", verifiedKey{ID: "https://remote.example/users/carol#main-key", Owner: "https://remote.example/users/carol"}, true},
+		{"host case", "https://Remote.Example/users/carol", verifiedKey{ID: "https://remote.example/users/carol#main-key", Owner: "https://Remote.Example/users/carol"}, true},
+		{"another host", "https://evil.example/users/carol", verifiedKey{ID: "https://remote.example/users/carol#main-key", Owner: "https://remote.example/users/carol"}, false},
+		{"another owner", "https://remote.example/users/dave", verifiedKey{ID: "https://remote.example/users/carol#main-key", Owner: "https://remote.example/users/carol"}, false},
+		{"invalid actor", "", verifiedKey{ID: "https://remote.example/users/carol#main-key", Owner: "https://remote.example/users/carol"}, false},
+		{"invalid keyId", "https://remote.example/users/carol", verifiedKey{ID: "main-key", Owner: "https://remote.example/users/carol"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := checkKeyOwner(tt.Actor, tt.Key)
+			if tt.OK && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !tt.OK && signatureErrorCode(err) != ActorMismatch {
+				t.Fatalf("expected %s but got %v", ActorMismatch, err)
+			}
+		})
+	}
+}
+
+func TestPostInbox_actorMismatch(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	remote := newFakeRemote(t, h)
+	keyID := remote.actor("carol") + "#main-key"
+
+	tests := []struct {
+		Name  string
+		Actor string
+		Code  int
+	}{
+		{"signed by the actor", remote.actor("carol"), 202},
+		{"actor on another host", "https://evil.example/users/carol", 401},
+		{"another actor on the same host", remote.actor("dave"), 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"@context":"https://www.w3.org/ns/activitystreams","id":"%s/listens/1","type":"Listen","actor":"%s"}`, tt.Actor, tt.Actor)
+			req := newSignedPost(t, keyID, "https://local.example/@alice/inbox", body, nil)
+
+			rec := serve(h, req)
+			if rec.Code != tt.Code {
+				t.Fatalf("expected %d but got %d: %s", tt.Code, rec.Code, rec.Body)
+			}
+			if tt.Code != 401 {
+				return
+			}
+
+			var resp map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp["code"] != string(ActorMismatch) {
+				t.Errorf("expected code %s but got %q", ActorMismatch, resp["code"])
+			}
+		})
+	}
+}
+
+func TestPostInbox_signatureFailure(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	remote := newFakeRemote(t, h)
+	actor := remote.actor("carol")
+	keyID := actor + "#main-key"
+	body := fmt.Sprintf(`{"@context":"https://www.w3.org/ns/activitystreams","id":"%s/listens/1","type":"Listen","actor":"%s"}`, actor, actor)
+
+	gone := httptest.NewServer(http.NotFoundHandler())
+	gone.Close()
+
+	tests := []struct {
+		Name    string
+		Request func(t *testing.T) *http.Request
+		Code    SignatureErrorCode
+	}{
+		{"missing signature", func(t *testing.T) *http.Request {
+			req := newSignedPost(t, keyID, "https://local.example/@alice/inbox", body, nil)
+			req.Header.Del("Signature")
+			return req
+		}, SignatureMissing},
+		{"key fetch failed", func(t *testing.T) *http.Request {
+			return newSignedPost(t, gone.URL+"/users/carol#main-key", "https://local.example/@alice/inbox", body, nil)
+		}, KeyFetchFailed},
+		{"digest mismatch", func(t *testing.T) *http.Request {
+			req := newSignedPost(t, keyID, "https://local.example/@alice/inbox", body, nil)
+			swapped := strings.Replace(body, "Listen", "Read", 1)
+			req.Body = io.NopCloser(strings.NewReader(swapped))
+			req.ContentLength = int64(len(swapped))
+			return req
+		}, DigestMismatch},
+		{"signature invalid", func(t *testing.T) *http.Request {
+			req := newSignedPost(t, keyID, "https://local.example/@alice/inbox", body, nil)
+			req.Header.Set("Date", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+			return req
+		}, SignatureInvalid},
+		{"stale date", func(t *testing.T) *http.Request {
+			req := newSignedPost(t, keyID, "https://local.example/@alice/inbox", body, nil)
+			req.Header.Set("Date", time.Now().Add(-maxSignatureAge-time.Hour).UTC().Format(http.TimeFormat))
+			return req
+		}, StaleDate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			rec := serve(h, tt.Request(t))
+			if rec.Code != 401 {
+				t.Fatalf("expected 401 but got %d: %s", rec.Code, rec.Body)
+			}
+
+			var resp map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp["code"] != string(tt.Code) {
+				t.Errorf("expected code %s but got %q: %s", tt.Code, resp["code"], resp["detail"])
+			}
+		})
+	}
+}
+
+func TestPostInbox_signatureChallenge(t *testing.T) {
+	tests := []struct {
+		Name            string
+		SignedHeaders   []string
+		SignatureFormat string
+		Authenticate    string
+		AcceptSignature string
+	}{
+		{"default", nil, "", `Signature realm="inbox",headers="(request-target) host date digest"`, ""},
+		{"configured headers", []string{"(request-target)", "host", "date", "digest", "content-type"}, "", `Signature realm="inbox",headers="(request-target) host date digest content-type"`, ""},
+		{"configured without digest", []string{"(request-target)", "host", "date"}, "", `Signature realm="inbox",headers="(request-target) host date digest"`, ""},
+		{"rfc9421", nil, SignatureFormatRFC9421, `Signature realm="inbox"`, `sig1=("@method" "@target-uri" "@authority" "date" "content-digest")`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := newTestHandler(t, "alice")
+			h.SignedHeaders = tt.SignedHeaders
+			h.SignatureFormat = tt.SignatureFormat
+
+			req := httptest.NewRequest("POST", "/@alice/inbox", strings.NewReader(`{"type":"Listen","actor":"https://remote.example/users/carol"}`))
+			req.Header.Set("Content-Type", "application/activity+json")
+			rec := serve(h, req)
+			if rec.Code != 401 {
+				t.Fatalf("expected 401 but got %d: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("WWW-Authenticate"); got != tt.Authenticate {
+				t.Errorf("unexpected WWW-Authenticate: %s", got)
+			}
+			if got := rec.Header().Get("Accept-Signature"); got != tt.AcceptSignature {
+				t.Errorf("unexpected Accept-Signature: %s", got)
+			}
+		})
+	}
+}
+
+func TestPostInbox_invalidBody(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Body  string
+		Error string
+	}{
+		{"empty", "", errEmptyBody.Error()},
+		{"malformed", `{"type":"Create"`, errMalformedJSON.Error()},
+		{"pathologically nested", `{"type":"Create","object":` + strings.Repeat("[", 100000) + strings.Repeat("]", 100000) + `}`, errTooDeepJSON.Error()},
+	}
+
+	h := newTestHandler(t, "alice")
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/@alice/inbox", strings.NewReader(tt.Body))
+			req.Header.Set("Content-Type", "application/activity+json")
+
+			rec := serve(h, req)
+			if rec.Code != 400 {
+				t.Fatalf("expected 400 but got %d: %s", rec.Code, rec.Body)
+			}
+			var resp map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp["error"] != tt.Error {
+				t.Errorf("expected %q but got %q", tt.Error, resp["error"])
+			}
+		})
+	}
+}
+
+// endlessBody is a request body of '{' followed by spaces forever, that counts how much of it is read.
+type endlessBody struct{ read int64 }
+
+func (b *endlessBody) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = ' '
+	}
+	if b.read == 0 && len(p) > 0 {
+		p[0] = '{'
+	}
+	b.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestPostInbox_bodyTooLarge(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	h.MaxBodySize = 1024
+
+	for _, path := range []string{"/inbox", "/@alice/inbox"} {
+		body := &endlessBody{}
+		req := httptest.NewRequest("POST", path, body)
+		req.Header.Set("Content-Type", "application/activity+json")
+
+		if rec := serve(h, req); rec.Code != 413 {
+			t.Errorf("%s: expected 413 but got %d: %s", path, rec.Code, rec.Body)
+		}
+		// The body is not buffered beyond the limit before the signature is checked.
+		if body.read > 64*1024 {
+			t.Errorf("%s: read %d bytes of the body", path, body.read)
+		}
+	}
+}
+
+// postFollow sends a Follow from the remote actor to the local user, and returns the response.
+func postFollow(t *testing.T, h *Handler, remote *fakeRemote, name, username string, n int) *httptest.ResponseRecorder {
+	t.Helper()
+
+	actor := remote.actor(name)
+	body := fmt.Sprintf(`{"@context":"https://www.w3.org/ns/activitystreams","id":"%s/follows/%d","type":"Follow","actor":"%s","object":"%s"}`, actor, n, actor, h.userURL(username))
+	return serve(h, newSignedPost(t, actor+"#main-key", "https://"+h.Hostname+"/@"+username+"/inbox", body, nil))
+}
+
+func TestPostInboxFollow_uniqueAcceptID(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	remote := newFakeRemote(t, h)
+
+	for i, name := range []string{"carol", "dave"} {
+		if rec := postFollow(t, h, remote, name, "alice", i); rec.Code != 200 {
+			t.Fatalf("expected 200 but got %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	received := remote.Received()
+	if len(received) != 2 {
+		t.Fatalf("expected 2 Accepts but got %d", len(received))
+	}
+	ids := []string{idOf(received[0]), idOf(received[1])}
+	if ids[0] == "" || ids[0] == ids[1] {
+		t.Fatalf("the Accepts do not have distinct ids: %q", ids)
+	}
+
+	for i, id := range ids {
+		req := httptest.NewRequest("GET", strings.TrimPrefix(id, h.baseURL()), nil)
+		req.Header.Set("Accept", "application/activity+json")
+		rec := serve(h, req)
+		if rec.Code != 200 {
+			t.Fatalf("GET %s: expected 200 but got %d", id, rec.Code)
+		}
+		var activity map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &activity); err != nil {
+			t.Fatal(err)
+		}
+		if activity["type"] != "Accept" || idOf(activity["object"]) != idOf(received[i]["object"]) {
+			t.Errorf("GET %s: unexpected activity: %v", id, activity)
+		}
+	}
+}
+
+func TestFollowResponse_concurrent(t *testing.T) {
+	h := newTestHandler(t, "alice")
+
+	const n = 50
+	ids := make(chan string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			follow := &Activity{ID: fmt.Sprintf("https://remote.example/follows/%d", i), Raw: map[string]any{"type": "Follow"}}
+			ids <- idOf(h.followResponse("Accept", "alice", follow))
+		}(i)
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := map[string]bool{}
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("duplicated id: %s", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestPostInbox_types(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	remote := newFakeRemote(t, h)
+	actor := remote.actor("carol")
+
+	tests := []struct {
+		Name  string
+		Type  string
+		Code  int
+		Error string
+	}{
+		{"missing", ``, 400, "missing activity type"},
+		{"null", `,"type":null`, 400, "missing activity type"},
+		{"empty array", `,"type":[]`, 400, "missing activity type"},
+		{"array with a known type", `,"type":["https://example.com/ns#Custom","Listen"]`, 202, ""},
+		{"unknown", `,"type":"Custom"`, 400, `unsupported type: "Custom"`},
+		{"array of unknown types", `,"type":["Custom","Other"]`, 400, `unsupported type: "Custom, Other"`},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"@context":"https://www.w3.org/ns/activitystreams","id":"%s/activities/%d","actor":"%s"%s}`, actor, i, actor, tt.Type)
+			rec := serve(h, newSignedPost(t, actor+"#main-key", "https://local.example/@alice/inbox", body, nil))
+			if rec.Code != tt.Code {
+				t.Fatalf("expected %d but got %d: %s", tt.Code, rec.Code, rec.Body)
+			}
+			if tt.Error == "" {
+				return
+			}
+
+			var resp map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp["error"] != tt.Error {
+				t.Errorf("expected %q but got %q", tt.Error, resp["error"])
+			}
+		})
+	}
+}
+
+// postCreate sends a Create of a Note with the audience from the remote actor to the shared inbox, and returns the response.
+func postCreate(t *testing.T, h *Handler, remote *fakeRemote, n int, to, cc []string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	actor := remote.actor("carol")
+	audience, _ := json.Marshal(map[string]any{"to": to, "cc": cc})
+	body := fmt.Sprintf(`{"@context":"https://www.w3.org/ns/activitystreams","id":"%s/notes/%d/activity","type":"Create","actor":"%s","object":{"id":"%s/notes/%d","type":"Note","content":"hello %d"},%s`, actor, n, actor, actor, n, n, audience[1:])
+	return serve(h, newSignedPost(t, actor+"#main-key", "https://"+h.Hostname+"/inbox", body, nil))
+}
+
+func TestPostInboxCreate_direct(t *testing.T) {
+	h := newTestHandler(t, "alice", "bob")
+	h.Debug = true
+	remote := newFakeRemote(t, h)
+	alice, bob := h.userURL("alice"), h.userURL("bob")
+
+	tests := []struct {
+		Name   string
+		To, Cc []string
+		Public bool
+		Direct []string
+	}{
+		{"public", []string{PublicAudience}, []string{alice}, true, nil},
+		{"followers only", []string{remote.actor("carol") + "/followers"}, []string{alice}, false, nil},
+		{"direct to alice", []string{alice}, nil, false, []string{"alice"}},
+		{"direct to alice and bob", []string{alice}, []string{bob}, false, []string{"alice", "bob"}},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			notes := len(h.Notes.List())
+			dms := map[string]int{"alice": len(h.DirectMessages.List("alice")), "bob": len(h.DirectMessages.List("bob"))}
+
+			if rec := postCreate(t, h, remote, i, tt.To, tt.Cc); rec.Code != 200 {
+				t.Fatalf("expected 200 but got %d: %s", rec.Code, rec.Body)
+			}
+
+			if tt.Direct == nil {
+				stored := h.Notes.List()
+				if len(stored) != notes+1 || stored[len(stored)-1].Public != tt.Public {
+					t.Errorf("the note is not stored as a timeline note: %+v", stored)
+				}
+			} else if len(h.Notes.List()) != notes {
+				t.Error("a direct message is stored as a timeline note")
+			}
+			for _, username := range []string{"alice", "bob"} {
+				want := dms[username]
+				for _, r := range tt.Direct {
+					if r == username {
+						want++
+					}
+				}
+				if n := len(h.DirectMessages.List(username)); n != want {
+					t.Errorf("expected %d direct messages to %s but got %d", want, username, n)
+				}
+			}
+		})
+	}
+
+	rec := serve(h, httptest.NewRequest("GET", "/debug/direct/bob", nil))
+	var messages []ReceivedNote
+	if err := json.Unmarshal(rec.Body.Bytes(), &messages); err != nil {
+		t.Fatalf("failed to decode: %s: %s", err, rec.Body)
+	}
+	if len(messages) != 1 || messages[0].Content != "hello 3" {
+		t.Errorf("unexpected direct messages to bob: %+v", messages)
+	}
+}
+
+func TestPostInbox_acknowledged(t *testing.T) {
+	tests := []struct {
+		Name         string
+		Acknowledged []string
+		Type         string
+		Code         int
+	}{
+		{"default View", nil, "View", 202},
+		{"default Listen", nil, "Listen", 202},
+		{"unknown", nil, "Custom", 400},
+		{"configured", []string{"Custom"}, "Custom", 202},
+		{"not configured", []string{"Custom"}, "View", 400},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := newTestHandler(t, "alice")
+			h.AcknowledgedTypes = tt.Acknowledged
+			remote := newFakeRemote(t, h)
+			actor := remote.actor("carol")
+
+			body := fmt.Sprintf(`{"@context":"https://www.w3.org/ns/activitystreams","id":"%s/activities/%d","type":"%s","actor":"%s","object":"https://local.example/@alice/posts/1"}`, actor, i, tt.Type, actor)
+			rec, logs := serveWithLog(h, newSignedPost(t, actor+"#main-key", "https://local.example/@alice/inbox", body, nil))
+			if rec.Code != tt.Code {
+				t.Fatalf("expected %d but got %d: %s", tt.Code, rec.Code, rec.Body)
+			}
+			if logged := strings.Contains(logs, "acknowledged "+tt.Type+" from "+actor); logged != (tt.Code == 202) {
+				t.Errorf("unexpected log: %s", logs)
+			}
+		})
+	}
+}
+
+func TestPostInboxReaction_object(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	remote := newFakeRemote(t, h)
+	actor := remote.actor("carol")
+	note := h.userURL("alice") + "/posts/1"
+
+	tests := []struct {
+		Name   string
+		Type   string
+		Object string
+		Code   int
+	}{
+		{"Like of an IRI", "Like", `"` + note + `"`, 200},
+		{"Announce of an embedded object", "Announce", `{"id":"` + note + `","type":"Note"}`, 200},
+		{"Like of an object without id", "Like", `{"type":"Note"}`, 400},
+		{"Like of a number", "Like", `1`, 400},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"@context":"https://www.w3.org/ns/activitystreams","id":"%s/reactions/%d","type":"%s","actor":"%s","object":%s}`, actor, i, tt.Type, actor, tt.Object)
+			rec := serve(h, newSignedPost(t, actor+"#main-key", "https://local.example/@alice/inbox", body, nil))
+			if rec.Code != tt.Code {
+				t.Fatalf("expected %d but got %d: %s", tt.Code, rec.Code, rec.Body)
+			}
+			if tt.Code != 200 {
+				if !strings.Contains(rec.Body.String(), errUnsupportedObject.Error()) {
+					t.Errorf("unexpected error: %s", rec.Body)
+				}
+				return
+			}
+			if n := h.Reactions.Count(note, tt.Type); n != 1 {
+				t.Errorf("expected the %s to be recorded for %s but got %d", tt.Type, note, n)
+			}
+		})
+	}
+}
+
+func TestPostInboxCreate_published(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	remote := newFakeRemote(t, h)
+	actor := remote.actor("carol")
+
+	tests := []struct {
+		Published string
+		Want      string
+		Warned    bool
+	}{
+		{"2024-01-02T12:04:05+09:00", "2024-01-02T03:04:05Z", false},
+		{"2024-01-02T03:04:05Z", "2024-01-02T03:04:05Z", false},
+		{"not a time", "", true},
+	}
+
+	for i, tt := range tests {
+		body := fmt.Sprintf(`{"@context":"https://www.w3.org/ns/activitystreams","id":"%s/notes/%d/activity","type":"Create","actor":"%s","to":"%s","object":{"id":"%s/notes/%d","type":"Note","content":"hello","published":%q}}`, actor, i, actor, PublicAudience, actor, i, tt.Published)
+		rec, log := serveWithLog(h, newSignedPost(t, actor+"#main-key", "https://"+h.Hostname+"/inbox", body, nil))
+		if rec.Code != 200 {
+			t.Fatalf("%s: unexpected response: %d %s", tt.Published, rec.Code, rec.Body)
+		}
+
+		notes := h.Notes.List()
+		if len(notes) != i+1 {
+			t.Fatalf("%s: the note was not stored", tt.Published)
+		}
+		if got := notes[len(notes)-1].Published; got != tt.Want {
+			t.Errorf("%s: expected %q but got %q", tt.Published, tt.Want, got)
+		}
+		if warned := strings.Contains(log, "ignored the published"); warned != tt.Warned {
+			t.Errorf("%s: unexpected log: %s", tt.Published, log)
+		}
+	}
+}
+
+func TestPostInboxFollowAnswer(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Type     string
+		Embedded bool
+		Sender   string
+		Code     int
+		Status   string
+	}{
+		{"Accept with the id", "Accept", false, "carol", 200, "accepted"},
+		{"Accept with the embedded Follow", "Accept", true, "carol", 200, "accepted"},
+		{"Reject with the id", "Reject", false, "carol", 200, "rejected"},
+		{"Reject with the embedded Follow", "Reject", true, "carol", 200, "rejected"},
+		{"Accept from another actor", "Accept", false, "dave", 202, "ignored"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := newTestHandler(t, "alice")
+			h.AdminToken = "secret"
+			remote := newFakeRemote(t, h)
+			carol := remote.actor("carol")
+
+			if rec := postAdmin(t, h, "/admin/follows", map[string]any{"username": "alice", "actor": carol}); rec.Code != 202 {
+				t.Fatalf("failed to follow: %d %s", rec.Code, rec.Body)
+			}
+			received := remote.Received()
+			if len(received) != 1 || received[0]["type"] != "Follow" || received[0]["object"] != carol {
+				t.Fatalf("unexpected activities sent: %v", received)
+			}
+			follow := received[0]
+
+			var object any = follow["id"]
+			if tt.Embedded {
+				object = follow
+			}
+			sender := remote.actor(tt.Sender)
+			answer, _ := json.Marshal(map[string]any{
+				"@context": ActivityStreamsContext,
+				"id":       sender + "/answers/1",
+				"type":     tt.Type,
+				"actor":    sender,
+				"object":   object,
+			})
+			rec := serve(h, newSignedPost(t, sender+"#main-key", "https://"+h.Hostname+"/@alice/inbox", string(answer), nil))
+			if rec.Code != tt.Code || !strings.Contains(rec.Body.String(), tt.Status) {
+				t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body)
+			}
+
+			following := h.Following.List("alice")
+			if accepted := len(following) == 1 && following[0] == carol; accepted != (tt.Status == "accepted") {
+				t.Errorf("unexpected following: %v", following)
+			}
+			// The follow waits for the answer from carol, if the answer was not matched.
+			if pending := len(h.OutgoingFollows.List()) == 1; pending != (tt.Status == "ignored") {
+				t.Errorf("unexpected unanswered follows: %v", h.OutgoingFollows.List())
+			}
+		})
+	}
+}
+
+func TestPostInboxFollowAnswer_alias(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	h.AdminToken = "secret"
+	remote := newFakeRemote(t, h)
+	carol, alias := remote.actor("carol"), remote.URL+"/@carol"
+
+	// The alias resolves to the actor document of carol, whose id is the canonical one.
+	h.Actors = ActorResolverFunc(func(ctx context.Context, id string) (*remoteActor, error) {
+		return &remoteActor{ID: carol, Inbox: carol + "/inbox"}, nil
+	})
+
+	if rec := postAdmin(t, h, "/admin/follows", map[string]any{"username": "alice", "actor": alias}); rec.Code != 202 {
+		t.Fatalf("failed to follow: %d %s", rec.Code, rec.Body)
+	}
+	received := remote.Received()
+	if len(received) != 1 || received[0]["object"] != carol {
+		t.Fatalf("the Follow is not sent to the canonical id: %v", received)
+	}
+	if pending := h.OutgoingFollows.List(); len(pending) != 1 || pending[0].Actor != carol {
+		t.Fatalf("unexpected unanswered follows: %+v", pending)
+	}
+
+	answer := fmt.Sprintf(`{"@context":"https://www.w3.org/ns/activitystreams","id":"%s/answers/1","type":"Accept","actor":"%s","object":"%s"}`, carol, carol, received[0]["id"])
+	if rec := serve(h, newSignedPost(t, carol+"#main-key", "https://"+h.Hostname+"/@alice/inbox", answer, nil)); rec.Code != 200 {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body)
+	}
+	if following := h.Following.List("alice"); len(following) != 1 || following[0] != carol {
+		t.Errorf("unexpected following: %v", following)
+	}
+}
+
+func TestPostInboxFollowAnswer_unknownFollow(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	remote := newFakeRemote(t, h)
+	carol := remote.actor("carol")
+
+	body := fmt.Sprintf(`{"@context":"https://www.w3.org/ns/activitystreams","id":"%s/answers/1","type":"Accept","actor":"%s","object":"https://local.example/@alice/follows/unknown"}`, carol, carol)
+	rec, log := serveWithLog(h, newSignedPost(t, carol+"#main-key", "https://"+h.Hostname+"/@alice/inbox", body, nil))
+	if rec.Code != 202 {
+		t.Fatalf("expected 202 but got %d: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(log, "does not match any follow") {
+		t.Errorf("the unmatched Accept was not logged: %s", log)
+	}
+	if following := h.Following.List("alice"); len(following) != 0 {
+		t.Errorf("unexpected following: %v", following)
+	}
+}
+
+func TestPostInboxEmojiReact(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	h.Debug = true
+	remote := newFakeRemote(t, h)
+	note := h.userURL("alice") + "/posts/1"
+	blobcat := `{"type":"Emoji","name":":blobcat:","icon":{"type":"Image","url":"https://remote.example/emoji/blobcat.png"}}`
+
+	tests := []struct {
+		Name    string
+		Actor   string
+		Content string
+		Tag     string
+		Code    int
+		Emoji   string
+	}{
+		{"unicode emoji", "carol", `"👍"`, "", 202, "👍"},
+		{"another unicode emoji of the same actor", "carol", `"🎉"`, "", 202, "🎉"},
+		{"same emoji of the same actor again", "carol", `"👍"`, "", 202, "👍"},
+		{"custom emoji", "dave", `":blobcat:"`, `[` + blobcat + `]`, 202, ":blobcat:"},
+		{"custom emoji without content", "erin", `""`, blobcat, 202, ":blobcat:"},
+		{"no emoji", "frank", `""`, "", 400, ""},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			actor := remote.actor(tt.Actor)
+			tag := ""
+			if tt.Tag != "" {
+				tag = `,"tag":` + tt.Tag
+			}
+			body := fmt.Sprintf(`{"@context":"https://www.w3.org/ns/activitystreams","id":"%s/reactions/%d","type":"EmojiReact","actor":"%s","object":"%s","content":%s%s}`, actor, i, actor, note, tt.Content, tag)
+			rec := serve(h, newSignedPost(t, actor+"#main-key", "https://local.example/@alice/inbox", body, nil))
+			if rec.Code != tt.Code {
+				t.Fatalf("expected %d but got %d: %s", tt.Code, rec.Code, rec.Body)
+			}
+			if tt.Code != 202 {
+				return
+			}
+
+			var found *Reaction
+			for _, r := range h.Reactions.List()[note] {
+				if r.Actor == actor && r.Content == tt.Emoji {
+					r := r
+					found = &r
+				}
+			}
+			if found == nil {
+				t.Fatalf("the reaction is not recorded: %v", h.Reactions.List())
+			}
+			if strings.HasPrefix(tt.Emoji, ":") {
+				if found.Emoji == nil || found.Emoji.Href != "https://remote.example/emoji/blobcat.png" {
+					t.Errorf("the custom emoji is not recorded: %+v", found.Emoji)
+				}
+			} else if found.Emoji != nil {
+				t.Errorf("unexpected custom emoji for a unicode emoji: %+v", found.Emoji)
+			}
+		})
+	}
+
+	var counts map[string]ReactionCounts
+	if err := json.Unmarshal(serve(h, httptest.NewRequest("GET", "/debug/reactions/counts", nil)).Body.Bytes(), &counts); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"👍": 1, "🎉": 1, ":blobcat:": 2}
+	if got := counts[note].Emojis; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected %v but got %v", want, got)
+	}
+}
+
+func TestPostInbox_strictContentType(t *testing.T) {
+	tests := []struct {
+		ContentType string
+		Strict      bool
+		Code        int
+	}{
+		{"application/activity+json", true, 200},
+		{`application/ld+json; profile="https://www.w3.org/ns/activitystreams"`, true, 200},
+		{"application/ld+json", true, 415},
+		{"application/json", true, 415},
+		{"", true, 415},
+		{"application/json", false, 200},
+		{"", false, 200},
+	}
+
+	for _, tt := range tests {
+		h := newTestHandler(t, "alice")
+		h.StrictContentType = tt.Strict
+		actor := newFakeRemote(t, h).actor("carol")
+
+		body := fmt.Sprintf(`{"@context":"https://www.w3.org/ns/activitystreams","id":"%s/likes/1","type":"Like","actor":"%s","object":"%s/posts/1"}`, actor, actor, h.userURL("alice"))
+		req := newSignedPost(t, actor+"#main-key", "https://local.example/@alice/inbox", body, nil)
+		req.Header.Set("Content-Type", tt.ContentType)
+
+		if rec := serve(h, req); rec.Code != tt.Code {
+			t.Errorf("%q with strict %v: expected %d but got %d: %s", tt.ContentType, tt.Strict, tt.Code, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestPostInboxFollow_self(t *testing.T) {
+	tests := []struct {
+		SelfFollow string
+		Code       int
+		Answer     string
+	}{
+		{"", 200, "Reject"},
+		{SelfFollowReject, 200, "Reject"},
+		{SelfFollowError, 400, ""},
+		{SelfFollowAccept, 200, "Accept"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q", tt.SelfFollow), func(t *testing.T) {
+			h := newTestHandler(t, "alice")
+			h.SelfFollow = tt.SelfFollow
+			remote := newFakeRemote(t, h)
+
+			// The fake remote stands in for this host, so that alice can sign the follow and receive the answer.
+			h.Hostname = strings.TrimPrefix(remote.URL, "https://")
+			h.UsersPath = true
+			alice := h.userURL("alice")
+			if alice != remote.actor("alice") {
+				t.Fatalf("unexpected actor id: %s", alice)
+			}
+
+			body := fmt.Sprintf(`{"@context":"https://www.w3.org/ns/activitystreams","id":"%s/follows/1","type":"Follow","actor":"%s","object":"%s"}`, alice, alice, alice)
+			rec := serve(h, newSignedPost(t, alice+"#main-key", alice+"/inbox", body, nil))
+			if rec.Code != tt.Code {
+				t.Fatalf("expected %d but got %d: %s", tt.Code, rec.Code, rec.Body)
+			}
+
+			received := remote.Received()
+			if tt.Answer == "" {
+				if len(received) != 0 {
+					t.Errorf("unexpected answer: %v", received)
+				}
+			} else if len(received) != 1 || received[0]["type"] != tt.Answer {
+				t.Errorf("expected %s but got %v", tt.Answer, received)
+			}
+			if followers := h.Followers.List("alice"); (len(followers) == 1) != (tt.Answer == "Accept") {
+				t.Errorf("unexpected followers: %q", followers)
+			}
+		})
+	}
+}
+
+func TestIsSelfFollow(t *testing.T) {
+	h := newTestHandler(t, "alice", "bob")
+
+	tests := map[string]bool{
+		"https://local.example/@alice":          true,
+		"https://LOCAL.example/@alice":          true,
+		"https://local.example:443/@alice":      true,
+		"https://local.example/@alice/":         true,
+		"https://local.example/@alice#main-key": true,
+		"https://local.example/@Alice":          true,
+		"https://local.example/@bob":            false,
+		"https://local.example:8443/@alice":     false,
+		"https://remote.example/@alice":         false,
+		"https://local.example/@alice/posts/1":  false,
+	}
+	for actor, want := range tests {
+		follow := &Activity{Actor: actor}
+		if got := h.isSelfFollow("alice", follow); got != want {
+			t.Errorf("%s: expected %v but got %v", actor, want, got)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/labstack/echo"
+)
+
+// inboxEcho is a middleware that adds how the inbox interpreted the activity to the response, for debugging with curl.
+// It works only if Debug is set and the request has the echo query parameter, such as POST /inbox?echo=1.
+// It only rewrites the response; what the inbox stores does not change.
+func (h *Handler) inboxEcho(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !h.Debug || c.QueryParam("echo") == "" {
+			return next(c)
+		}
+
+		res := c.Response()
+		w := &bufferedWriter{ResponseWriter: res.Writer, code: http.StatusOK}
+		res.Writer = w
+		err := next(c)
+		res.Writer = w.ResponseWriter
+		if err != nil {
+			return err
+		}
+
+		body := map[string]any{}
+		if err := json.Unmarshal(w.buf.Bytes(), &body); err != nil {
+			body["body"] = w.buf.String()
+		}
+		body["debug"] = inboxInterpretation(c)
+
+		w.ResponseWriter.WriteHeader(w.code)
+		return json.NewEncoder(w.ResponseWriter).Encode(body)
+	}
+}
+
+// inboxInterpretation reports the activity and the verification result that PostInbox left in the context.
+func inboxInterpretation(c echo.Context) map[string]any {
+	activity, ok := c.Get("activity").(*Activity)
+	if !ok {
+		return map[string]any{"parsed": false}
+	}
+
+	// verified is null if the inbox responded before verifying the signature, such as for a missing type or a blocked instance.
+	verification := map[string]any{"attempted": true, "verified": true}
+	if attempted, _ := c.Get("verifyAttempted").(bool); !attempted {
+		verification = map[string]any{"attempted": false, "verified": nil}
+	} else if err, _ := c.Get("verifyErr").(error); err != nil {
+		verification["verified"] = false
+		verification["error"] = err.Error()
+		verification["code"] = signatureErrorCode(err)
+	}
+
+	return map[string]any{
+		"parsed": true,
+		"type":   activity.Type,
+		"actor":  activity.Actor,
+		"object": map[string]any{
+			"id":   activity.Object.ID,
+			"type": activity.Object.Type(),
+		},
+		"public":    isPublic(append(activity.To, activity.Cc...)),
+		"signature": verification,
+		"activity":  activity.Raw,
+	}
+}
+
+// bufferedWriter holds the response, so that it can be rewritten after the handler returns.
+type bufferedWriter struct {
+	http.ResponseWriter
+	code int
+	buf  bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	w.code = code
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestInboxEcho_verification(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	h.Debug = true
+	remote := newFakeRemote(t, h)
+	actor := remote.actor("carol")
+	h.BlockedDomains = []string{"blocked.example"}
+
+	tests := []struct {
+		Name      string
+		Body      string
+		Code      int
+		Attempted bool
+		Verified  any
+	}{
+		{
+			"verified",
+			fmt.Sprintf(`{"id":"%s/listens/1","type":"Listen","actor":"%s"}`, actor, actor),
+			202, true, true,
+		},
+		{
+			"missing type",
+			fmt.Sprintf(`{"id":"%s/listens/2","actor":"%s"}`, actor, actor),
+			400, false, nil,
+		},
+		{
+			"blocked instance",
+			`{"id":"https://blocked.example/listens/1","type":"Listen","actor":"https://blocked.example/users/mallory"}`,
+			403, false, nil,
+		},
+		{
+			"actor mismatch",
+			`{"id":"https://evil.example/listens/1","type":"Listen","actor":"https://evil.example/users/mallory"}`,
+			401, true, false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := newSignedPost(t, actor+"#main-key", "https://local.example/@alice/inbox?echo=1", tt.Body, nil)
+
+			rec := serve(h, req)
+			if rec.Code != tt.Code {
+				t.Fatalf("expected %d but got %d: %s", tt.Code, rec.Code, rec.Body)
+			}
+
+			var resp struct {
+				Debug struct {
+					Signature map[string]any `json:"signature"`
+				} `json:"debug"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			sig := resp.Debug.Signature
+			if sig["attempted"] != tt.Attempted || sig["verified"] != tt.Verified {
+				t.Errorf("expected attempted=%v verified=%v but got %v", tt.Attempted, tt.Verified, sig)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// KeyStore provides the key pairs of local users.
+type KeyStore interface {
+	PrivateKey(username string) (*rsa.PrivateKey, error)
+	PublicKeyPEM(username string) (string, error)
+}
+
+// sharedKeyName is the name of the key file of FileKeyStore.Shared.
+const sharedKeyName = "shared"
+
+// FileKeyStore keeps a PEM file for each user in Dir. A key is generated and saved on first access.
+type FileKeyStore struct {
+	Dir string
+
+	// Shared makes every user use the one key in shared.pem. It is for hosts that accept any username,
+	// where a key for each requested username would let anyone make the server generate and save keys endlessly.
+	Shared bool
+
+	mu sync.Mutex
+}
+
+func (s *FileKeyStore) PrivateKey(username string) (*rsa.PrivateKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := username
+	if s.Shared {
+		name = sharedKeyName
+	}
+	path := filepath.Join(s.Dir, name+".pem")
+
+	raw, err := os.ReadFile(path)
+	if err == nil {
+		key, err := parsePrivateKeyPEM(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return nil, err
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	err = createFileAtomic(path, pem.EncodeToMemory(block), 0600)
+	if errors.Is(err, os.ErrExist) {
+		// Another process generated the key first. Use theirs, so that the published key does not change.
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return parsePrivateKeyPEM(raw)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// createFileAtomic creates a file with the data, failing with os.ErrExist if the file already exists.
+// The data is written to a temporary file in the same directory and then linked to the path,
+// so the path never holds a partially written file even if the process crashes midway.
+func createFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeFileVia(path, data, perm, os.Link)
+}
+
+// replaceFileAtomic is createFileAtomic that replaces the existing file instead of failing.
+func replaceFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeFileVia(path, data, perm, os.Rename)
+}
+
+// writeFileVia writes the data to a temporary file next to the path, and then moves it to the path by place.
+func writeFileVia(path string, data []byte, perm os.FileMode, place func(tmp, path string) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return place(tmp.Name(), path)
+}
+
+func (s *FileKeyStore) PublicKeyPEM(username string) (string, error) {
+	key, err := s.PrivateKey(username)
+	if err != nil {
+		return "", err
+	}
+	return encodePublicKeyPEM(&key.PublicKey)
+}
+
+// StaticKeyStore uses the same key for every user. It is for single-user deployments that pass the key through an environment variable.
+type StaticKeyStore struct {
+	Key *rsa.PrivateKey
+}
+
+// NewStaticKeyStore parses a PEM encoded private key.
+func NewStaticKeyStore(keyPEM string) (*StaticKeyStore, error) {
+	key, err := parsePrivateKeyPEM([]byte(keyPEM))
+	if err != nil {
+		return nil, err
+	}
+	return &StaticKeyStore{Key: key}, nil
+}
+
+func (s *StaticKeyStore) PrivateKey(username string) (*rsa.PrivateKey, error) {
+	return s.Key, nil
+}
+
+func (s *StaticKeyStore) PublicKeyPEM(username string) (string, error) {
+	return encodePublicKeyPEM(&s.Key.PublicKey)
+}
+
+// KeyRotationTracker notices the rotation of the keys of local users by the change of the public key that is served,
+// since the keys are rotated by replacing them outside of the server. It is safe for concurrent use.
+type KeyRotationTracker struct {
+	sync.RWMutex
+	keys    map[string]string
+	rotated map[string]time.Time
+}
+
+// observe records the public key of the user that is served at now. The first key seen is not a rotation.
+func (t *KeyRotationTracker) observe(username, publicKeyPEM string, now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.keys == nil {
+		t.keys = make(map[string]string)
+		t.rotated = make(map[string]time.Time)
+	}
+	if old, ok := t.keys[username]; ok && old != publicKeyPEM {
+		t.rotated[username] = now
+	}
+	t.keys[username] = publicKeyPEM
+}
+
+// RotatedAt returns when the key of the user was rotated last.
+func (t *KeyRotationTracker) RotatedAt(username string) (time.Time, bool) {
+	t.RLock()
+	defer t.RUnlock()
+
+	at, ok := t.rotated[username]
+	return at, ok
+}
+
+// parsePrivateKeyPEM parses an RSA private key in either PKCS#1 or PKCS#8 form.
+func parsePrivateKeyPEM(raw []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("malformed private key: %w", err)
+	}
+	if k, ok := key.(*rsa.PrivateKey); ok {
+		return k, nil
+	}
+	return nil, errors.New("private key is not RSA")
+}
+
+func encodePublicKeyPEM(key *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+
+	"github.com/labstack/echo"
+)
+
+// RootRedirectProfile is the value of ROOT_REDIRECT to redirect GET / to the profile of the first known user.
+const RootRedirectProfile = "profile"
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<title>{{.Hostname}}</title>
+<h1>{{.Hostname}}</h1>
+<p>this is a sandbox of ActivityPub.</p>
+<h2>users</h2>
+<ul>
+{{- range .Users}}
+<li><a href="{{.URL}}">@{{.Name}}@{{$.Hostname}}</a></li>
+{{- else}}
+<li>any username is accepted.</li>
+{{- end}}
+</ul>
+<h2>endpoints</h2>
+<ul>
+{{- range .Endpoints}}
+<li><a href="{{.}}">{{.}}</a></li>
+{{- end}}
+</ul>
+`))
+
+// GetRoot redirects to RootRedirect, or serves the landing page if it is empty.
+func (h *Handler) GetRoot(c echo.Context) error {
+	users := h.knownUsers()
+
+	switch {
+	case h.RootRedirect == RootRedirectProfile && len(users) > 0:
+		return c.Redirect(302, h.userURL(users[0].Name))
+	case h.RootRedirect != "" && h.RootRedirect != RootRedirectProfile:
+		return c.Redirect(302, h.RootRedirect)
+	}
+
+	type user struct {
+		Name string
+		URL  string
+	}
+	data := struct {
+		Hostname  string
+		Users     []user
+		Endpoints []string
+	}{
+		Hostname: h.Hostname,
+		Endpoints: []string{
+			"/.well-known/webfinger",
+			"/.well-known/host-meta",
+			"/.well-known/nodeinfo",
+			"/inbox",
+		},
+	}
+	for _, u := range users {
+		data.Users = append(data.Users, user{Name: u.Name, URL: h.userURL(u.Name)})
+	}
+
+	var buf bytes.Buffer
+	if err := landingTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+	return c.HTMLBlob(200, buf.Bytes())
+}
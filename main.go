@@ -2,19 +2,34 @@ package main
 
 import (
 	"bytes"
+	_ "embed"
 	"encoding/json"
 	"fmt"
+	"html"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 )
 
+// RequestLogPath is where the received activities are appended as NDJSON, for debugging and replaying.
+// It is a variable so that the tests can replay a fixture.
+var RequestLogPath = "/request.log"
+
+// The root path is skipped, because it is opened by people who are given the instance URL rather than by servers.
 func logRequestForDebug(c echo.Context, body any) {
 	r := c.Request()
+	if r.URL.Path == "/" {
+		return
+	}
 	rec := map[string]any{
 		"datetime": time.Now().Format(time.RFC3339),
 		"remote":   c.RealIP(),
@@ -24,7 +39,7 @@ func logRequestForDebug(c echo.Context, body any) {
 		"body":     body,
 	}
 
-	f, err := os.OpenFile("/request.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	f, err := os.OpenFile(RequestLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return
 	}
@@ -33,20 +48,339 @@ func logRequestForDebug(c echo.Context, body any) {
 	json.NewEncoder(f).Encode(rec)
 }
 
+//go:embed public/header.png
+var defaultHeaderImage []byte
+
 type Handler struct {
-	Hostname string
+	Hostname    string
+	HeaderImage string
+
+	// Users are the local accounts on this host. Any username is accepted if empty.
+	Users []*User
+
+	// SubscribeTemplate is the remote follow URL advertised in WebFinger. /authorize_interaction is used if empty.
+	SubscribeTemplate string
+
+	// RootRedirect is where GET / redirects to. RootRedirectProfile is the profile of the first known user.
+	// A landing page listing the users and the endpoints is served if empty.
+	RootRedirect string
+
+	// Keys provides the key pairs of the local users.
+	Keys KeyStore
+
+	// Client is used for every outgoing request. http.DefaultClient is used if nil.
+	Client *http.Client
+
+	// AdminToken is the bearer token for the /admin API. The admin API is disabled if empty.
+	AdminToken string
+
+	// Debug enables the /debug endpoints.
+	Debug bool
+
+	// DebugSignatures logs the reconstructed signing string when an inbound signature fails to verify.
+	DebugSignatures bool
+
+	// Notes stores notes received via the inbox.
+	Notes NoteStore
+
+	// DirectMessages stores notes received via the inbox that are addressed only to local users.
+	DirectMessages DirectMessageStore
+
+	// Reactions stores Likes, Announces and EmojiReacts received via the inbox.
+	Reactions ReactionStore
+
+	// Posts stores the posts of the local users.
+	Posts PostStore
+
+	// Activities stores activities sent by the local users, such as Accept.
+	Activities ActivityStore
+
+	// Followers and Following store the actor ids of remote followers and followees of each local user.
+	Followers FollowStore
+	Following FollowStore
+
+	// FollowPolicy decides how to answer incoming follow requests. AcceptAllFollows is used if nil.
+	FollowPolicy FollowPolicy
+
+	// SelfFollow is how a Follow from a local user to themselves is answered: SelfFollowReject sends Reject,
+	// SelfFollowError responds 400, and SelfFollowAccept passes it to FollowPolicy. SelfFollowReject is used if empty.
+	SelfFollow string
+
+	// PendingFollows stores follow requests that FollowPolicy deferred.
+	PendingFollows PendingFollowStore
+
+	// OutgoingFollows stores the Follows sent by the local users until the remote actors answer them.
+	OutgoingFollows OutgoingFollowStore
+
+	// FollowMoves makes the local users follow the target of a Move from an account they follow, and unfollow the old one, like Mastodon does.
+	FollowMoves bool
+
+	// FeaturedTags advertises the featuredTags collection of the Hashtags of the user in the actor.
+	FeaturedTags bool
+
+	// AllowedDomains, if set, are the only instances that can deliver to the inbox.
+	// BlockedDomains are the instances that cannot deliver. Only one of them can be set.
+	AllowedDomains []string
+	BlockedDomains []string
+
+	// InsecureSkipVerifyFrom are the networks whose requests are accepted by the inbox even if the signature is invalid or missing.
+	// It is for development only, and empty by default.
+	InsecureSkipVerifyFrom []*net.IPNet
+
+	// DelayMin and DelayMax inject an artificial latency into the actor and inbox responses. Disabled if DelayMax is zero.
+	DelayMin time.Duration
+	DelayMax time.Duration
+
+	// StrictAccept responds 406 if neither HTML nor ActivityStreams JSON is acceptable, instead of falling back to HTML.
+	StrictAccept bool
+
+	// SuspendedActor is how the actors of suspended users are served: SuspendedActorMinimal serves minimalActor,
+	// and SuspendedActorForbidden responds 403. SuspendedActorMinimal is used if empty.
+	SuspendedActor string
+
+	// StrictContentType responds 415 to the inbox requests whose Content-Type is not ActivityStreams, such as application/json.
+	StrictContentType bool
+
+	// PageSize is the number of items in a collection page. DefaultPageSize is used if zero.
+	PageSize int
+
+	// InlineFirstPage embeds the first page into the collection summary instead of linking it by URL.
+	InlineFirstPage bool
+
+	// InlineItemsBelow embeds every item into the collection summary without pages, if the collection has fewer items than this.
+	// Collections are always paged if zero.
+	InlineItemsBelow int
+
+	// Multikey also publishes the key as a Multikey under assertionMethod, in addition to the classic publicKey.
+	Multikey bool
+
+	// CacheMaxAge is the max-age of the actor, collection and object documents. DefaultCacheMaxAge is used if zero.
+	CacheMaxAge time.Duration
+
+	// KeyRotationMaxAge is the max-age of the actor for KeyRotationWindow after its key is rotated.
+	// DefaultKeyRotationMaxAge and DefaultKeyRotationWindow are used if zero.
+	KeyRotationMaxAge time.Duration
+	KeyRotationWindow time.Duration
+
+	// KeyRotations tracks when the key of each user was rotated last.
+	KeyRotations KeyRotationTracker
+
+	// MaxContentLength is the limit of the content of posts created by the admin API. DefaultMaxContentLength is used if zero.
+	// It is counted in bytes, or in characters if CountRunes is set.
+	MaxContentLength int
+	CountRunes       bool
+
+	// NodeInfoMetadata is the metadata block of NodeInfo, such as nodeName. An empty object is served if nil.
+	NodeInfoMetadata map[string]json.RawMessage
+
+	// AcknowledgedTypes are the activity types that the inbox answers 202 and only logs, instead of 400.
+	// DefaultAcknowledgedTypes is used if nil.
+	AcknowledgedTypes []string
+
+	// Language is the language tag of the generated texts, such as "ja". It is emitted as contentMap, summaryMap and nameMap. No language is declared if empty.
+	Language string
+
+	// PrettyJSON indents the ActivityStreams documents for reading them with curl.
+	PrettyJSON bool
+
+	// WebhookURL receives a JSON POST for every activity delivered to the inbox. Disabled if empty.
+	WebhookURL string
+
+	// SignedHeaders are the headers that the signatures of deliveries cover. DefaultSignedHeaders is used if nil.
+	SignedHeaders []string
+
+	// SignatureFormat is the format of the signatures of deliveries, either SignatureFormatCavage or SignatureFormatRFC9421.
+	// draft-cavage is used if empty. Incoming requests are verified in either format regardless of this.
+	SignatureFormat string
+
+	// Canonicalizer converts bodies before digesting them, for both signing and verifying. RawBytes is used if nil.
+	Canonicalizer Canonicalizer
+
+	// Compactor rewrites the served ActivityStreams documents, such as JSON-LD compaction. They are served as built if nil.
+	Compactor Compactor
+
+	// UsersPath makes /users/:username the canonical actor id instead of /@:username. Both forms are served either way.
+	UsersPath bool
+
+	// MaxJSONDepth is the nesting limit of activities posted to the inbox. DefaultMaxJSONDepth is used if zero.
+	MaxJSONDepth int
+
+	// MaxBodySize is the size limit in bytes of activities posted to the inbox. DefaultMaxBodySize is used if zero.
+	MaxBodySize int64
+
+	// Actors resolves the remote actors to deliver to. They are fetched over HTTP and cached in ActorCache if nil.
+	Actors ActorResolver
+
+	// ActorCache keeps the fetched actors for ActorCacheTTL. DefaultActorCacheTTL is used if zero.
+	ActorCache    ActorCache
+	ActorCacheTTL time.Duration
+
+	// Retry is the backoff schedule of failed deliveries. DefaultRetryPolicy is used if zero.
+	Retry RetryPolicy
+
+	// DeliveryWorkers is the number of concurrent deliveries. DefaultDeliveryWorkers is used if zero.
+	DeliveryWorkers int
+
+	// FanOutBatchSize is the number of concurrent deliveries of one activity. DefaultFanOutBatchSize is used if zero.
+	FanOutBatchSize int
+
+	// FetchBudget is the number of remote fetches allowed while processing one inbound activity.
+	// FetchMaxDepth is how deep nested object references are followed. DefaultFetchBudget and DefaultFetchMaxDepth are used if zero.
+	FetchBudget   int
+	FetchMaxDepth int
+
+	// FetchMaxRedirects is how many redirects a remote fetch follows within the same host. DefaultFetchMaxRedirects is used if zero.
+	FetchMaxRedirects int
+
+	// Deliveries tracks the state of the delivery queue for /debug/queue.
+	Deliveries DeliveryTracker
+
+	// Receipts keeps what happened to the deliveries of each note for /debug/receipts.
+	Receipts ReceiptStore
+
+	// ReachabilityInterval is how often the followers are probed. The check is disabled if zero.
+	// PruneUnreachableAfter removes the followers that failed the check that many times in a row. They are only reported if zero.
+	ReachabilityInterval  time.Duration
+	PruneUnreachableAfter int
+
+	// Reachability tracks the followers that failed the reachability check for /debug/reachability.
+	Reachability ReachabilityTracker
+
+	queue      chan *delivery
+	startQueue sync.Once
+}
+
+func (h *Handler) maxJSONDepth() int {
+	if h.MaxJSONDepth > 0 {
+		return h.MaxJSONDepth
+	}
+	return DefaultMaxJSONDepth
+}
+
+func (h *Handler) maxBodySize() int64 {
+	if h.MaxBodySize > 0 {
+		return h.MaxBodySize
+	}
+	return DefaultMaxBodySize
+}
+
+// client returns the HTTP client for outgoing requests.
+func (h *Handler) client() *http.Client {
+	if h.Client != nil {
+		return h.Client
+	}
+	return http.DefaultClient
+}
+
+func (h *Handler) followPolicy() FollowPolicy {
+	if h.FollowPolicy != nil {
+		return h.FollowPolicy
+	}
+	return AcceptAllFollows
+}
+
+// baseURL returns the public origin of this server, such as "https://example.com".
+func (h *Handler) baseURL() string {
+	return "https://" + h.Hostname
+}
+
+// actorPaths returns the path prefixes that actors are served at, the canonical one first.
+func (h *Handler) actorPaths() []string {
+	if h.UsersPath {
+		return []string{"/users/", "/@"}
+	}
+	return []string{"/@", "/users/"}
+}
+
+// userURL returns the actor id of the local user.
+func (h *Handler) userURL(username string) string {
+	return h.baseURL() + h.actorPaths()[0] + username
+}
+
+// userURLs returns every URL that the local user is served at, the canonical actor id first.
+func (h *Handler) userURLs(username string) []string {
+	var urls []string
+	for _, p := range h.actorPaths() {
+		urls = append(urls, h.baseURL()+p+username)
+	}
+	return urls
+}
+
+// trimActorPath strips the origin and the actor path prefix of either form from a local URL,
+// and returns the rest such as "alice/posts/1".
+func (h *Handler) trimActorPath(id string) (string, bool) {
+	for _, p := range h.actorPaths() {
+		if rest, ok := strings.CutPrefix(id, h.baseURL()+p); ok {
+			return rest, true
+		}
+	}
+	return "", false
+}
+
+// localUsername extracts the username from an actor id of a local user.
+func (h *Handler) localUsername(id string) (string, bool) {
+	username, ok := h.trimActorPath(id)
+	if !ok || strings.ContainsAny(username, "/?#") {
+		return "", false
+	}
+	u, ok := h.lookupUser(username)
+	if !ok {
+		return "", false
+	}
+	return u.Name, true
 }
 
+// getOrHead is the methods for the public endpoints. HEAD is answered by the GET handler; net/http drops the body.
+var getOrHead = []string{"GET", "HEAD"}
+
 func (h *Handler) RegisterRoutes(e *echo.Echo) {
-	e.GET("/.well-known/nodeinfo", h.GetNodeInfo)
-	e.GET("/.well-known/host-meta", h.GetHostMeta)
-	e.GET("/.well-known/webfinger", h.GetWebFinger)
-	e.GET("/@:username", h.GetUser)
-	e.GET("/@:username/icon.png", h.GetIcon)
-	e.POST("/@:username/inbox", h.PostInbox)
-	e.GET("/@:username/outbox", h.GetOutbox)
-	e.GET("/@:username/followers", h.GetFollowers)
-	e.GET("/@:username/following", h.GetFollowing)
+	e.Pre(middleware.RemoveTrailingSlash())
+
+	e.Match(getOrHead, "/", h.GetRoot)
+	e.Match(getOrHead, "/.well-known/nodeinfo", h.GetNodeInfoDiscovery)
+	e.Match(getOrHead, "/nodeinfo/:version", h.GetNodeInfo)
+	e.Match(getOrHead, "/.well-known/host-meta", h.GetHostMeta)
+	e.Match(getOrHead, "/.well-known/webfinger", h.GetWebFinger)
+	e.GET("/authorize_interaction", h.GetAuthorizeInteraction)
+	e.POST("/inbox", h.PostInbox, h.inboxEcho, h.injectDelay)
+	for _, p := range h.actorPaths() {
+		user := p + ":username"
+		e.Match(getOrHead, user, h.GetUser, h.actorCacheControl, h.requireUser, h.injectDelay)
+		e.Match(getOrHead, user+"/icon.png", h.GetIcon, h.requireUser)
+		e.Match(getOrHead, user+"/header.png", h.GetHeader, h.requireUser)
+		e.POST(user+"/inbox", h.PostInbox, h.inboxEcho, h.requireUser, h.injectDelay)
+		e.Match(getOrHead, user+"/outbox", h.GetOutbox, h.cacheControl, h.requireUser)
+		e.Match(getOrHead, user+"/posts/:id", h.GetPost, h.cacheControl, h.requireUser)
+		e.Match(getOrHead, user+"/posts/:id/context", h.GetPostContext, h.cacheControl, h.requireUser)
+		e.Match(getOrHead, user+"/activities/:id", h.GetActivity, h.cacheControl, h.requireUser)
+		e.Match(getOrHead, user+"/followers", h.GetFollowers, h.cacheControl, h.requireUser)
+		e.Match(getOrHead, user+"/following", h.GetFollowing, h.cacheControl, h.requireUser)
+		e.Match(getOrHead, user+"/collections/tags", h.GetFeaturedTags, h.cacheControl, h.requireUser)
+	}
+
+	admin := e.Group("/admin", bearerAuth(h.AdminToken))
+	admin.POST("/posts", h.PostAdminPosts)
+	admin.DELETE("/posts/:username/:id", h.DeleteAdminPost)
+	admin.POST("/posts/:username/:id/delete", h.DeleteAdminPost)
+	admin.GET("/pending-follows", h.GetAdminPendingFollows)
+	admin.POST("/follows", h.PostAdminFollows)
+	admin.GET("/follows", h.GetAdminFollows)
+	admin.POST("/replay", h.PostAdminReplay)
+	admin.POST("/pending-follows/:id/accept", h.PostAdminAcceptFollow)
+	admin.POST("/pending-follows/:id/reject", h.PostAdminRejectFollow)
+
+	if h.Debug {
+		e.GET("/debug/notes", h.GetDebugNotes)
+		e.GET("/debug/timeline", h.GetDebugTimeline)
+		e.GET("/debug/reactions", h.GetDebugReactions)
+		e.GET("/debug/reactions/counts", h.GetDebugReactionCounts)
+		e.GET("/debug/direct/:username", h.GetDebugDirectMessages, h.requireUser)
+		e.POST("/debug/parse", h.PostDebugParse)
+		e.GET("/debug/keys", h.GetDebugKeys, bearerAuth(h.AdminToken))
+		e.GET("/debug/queue", h.GetDebugQueue, bearerAuth(h.AdminToken))
+		e.GET("/debug/receipts/:username", h.GetDebugReceipts, bearerAuth(h.AdminToken), h.requireUser)
+		e.GET("/debug/reachability", h.GetDebugReachability, bearerAuth(h.AdminToken))
+	}
 }
 
 type XRD struct {
@@ -59,9 +393,85 @@ type XRDLink struct {
 	Template string `xml:"template,attr"`
 }
 
+// NodeInfoVersions are the served versions of the NodeInfo schema, oldest first.
+var NodeInfoVersions = []string{"2.0", "2.1"}
+
+// themeColorPattern matches the hex colors accepted as the themeColor of NodeInfo, such as "#6364ff".
+var themeColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+
+// validateNodeInfoMetadata checks the well-known fields of the metadata that instance directories read.
+// nodeName and nodeDescription must be strings, and themeColor must be a hex color. Other fields are served as is.
+func validateNodeInfoMetadata(metadata map[string]json.RawMessage) error {
+	for _, key := range []string{"nodeName", "nodeDescription", "themeColor"} {
+		raw, ok := metadata[key]
+		if !ok {
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return fmt.Errorf("%s must be a string: %s", key, raw)
+		}
+		if key == "themeColor" && !themeColorPattern.MatchString(s) {
+			return fmt.Errorf("themeColor must be a hex color such as \"#6364ff\": %q", s)
+		}
+	}
+	return nil
+}
+
+// GetNodeInfoDiscovery serves the links to the NodeInfo document of each version.
+func (h *Handler) GetNodeInfoDiscovery(c echo.Context) error {
+	links := make([]map[string]string, len(NodeInfoVersions))
+	for i, v := range NodeInfoVersions {
+		links[i] = map[string]string{
+			"rel":  "http://nodeinfo.diaspora.software/ns/schema/" + v,
+			"href": h.baseURL() + "/nodeinfo/" + v,
+		}
+	}
+	return c.JSON(200, map[string]any{
+		"links": links,
+	})
+}
+
+// GetNodeInfo serves the NodeInfo document of the version in the path.
+// 2.0 and 2.1 share the same fields here, because the software block has no repository nor homepage that only 2.1 allows.
 func (h *Handler) GetNodeInfo(c echo.Context) error {
+	version := c.Param("version")
+	found := false
+	for _, v := range NodeInfoVersions {
+		found = found || v == version
+	}
+	if !found {
+		return c.JSON(404, map[string]string{
+			"error": "unsupported nodeinfo version",
+		})
+	}
+
+	users := h.knownUsers()
+	total := len(users)
+	if total == 0 {
+		total = 1
+	}
+
+	now := time.Now()
+	activeMonth, activeHalfyear := 0, 0
+	for _, u := range users {
+		last := h.lastStatusAt(u)
+		if now.Sub(last) <= 30*24*time.Hour {
+			activeMonth++
+		}
+		if now.Sub(last) <= 180*24*time.Hour {
+			activeHalfyear++
+		}
+	}
+
+	metadata := h.NodeInfoMetadata
+	if metadata == nil {
+		metadata = map[string]json.RawMessage{}
+	}
+
+	c.Response().Header().Set("Content-Type", `application/json; profile="http://nodeinfo.diaspora.software/ns/schema/`+version+`#"`)
 	return c.JSON(200, map[string]any{
-		"version": "2.1",
+		"version": version,
 		"software": map[string]string{
 			"name":    "activitypub-sandbox",
 			"version": "0.0.1",
@@ -69,11 +479,19 @@ func (h *Handler) GetNodeInfo(c echo.Context) error {
 		"protocols": []string{
 			"activitypub",
 		},
+		"services": map[string][]string{
+			"inbound":  {},
+			"outbound": {},
+		},
+		"openRegistrations": false,
 		"usage": map[string]any{
 			"users": map[string]int{
-				"total": 1,
+				"total":          total,
+				"activeMonth":    activeMonth,
+				"activeHalfyear": activeHalfyear,
 			},
 		},
+		"metadata": metadata,
 	})
 }
 
@@ -100,37 +518,61 @@ func (h *Handler) GetWebFinger(c echo.Context) error {
 	if strings.HasPrefix(username, "acct:") {
 		username = username[len("acct:"):]
 	}
-	if username[0] == '@' {
-		username = username[1:]
+	username = strings.TrimPrefix(username, "@")
+
+	user, ok := h.lookupUser(username)
+	if !ok {
+		return c.JSON(404, map[string]string{
+			"error": "not found",
+		})
 	}
+	username = user.Name
 
 	return c.JSON(200, map[string]any{
 		"subject": fmt.Sprintf("acct:%s@%s", username, h.Hostname),
-		"aliases": []string{
-			fmt.Sprintf("https://%s/@%s", c.Request().Host, username),
-		},
+		"aliases": h.userURLs(username),
 		"links": []map[string]string{
 			{
 				"rel":  "http://webfinger.net/rel/profile-page",
 				"type": "text/html",
-				"href": fmt.Sprintf("https://%s/@%s", c.Request().Host, username),
+				"href": h.userURL(username),
 			},
 			{
 				"rel":  "self",
 				"type": "application/activity+json",
-				"href": fmt.Sprintf("https://%s/@%s", c.Request().Host, username),
+				"href": h.userURL(username),
+			},
+			{
+				"rel":      "http://ostatus.org/schema/1.0/subscribe",
+				"template": h.subscribeTemplate(),
 			},
 		},
 	})
 }
 
+// subscribeTemplate returns the URL template for remote follow, where {uri} is replaced with the account to follow.
+func (h *Handler) subscribeTemplate() string {
+	if h.SubscribeTemplate != "" {
+		return h.SubscribeTemplate
+	}
+	return h.baseURL() + "/authorize_interaction?uri={uri}"
+}
+
+// GetAuthorizeInteraction is a placeholder of the remote follow page.
+func (h *Handler) GetAuthorizeInteraction(c echo.Context) error {
+	return c.HTML(200, fmt.Sprintf(`<h1>Interact with %s</h1>not implemented yet.`, html.EscapeString(c.QueryParam("uri"))))
+}
+
 func (h *Handler) GetUser(c echo.Context) error {
-	accepts := strings.Split(c.Request().Header.Get("Accept"), ",")
+	repr, ok := negotiate(c.Request().Header.Get("Accept"))
+	if !ok && h.StrictAccept {
+		return c.JSON(406, map[string]string{
+			"error": "not acceptable",
+		})
+	}
 
-	for _, accept := range accepts {
-		if strings.TrimSpace(accept) == "application/activity+json" {
-			return h.GetUserActor(c)
-		}
+	if repr == RepresentationActivity {
+		return h.GetUserActor(c)
 	}
 	return h.GetUserPage(c)
 }
@@ -139,224 +581,567 @@ func (h *Handler) GetIcon(c echo.Context) error {
 	return c.File("public/icon.png")
 }
 
+func (h *Handler) GetHeader(c echo.Context) error {
+	if h.HeaderImage != "" {
+		return c.File(h.HeaderImage)
+	}
+	http.ServeContent(c.Response(), c.Request(), "header.png", time.Time{}, bytes.NewReader(defaultHeaderImage))
+	return nil
+}
+
 func (h *Handler) GetUserPage(c echo.Context) error {
 	username := c.Param("username")
+	user, _ := h.lookupUser(username)
 
-	return c.HTML(200, fmt.Sprintf(`<h1>@%s</h1>not implemented yet.`, username))
+	return c.HTML(200, fmt.Sprintf(
+		`<h1>@%s</h1><p>last status at <time>%s</time></p>not implemented yet.`,
+		html.EscapeString(username),
+		h.lastStatusAt(user).Format(time.RFC3339),
+	))
 }
 
 func (h *Handler) GetUserActor(c echo.Context) error {
-	username := c.Param("username")
+	build := h.userActor
+	if h.isSuspended(c.Param("username")) {
+		if h.suspendedActor() == SuspendedActorForbidden {
+			return c.JSON(403, map[string]string{
+				"error": "account suspended",
+			})
+		}
+		build = h.minimalActor
+	}
 
-	return c.JSON(200, map[string]any{
-		"@context": []string{
-			"https://www.w3.org/ns/activitystreams",
-			"https://w3id.org/security/v1",
+	actor, err := build(c.Param("username"))
+	if err != nil {
+		c.Logger().Printf("failed to build actor: %s", err)
+		return c.JSON(500, map[string]string{
+			"error": "internal server error",
+		})
+	}
+	return h.activityJSON(c, 200, actor)
+}
+
+// userActor builds the actor document of the local user.
+func (h *Handler) userActor(username string) (map[string]any, error) {
+	actor := h.userURL(username)
+	user, _ := h.lookupUser(username)
+
+	publicKey, err := h.Keys.PublicKeyPEM(username)
+	if err != nil {
+		return nil, err
+	}
+	h.KeyRotations.observe(username, publicKey, time.Now())
+
+	doc := map[string]any{
+		"@context": []any{
+			ActivityStreamsContext,
+			SecurityContext,
 		},
-		"id":                fmt.Sprintf("https://%s/@%s", h.Hostname, username),
-		"type":              "Person",
+		"id":                actor,
+		"type":              user.actorType(),
 		"name":              "DEBUG",
 		"preferredUsername": username,
 		"summary":           "<p>デバッグ用ニセアカウント。</p>",
-		"published":         "2023-08-14T20:38:00+09:00",
+		"published":         user.published().Format(time.RFC3339),
 		"icon": map[string]string{
 			"type":      "Image",
 			"mediaType": "image/png",
-			"url":       fmt.Sprintf("https://%s/@%s/icon.png", h.Hostname, username),
+			"url":       actor + "/icon.png",
+		},
+		"image": map[string]string{
+			"type":      "Image",
+			"mediaType": "image/png",
+			"url":       actor + "/header.png",
+		},
+		"url":       actor,
+		"inbox":     actor + "/inbox",
+		"outbox":    actor + "/outbox",
+		"followers": actor + "/followers",
+		"following": actor + "/following",
+		"endpoints": map[string]string{
+			"sharedInbox": h.baseURL() + "/inbox",
 		},
-		"url":       fmt.Sprintf("https://%s/@%s", c.Request().Host, username),
-		"inbox":     fmt.Sprintf("https://%s/@%s/inbox", c.Request().Host, username),
-		"outbox":    fmt.Sprintf("https://%s/@%s/outbox", c.Request().Host, username),
-		"followers": fmt.Sprintf("https://%s/@%s/followers", c.Request().Host, username),
-		"following": fmt.Sprintf("https://%s/@%s/following", c.Request().Host, username),
 		"publicKey": map[string]string{
-			"id":           fmt.Sprintf("https://%s/@%s#main-key", c.Request().Host, username),
-			"owner":        fmt.Sprintf("https://%s/@%s", c.Request().Host, username),
-			"publicKeyPem": "",
+			"id":           actor + "#main-key",
+			"owner":        actor,
+			"publicKeyPem": publicKey,
 		},
-	})
-}
-
-func (h *Handler) PostInbox(c echo.Context) error {
-	var request map[string]any
-	if err := json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
-		return c.JSON(400, map[string]string{
-			"error": "invalid request",
-		})
 	}
 
-	logRequestForDebug(c, request)
+	if len(user.Links) > 0 {
+		links := make([]map[string]string, len(user.Links))
+		for i, l := range user.Links {
+			links[i] = map[string]string{
+				"type":      "Link",
+				"href":      l.Href,
+				"mediaType": l.MediaType,
+			}
+		}
+		doc["url"] = links
+	}
 
-	switch request["type"] {
-	case "Follow":
-		return h.PostInboxFollow(c, request)
-	case "Undo":
-		return h.PostInboxUndo(c, request)
-	default:
-		return c.JSON(400, map[string]string{
-			"error": fmt.Sprintf("unsupported type: %q", request["type"]),
+	if len(user.AlsoKnownAs) > 0 {
+		doc["@context"] = append(doc["@context"].([]any), map[string]any{
+			"alsoKnownAs": map[string]string{
+				"@id":   "as:alsoKnownAs",
+				"@type": "@id",
+			},
 		})
+		doc["alsoKnownAs"] = append([]string{}, user.AlsoKnownAs...)
 	}
-}
-
-func (h *Handler) PostInboxFollow(c echo.Context, request map[string]any) error {
-	username := c.Param("username")
 
-	var accept bytes.Buffer
-	if err := json.NewEncoder(&accept).Encode(map[string]any{
-		"@context": "https://www.w3.org/ns/activitystreams",
-		"id":       fmt.Sprintf("https://%s/@%s#follow", h.Hostname, username),
-		"type":     "Accept",
-		"actor":    fmt.Sprintf("https://%s/@%s", h.Hostname, username),
-		"object":   request,
-	}); err != nil {
-		return c.JSON(500, map[string]string{
-			"error": "internal server error",
+	terms := map[string]any{
+		"toot":    "http://joinmastodon.org/ns#",
+		"Emoji":   "toot:Emoji",
+		"Hashtag": "as:Hashtag",
+	}
+	if h.FeaturedTags {
+		terms["featuredTags"] = map[string]string{
+			"@id":   "toot:featuredTags",
+			"@type": "@id",
+		}
+		doc["featuredTags"] = actor + "/collections/tags"
+	}
+	doc["@context"] = append(doc["@context"].([]any), terms)
+	if user.Discoverable != nil {
+		doc["@context"] = append(doc["@context"].([]any), map[string]string{
+			"discoverable": "toot:discoverable",
 		})
+		doc["discoverable"] = *user.Discoverable
+	}
+	if len(user.Emojis) > 0 || len(user.Hashtags) > 0 {
+		doc["tag"] = h.actorTags(user)
 	}
 
-	req, err := http.NewRequest("POST", request["actor"].(string), &accept)
-	if err != nil {
-		c.Logger().Printf("failed to prepare follow accept message: %s", err)
-		return c.JSON(500, map[string]string{
-			"error": "internal server error",
+	if len(user.Fields) > 0 {
+		doc["@context"] = append(doc["@context"].([]any), map[string]any{
+			"schema":        "http://schema.org#",
+			"PropertyValue": "schema:PropertyValue",
+			"value":         "schema:value",
 		})
+		attachment := make([]map[string]string, len(user.Fields))
+		for i, f := range user.Fields {
+			attachment[i] = map[string]string{
+				"type":  "PropertyValue",
+				"name":  f.Name,
+				"value": f.Value,
+			}
+		}
+		doc["attachment"] = attachment
 	}
 
-	req.Header.Set("Content-Type", "application/activity+json")
+	if h.Multikey {
+		key, err := h.Keys.PrivateKey(username)
+		if err != nil {
+			return nil, err
+		}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		c.Logger().Printf("failed to send follow accept message: %s", err)
-		return c.JSON(500, map[string]string{
-			"error": "internal server error",
-		})
+		doc["@context"] = append(doc["@context"].([]any), MultikeyContext)
+		doc["assertionMethod"] = []map[string]string{{
+			"id":                 actor + "#multikey",
+			"type":               "Multikey",
+			"controller":         actor,
+			"publicKeyMultibase": encodeMultikey(&key.PublicKey),
+		}}
 	}
 
-	if resp.StatusCode != 200 {
-		c.Logger().Printf("follow accept message has denied: %s", err)
-		return c.JSON(500, map[string]string{
-			"error": "internal server error",
+	h.addLanguageMaps(doc, "name", "summary")
+
+	return doc, nil
+}
+
+// actorTags builds the tag field of the actor from the custom emojis and the hashtags of the user.
+func (h *Handler) actorTags(user *User) []map[string]any {
+	tags := []map[string]any{}
+	for _, e := range user.Emojis {
+		tags = append(tags, map[string]any{
+			"id":   h.baseURL() + "/emojis/" + url.PathEscape(e.Shortcode),
+			"type": "Emoji",
+			"name": ":" + e.Shortcode + ":",
+			"icon": map[string]string{
+				"type":      "Image",
+				"mediaType": e.MediaType,
+				"url":       e.URL,
+			},
 		})
 	}
+	for _, t := range user.Hashtags {
+		tags = append(tags, h.hashtagObject(t))
+	}
+	return tags
+}
 
-	return c.JSON(200, map[string]string{
-		"status": "accepted",
-	})
+// hashtagObject builds a Hashtag of the name without #.
+func (h *Handler) hashtagObject(name string) map[string]any {
+	return map[string]any{
+		"type": "Hashtag",
+		"name": "#" + name,
+		"href": h.baseURL() + "/tags/" + url.PathEscape(name),
+	}
 }
 
-func (h *Handler) PostInboxUndo(c echo.Context, request map[string]any) error {
-	return c.JSON(200, map[string]string{
-		"status": "accepted",
+// GetFeaturedTags serves the featured hashtags of the user, which Mastodon shows on the profile.
+func (h *Handler) GetFeaturedTags(c echo.Context) error {
+	user, _ := h.lookupUser(c.Param("username"))
+
+	items := []map[string]any{}
+	for _, t := range user.Hashtags {
+		items = append(items, h.hashtagObject(t))
+	}
+
+	return h.activityJSON(c, 200, map[string]any{
+		"@context":   []any{ActivityStreamsContext, map[string]string{"Hashtag": "as:Hashtag"}},
+		"id":         h.userURL(user.Name) + "/collections/tags",
+		"type":       "Collection",
+		"totalItems": len(items),
+		"items":      items,
 	})
 }
 
 func (h *Handler) GetOutbox(c echo.Context) error {
 	username := c.Param("username")
+	typ := c.QueryParam("type")
+
+	if minID, maxID := c.QueryParam("min_id"), c.QueryParam("max_id"); minID != "" || maxID != "" {
+		page, ok := h.outboxCursorPage(username, typ, minID, maxID)
+		if !ok {
+			return c.JSON(400, map[string]string{
+				"error": "invalid min_id or max_id",
+			})
+		}
+		return h.activityJSON(c, 200, withContext(page))
+	}
+
+	if c.QueryParam("page") == "" {
+		return h.activityJSON(c, 200, h.outboxCollection(username, typ))
+	}
 
-	page := c.QueryParam("page")
-	if page == "" {
-		return c.JSON(200, map[string]any{
-			"@context":   "https://www.w3.org/ns/activitystreams",
-			"id":         fmt.Sprintf("https://%s/@%s", h.Hostname, username),
-			"type":       "OrderedCollection",
-			"totalItems": 1,
-			"first":      fmt.Sprintf("https://%s/@%s?page=0", h.Hostname, username),
-			"last":       fmt.Sprintf("https://%s/@%s?page=0", h.Hostname, username),
+	page, ok := parsePage(c.QueryParam("page"))
+	if !ok {
+		return c.JSON(400, map[string]string{
+			"error": "invalid page",
 		})
+	}
+	return h.activityJSON(c, 200, withContext(h.outboxPage(username, typ, page)))
+}
+
+// activityJSON sends an ActivityStreams document as application/activity+json.
+func (h *Handler) activityJSON(c echo.Context, code int, doc any) error {
+	if h.Compactor != nil {
+		compacted, err := h.Compactor.Compact(doc)
+		if err != nil {
+			c.Logger().Printf("failed to compact the document; serving it as is: %s", err)
+		} else {
+			doc = compacted
+		}
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/activity+json; charset=utf-8")
+	c.Response().WriteHeader(code)
+
+	enc := json.NewEncoder(c.Response())
+	enc.SetEscapeHTML(false)
+	if h.PrettyJSON {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(doc)
+}
+
+// addLanguageMaps adds the natural language forms such as contentMap for the fields, tagged with Language.
+// Nothing is added if Language is empty.
+func (h *Handler) addLanguageMaps(doc map[string]any, fields ...string) {
+	if h.Language == "" {
+		return
+	}
+	for _, f := range fields {
+		if v, ok := doc[f].(string); ok && v != "" {
+			doc[f+"Map"] = map[string]string{h.Language: v}
+		}
+	}
+}
+
+// withContext adds the ActivityStreams @context to a document built without it.
+func withContext(doc map[string]any) map[string]any {
+	doc["@context"] = ActivityStreamsContext
+	return doc
+}
+
+// outboxURL returns the id of the outbox. If typ is not empty, it is the id of the outbox filtered by the activity type.
+func (h *Handler) outboxURL(username, typ string) string {
+	outbox := h.userURL(username) + "/outbox"
+	if typ != "" {
+		outbox += "?type=" + url.QueryEscape(typ)
+	}
+	return outbox
+}
+
+// outboxCollection builds the summary of the outbox collection.
+func (h *Handler) outboxCollection(username, typ string) map[string]any {
+	collection := orderedCollectionOf(h, h.outboxURL(username, typ), h.outboxItems(username, typ))
+	if _, inlined := collection["orderedItems"]; h.InlineFirstPage && !inlined {
+		collection["first"] = h.outboxPage(username, typ, 0)
+	}
+	return collection
+}
+
+// outboxPage builds a page of the outbox without @context, so that it can be embedded in the collection.
+func (h *Handler) outboxPage(username, typ string, page int) map[string]any {
+	return orderedCollectionPage(h, h.outboxURL(username, typ), h.outboxItems(username, typ), page)
+}
+
+// outboxCursorPage builds a page of the outbox by the post id cursors, like the min_id and max_id of Mastodon, without @context.
+// max_id selects the newest posts older than it, and min_id selects the oldest posts newer than it. Items are newest first either way.
+func (h *Handler) outboxCursorPage(username, typ, minID, maxID string) (map[string]any, bool) {
+	parse := func(s string) (int64, bool) {
+		if s == "" {
+			return 0, true
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		return n, err == nil && n >= 0
+	}
+	after, ok := parse(minID)
+	if !ok {
+		return nil, false
+	}
+	before, ok := parse(maxID)
+	if !ok {
+		return nil, false
+	}
+
+	type entry struct {
+		id       int64
+		activity map[string]any
+	}
+	var all, matched []entry
+	for _, p := range h.Posts.History(username) {
+		activity := h.outboxActivity(p)
+		if typ != "" && activity["type"] != typ {
+			continue
+		}
+		id, _ := strconv.ParseInt(p.ID, 10, 64)
+		all = append(all, entry{id, activity})
+		if (minID == "" || id > after) && (maxID == "" || id < before) {
+			matched = append(matched, entry{id, activity})
+		}
+	}
+
+	size := h.pageSize()
+	if len(matched) > size {
+		if minID != "" {
+			matched = matched[len(matched)-size:]
+		} else {
+			matched = matched[:size]
+		}
+	}
+
+	outbox := h.outboxURL(username, typ)
+	items := make([]map[string]any, 0, len(matched))
+	for _, e := range matched {
+		items = append(items, e.activity)
+	}
+	page := map[string]any{
+		"type":         "OrderedCollectionPage",
+		"partOf":       outbox,
+		"orderedItems": items,
+	}
+	if minID != "" {
+		page["id"] = cursorURL(outbox, "min_id", minID)
 	} else {
-		return c.JSON(200, map[string]any{
-			"@context": "https://www.w3.org/ns/activitystreams",
-			"id":       fmt.Sprintf("https://%s/@%s/outbox?page=0", h.Hostname, username),
-			"type":     "OrderedCollectionPage",
-			"partOf":   fmt.Sprintf("https://%s/@%s/outbox", h.Hostname, username),
-			"orderedItems": []map[string]any{{
-				"id":        fmt.Sprintf("https://%s/@%s/posts/12345", h.Hostname, username),
-				"type":      "Create",
-				"published": "2023-08-13T11:32:00Z",
-				"actor":     fmt.Sprintf("https://%s/@%s", h.Hostname, username),
-				"to": []string{
-					"https://www.w3.org/ns/activitystreams#Public",
-				},
-				"cc": []string{
-					fmt.Sprintf("https://%s/@%s/followers", h.Hostname, username),
-				},
-				"object": map[string]any{
-					"id":           fmt.Sprintf("https://%s/@%s/posts/12345", h.Hostname, username),
-					"type":         "Note",
-					"published":    "2023-08-13T11:32:00Z",
-					"attributedTo": fmt.Sprintf("https://%s/@%s", h.Hostname, username),
-					"to": []string{
-						"https://www.w3.org/ns/activitystreams#Public",
-					},
-					"cc": []string{
-						fmt.Sprintf("https://%s/@%s/followers", h.Hostname, username),
-					},
-					"content": "Hello, world!",
-				},
-			}},
-		})
+		page["id"] = cursorURL(outbox, "max_id", maxID)
+	}
+
+	if len(matched) > 0 {
+		newest, oldest := matched[0].id, matched[len(matched)-1].id
+		if all[0].id > newest {
+			page["prev"] = cursorURL(outbox, "min_id", strconv.FormatInt(newest, 10))
+		}
+		if all[len(all)-1].id < oldest {
+			page["next"] = cursorURL(outbox, "max_id", strconv.FormatInt(oldest, 10))
+		}
+	}
+	return page, true
+}
+
+// outboxItems returns the activities in the outbox of the user, newest first. Deleted posts appear as their Delete.
+// If typ is not empty, only the activities of the type are returned.
+func (h *Handler) outboxItems(username, typ string) []map[string]any {
+	posts := h.Posts.History(username)
+
+	items := make([]map[string]any, 0, len(posts))
+	for _, p := range posts {
+		activity := h.outboxActivity(p)
+		if typ == "" || activity["type"] == typ {
+			items = append(items, activity)
+		}
 	}
+	return items
 }
 
 func (h *Handler) GetFollowers(c echo.Context) error {
 	username := c.Param("username")
-	page := c.QueryParam("page")
-
-	if page == "" {
-		return c.JSON(200, map[string]any{
-			"@context":   "https://www.w3.org/ns/activitystreams",
-			"id":         fmt.Sprintf("https://%s/@%s/followers", h.Hostname, username),
-			"type":       "OrderedCollection",
-			"totalItems": 314159265,
-			"first":      fmt.Sprintf("https://%s/@%s/followers?page=0", h.Hostname, username),
+
+	switch h.hideNetwork(username) {
+	case HideNetworkForbidden:
+		return c.JSON(403, map[string]string{
+			"error": "followers of this user are hidden",
 		})
-	} else {
-		return c.JSON(200, map[string]any{
-			"@context": "https://www.w3.org/ns/activitystreams",
-			"id":       fmt.Sprintf("https://%s/@%s/followers?page=0", h.Hostname, username),
-			"type":     "OrderedCollectionPage",
-			"partOf":   fmt.Sprintf("https://%s/@%s/followers", h.Hostname, username),
-			"orderedItems": []string{
-				"https://mstdn.jp/users/macrat",
-			},
-			"next": fmt.Sprintf("https://%s/@%s/followers?page=1", h.Hostname, username),
+	case HideNetworkCount:
+		return h.activityJSON(c, 200, h.followersCollection(username))
+	}
+
+	repr, ok := negotiateCollection(c.Request().Header.Get("Accept"))
+	if !ok && h.StrictAccept {
+		return c.JSON(406, map[string]string{
+			"error": "not acceptable",
+		})
+	}
+	if repr == RepresentationHTML {
+		return actorListHTML(c, "Followers of @"+username, h.Followers.List(username))
+	}
+
+	if c.QueryParam("page") == "" {
+		return h.activityJSON(c, 200, h.followersCollection(username))
+	}
+
+	page, ok := parsePage(c.QueryParam("page"))
+	if !ok {
+		return c.JSON(400, map[string]string{
+			"error": "invalid page",
 		})
 	}
+	return h.activityJSON(c, 200, withContext(h.followersPage(username, page)))
+}
+
+// followersCollection builds the summary of the followers collection.
+func (h *Handler) followersCollection(username string) map[string]any {
+	id, followers := h.userURL(username)+"/followers", h.Followers.List(username)
+	if h.hideNetwork(username) != "" {
+		return h.hideCount(username, hiddenCollection(id, len(followers)))
+	}
+	return h.hideCount(username, orderedCollectionOf(h, id, followers))
+}
+
+// hideCount removes totalItems from the collection if the user hides the counts.
+func (h *Handler) hideCount(username string, collection map[string]any) map[string]any {
+	if u, ok := h.lookupUser(username); ok && u.HideCounts {
+		delete(collection, "totalItems")
+	}
+	return collection
+}
+
+// followersPage builds a page of the followers collection without @context.
+func (h *Handler) followersPage(username string, page int) map[string]any {
+	return orderedCollectionPage(h, h.userURL(username)+"/followers", h.Followers.List(username), page)
 }
 
 func (h *Handler) GetFollowing(c echo.Context) error {
 	username := c.Param("username")
-	page := c.QueryParam("page")
-
-	if page == "" {
-		return c.JSON(200, map[string]any{
-			"@context":   "https://www.w3.org/ns/activitystreams",
-			"id":         fmt.Sprintf("https://%s/@%s/following", h.Hostname, username),
-			"type":       "OrderedCollection",
-			"totalItems": 1,
-			"first":      fmt.Sprintf("https://%s/@%s/following?page=0", h.Hostname, username),
+
+	switch h.hideNetwork(username) {
+	case HideNetworkForbidden:
+		return c.JSON(403, map[string]string{
+			"error": "following of this user is hidden",
 		})
-	} else {
-		return c.JSON(200, map[string]any{
-			"@context": "https://www.w3.org/ns/activitystreams",
-			"id":       fmt.Sprintf("https://%s/@%s/following?page=0", h.Hostname, username),
-			"type":     "OrderedCollectionPage",
-			"partOf":   fmt.Sprintf("https://%s/@%s/following", h.Hostname, username),
-			"orderedItems": []string{
-				"https://mstdn.jp/users/macrat",
-			},
-			"next": fmt.Sprintf("https://%s/@%s/following?page=1", h.Hostname, username),
+	case HideNetworkCount:
+		return h.activityJSON(c, 200, h.followingCollection(username))
+	}
+
+	repr, ok := negotiateCollection(c.Request().Header.Get("Accept"))
+	if !ok && h.StrictAccept {
+		return c.JSON(406, map[string]string{
+			"error": "not acceptable",
+		})
+	}
+	if repr == RepresentationHTML {
+		return actorListHTML(c, "Followed by @"+username, h.Following.List(username))
+	}
+
+	if c.QueryParam("page") == "" {
+		return h.activityJSON(c, 200, h.followingCollection(username))
+	}
+
+	page, ok := parsePage(c.QueryParam("page"))
+	if !ok {
+		return c.JSON(400, map[string]string{
+			"error": "invalid page",
 		})
 	}
+	return h.activityJSON(c, 200, withContext(h.followingPage(username, page)))
+}
+
+// followingCollection builds the summary of the following collection.
+func (h *Handler) followingCollection(username string) map[string]any {
+	id, following := h.userURL(username)+"/following", h.Following.List(username)
+	if h.hideNetwork(username) != "" {
+		return h.hideCount(username, hiddenCollection(id, len(following)))
+	}
+	return h.hideCount(username, orderedCollectionOf(h, id, following))
+}
+
+// followingPage builds a page of the following collection without @context.
+func (h *Handler) followingPage(username string, page int) map[string]any {
+	return orderedCollectionPage(h, h.userURL(username)+"/following", h.Following.List(username), page)
 }
 
 func main() {
+	conf, err := loadConfig(os.Getenv("CONFIG"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "dump" {
+		if err := runDump(conf, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	selfCheckTimeout := DefaultSelfCheckTimeout
+	if d, err := time.ParseDuration(os.Getenv("SELF_CHECK_TIMEOUT")); err == nil {
+		selfCheckTimeout = d
+	}
+
+	logExcludes := parseLogExcludePaths(os.Getenv("LOG_EXCLUDE_PATHS"))
+
+	hosts := make(VirtualHosts)
+	var handlers []*Handler
+	for _, host := range conf.Hosts {
+		h, err := newHandler(host)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		e := echo.New()
+		e.Use(accessLogger(logExcludes))
+		h.RegisterRoutes(e)
+		hosts[host.Hostname] = e
+		handlers = append(handlers, h)
+	}
+
 	e := echo.New()
-	e.Use(middleware.Logger())
-	h := &Handler{
-		Hostname: "oxyfern.blanktar.jp",
+	e.Any("/*", echo.WrapHandler(hosts))
+
+	// Listen before the self-check, so that it can reach this server through the reverse proxy.
+	l, err := net.Listen("tcp", ":8000")
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	e.Listener = l
+
+	if os.Getenv("SELF_CHECK") != "" {
+		for _, h := range handlers {
+			go h.runSelfCheck(selfCheckTimeout)
+		}
 	}
-	h.RegisterRoutes(e)
-	e.Logger.Fatal(e.Start(":8000"))
+
+	for _, h := range handlers {
+		if h.ReachabilityInterval > 0 {
+			go h.runReachabilityCheck()
+		}
+	}
+
+	e.Logger.Fatal(newServer(e).Serve(l))
 }
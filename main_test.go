@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// getJSON fetches the path from the handler as ActivityStreams and decodes the response.
+func getJSON(t *testing.T, h *Handler, path string) map[string]any {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("Accept", "application/activity+json")
+	rec := serve(h, req)
+	if rec.Code != 200 {
+		t.Fatalf("GET %s: expected 200 but got %d: %s", path, rec.Code, rec.Body)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("GET %s: %s", path, err)
+	}
+	return doc
+}
+
+func TestGetOutbox_inlineFirstPage(t *testing.T) {
+	for _, inline := range []bool{false, true} {
+		h := newTestHandler(t, "alice")
+		h.InlineFirstPage = inline
+		h.Posts.Add(&Post{Username: "alice", Content: "one", Published: time.Now()})
+		h.Posts.Add(&Post{Username: "alice", Content: "two", Published: time.Now()})
+
+		outbox := h.userURL("alice") + "/outbox"
+		doc := getJSON(t, h, "/@alice/outbox")
+		if doc["id"] != outbox {
+			t.Errorf("inline=%v: unexpected id: %v", inline, doc["id"])
+		}
+		if doc["totalItems"] != float64(2) {
+			t.Errorf("inline=%v: unexpected totalItems: %v", inline, doc["totalItems"])
+		}
+
+		if !inline {
+			if doc["first"] != outbox+"?page=0" {
+				t.Errorf("unexpected first: %v", doc["first"])
+			}
+			continue
+		}
+
+		first, ok := doc["first"].(map[string]any)
+		if !ok {
+			t.Fatalf("first is not inlined: %v", doc["first"])
+		}
+		if first["id"] != outbox+"?page=0" || first["type"] != "OrderedCollectionPage" || first["partOf"] != outbox {
+			t.Errorf("unexpected first page: %v", first)
+		}
+		if _, ok := first["@context"]; ok {
+			t.Error("the inlined page has its own @context")
+		}
+		if items, _ := first["orderedItems"].([]any); len(items) != 2 {
+			t.Errorf("expected 2 items in the first page but got %v", first["orderedItems"])
+		}
+
+		// The page is the same as the one served by itself.
+		page := getJSON(t, h, "/@alice/outbox?page=0")
+		delete(page, "@context")
+		a, _ := json.Marshal(page)
+		b, _ := json.Marshal(first)
+		if string(a) != string(b) {
+			t.Errorf("the inlined page differs from the served one:\n%s\n%s", b, a)
+		}
+	}
+}
+
+func TestCacheControl_vary(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	h.Posts.Add(&Post{Username: "alice", Content: "hello", Published: time.Now()})
+
+	for _, path := range []string{"/@alice", "/@alice/posts/1", "/@alice/followers", "/@alice/following"} {
+		for _, accept := range []string{"text/html", "application/activity+json"} {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("Accept", accept)
+			rec := serve(h, req)
+			if rec.Code != 200 || !strings.HasPrefix(rec.Header().Get("Cache-Control"), "max-age=") {
+				t.Errorf("GET %s as %s: unexpected response: %d %q", path, accept, rec.Code, rec.Header().Get("Cache-Control"))
+			}
+			if vary := rec.Header().Get("Vary"); vary != "Accept" {
+				t.Errorf("GET %s as %s: expected Vary: Accept but got %q", path, accept, vary)
+			}
+		}
+	}
+
+	// Errors are not cached, so they do not need to vary.
+	req := httptest.NewRequest("GET", "/@alice/posts/2", nil)
+	req.Header.Set("Accept", "application/activity+json")
+	if rec := serve(h, req); rec.Code != 404 || rec.Header().Get("Cache-Control") != "no-store" || rec.Header().Get("Vary") != "" {
+		t.Errorf("unexpected headers of an error: %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestHead(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	h.Posts.Add(&Post{Username: "alice", Content: "hello", Published: time.Now()})
+
+	for _, path := range []string{"/@alice", "/@alice/outbox", "/@alice/outbox?page=0", "/@alice/followers", "/@alice/following", "/@alice/posts/1", "/.well-known/nodeinfo"} {
+		t.Run(path, func(t *testing.T) {
+			get := httptest.NewRequest("GET", path, nil)
+			get.Header.Set("Accept", "application/activity+json")
+			head := httptest.NewRequest("HEAD", path, nil)
+			head.Header.Set("Accept", "application/activity+json")
+
+			want, got := serve(h, get), serve(h, head)
+			if want.Code != 200 {
+				t.Fatalf("GET: expected 200 but got %d", want.Code)
+			}
+			if got.Code != want.Code {
+				t.Fatalf("expected %d like GET but got %d", want.Code, got.Code)
+			}
+			for _, name := range []string{"Content-Type", "Cache-Control", "Vary"} {
+				if got.Header().Get(name) != want.Header().Get(name) {
+					t.Errorf("%s differs from GET: %q vs %q", name, got.Header().Get(name), want.Header().Get(name))
+				}
+			}
+			// The nodeinfo discovery is not negotiated nor cached; the others must actually send the headers compared above.
+			if path != "/.well-known/nodeinfo" && (got.Header().Get("Cache-Control") == "" || got.Header().Get("Vary") == "") {
+				t.Errorf("Cache-Control or Vary is missing: %v", got.Header())
+			}
+		})
+	}
+
+	head := httptest.NewRequest("HEAD", "/@alice", nil)
+	head.Header.Set("Accept", "application/activity+json")
+	if ct := serve(h, head).Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/activity+json") {
+		t.Errorf("unexpected Content-Type of the actor: %s", ct)
+	}
+}
+
+func TestGetOutbox_type(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	for i := 0; i < 3; i++ {
+		h.Posts.Add(&Post{Username: "alice", Content: fmt.Sprint(i), Published: time.Now()})
+	}
+	h.Posts.Delete("alice", "2", time.Now())
+
+	tests := []struct {
+		Type  string
+		Total int
+	}{
+		{"", 3},
+		{"Create", 2},
+		{"Delete", 1},
+		{"Announce", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Type, func(t *testing.T) {
+			path := "/@alice/outbox"
+			if tt.Type != "" {
+				path += "?type=" + tt.Type
+			}
+			id := h.userURL("alice") + path[len("/@alice"):]
+
+			collection := getJSON(t, h, path)
+			if collection["id"] != id || collection["totalItems"] != float64(tt.Total) {
+				t.Errorf("unexpected id or totalItems: %v, %v", collection["id"], collection["totalItems"])
+			}
+			first, _ := collection["first"].(string)
+			if first != pageURL(id, 0) {
+				t.Fatalf("unexpected first: %v", collection["first"])
+			}
+
+			page := getJSON(t, h, strings.TrimPrefix(first, h.baseURL()))
+			items, ok := page["orderedItems"].([]any)
+			if !ok || len(items) != tt.Total {
+				t.Fatalf("expected %d items but got %v", tt.Total, page["orderedItems"])
+			}
+			for _, item := range items {
+				if typ := item.(map[string]any)["type"]; tt.Type != "" && typ != tt.Type {
+					t.Errorf("unexpected type of an item: %v", typ)
+				}
+			}
+		})
+	}
+}
+
+func TestGetNodeInfo_versions(t *testing.T) {
+	h := newTestHandler(t, "alice", "bob")
+
+	rec := serve(h, httptest.NewRequest("GET", "/.well-known/nodeinfo", nil))
+	var discovery struct {
+		Links []struct {
+			Rel  string `json:"rel"`
+			Href string `json:"href"`
+		} `json:"links"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &discovery); err != nil {
+		t.Fatal(err)
+	}
+	if len(discovery.Links) != len(NodeInfoVersions) {
+		t.Fatalf("unexpected links: %+v", discovery.Links)
+	}
+
+	for i, version := range NodeInfoVersions {
+		t.Run(version, func(t *testing.T) {
+			link := discovery.Links[i]
+			if link.Rel != "http://nodeinfo.diaspora.software/ns/schema/"+version || link.Href != h.baseURL()+"/nodeinfo/"+version {
+				t.Fatalf("unexpected link: %+v", link)
+			}
+
+			rec := serve(h, httptest.NewRequest("GET", strings.TrimPrefix(link.Href, h.baseURL()), nil))
+			if ct := rec.Header().Get("Content-Type"); ct != `application/json; profile="http://nodeinfo.diaspora.software/ns/schema/`+version+`#"` {
+				t.Errorf("unexpected Content-Type: %s", ct)
+			}
+			var doc map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if err := checkNodeInfoSchema(doc, version); err != nil {
+				t.Errorf("invalid document: %s: %v", err, doc)
+			}
+
+			users := doc["usage"].(map[string]any)["users"].(map[string]any)
+			if users["total"] != float64(2) {
+				t.Errorf("unexpected usage: %v", users)
+			}
+		})
+	}
+
+	if rec := serve(h, httptest.NewRequest("GET", "/nodeinfo/1.0", nil)); rec.Code != 404 {
+		t.Errorf("expected 404 for an unsupported version but got %d", rec.Code)
+	}
+}
+
+// checkNodeInfoSchema checks the constraints of the NodeInfo schema of the version that the document can break.
+// 2.0 does not allow the software to have repository and homepage, which 2.1 added.
+func checkNodeInfoSchema(doc map[string]any, version string) error {
+	allowed := map[string]bool{"version": true, "software": true, "protocols": true, "services": true, "openRegistrations": true, "usage": true, "metadata": true}
+	for key := range doc {
+		if !allowed[key] {
+			return fmt.Errorf("unknown property %s", key)
+		}
+	}
+	for key := range allowed {
+		if _, ok := doc[key]; !ok {
+			return fmt.Errorf("%s is missing", key)
+		}
+	}
+
+	if doc["version"] != version {
+		return fmt.Errorf("unexpected version %v", doc["version"])
+	}
+
+	software, _ := doc["software"].(map[string]any)
+	if name, _ := software["name"].(string); !regexp.MustCompile(`^[a-z0-9-]+$`).MatchString(name) {
+		return fmt.Errorf("invalid software name %q", name)
+	}
+	if _, ok := software["version"].(string); !ok {
+		return errors.New("software version is missing")
+	}
+	for key := range software {
+		if key != "name" && key != "version" && (version == "2.0" || (key != "repository" && key != "homepage")) {
+			return fmt.Errorf("unknown software property %s", key)
+		}
+	}
+
+	if protocols, _ := doc["protocols"].([]any); len(protocols) == 0 {
+		return errors.New("protocols is empty")
+	}
+	services, _ := doc["services"].(map[string]any)
+	for _, key := range []string{"inbound", "outbound"} {
+		if _, ok := services[key].([]any); !ok {
+			return fmt.Errorf("services.%s is not an array", key)
+		}
+	}
+	if _, ok := doc["openRegistrations"].(bool); !ok {
+		return errors.New("openRegistrations is not a boolean")
+	}
+	if usage, _ := doc["usage"].(map[string]any); usage["users"] == nil {
+		return errors.New("usage.users is missing")
+	}
+	if _, ok := doc["metadata"].(map[string]any); !ok {
+		return errors.New("metadata is not an object")
+	}
+	return nil
+}
+
+func TestGetNodeInfo_metadata(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	metadata := `{"nodeName":"sandbox","themeColor":"#6364ff","maintainer":{"name":"admin","email":"admin@local.example"},"features":["polls",1,null,true]}`
+	if err := json.Unmarshal([]byte(metadata), &h.NodeInfoMetadata); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateNodeInfoMetadata(h.NodeInfoMetadata); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	rec := serve(h, httptest.NewRequest("GET", "/nodeinfo/2.1", nil))
+	var doc struct {
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to decode: %s: %s", err, rec.Body)
+	}
+	var got, want any
+	json.Unmarshal(doc.Metadata, &got)
+	json.Unmarshal([]byte(metadata), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("metadata does not round-trip: %s", doc.Metadata)
+	}
+}
+
+func TestValidateNodeInfoMetadata(t *testing.T) {
+	tests := map[string]bool{
+		`{}`:                          true,
+		`{"nodeName":"sandbox"}`:      true,
+		`{"nodeName":1}`:              false,
+		`{"nodeDescription":["a"]}`:   false,
+		`{"themeColor":"#fff"}`:       true,
+		`{"themeColor":"#ffffff80"}`:  true,
+		`{"themeColor":"red"}`:        false,
+		`{"anything":{"goes":[1,2]}}`: true,
+	}
+	for metadata, ok := range tests {
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(metadata), &m); err != nil {
+			t.Fatal(err)
+		}
+		if err := validateNodeInfoMetadata(m); (err == nil) != ok {
+			t.Errorf("%s: unexpected result: %v", metadata, err)
+		}
+	}
+}
+
+func TestGetOutbox_cursor(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	h.PageSize = 3
+	for i := 0; i < 7; i++ {
+		h.Posts.Add(&Post{Username: "alice", Content: fmt.Sprint(i), Published: time.Now()})
+	}
+	outbox := h.outboxURL("alice", "")
+
+	// walk follows the link from the page until it runs out, and returns the post ids of each page.
+	walk := func(path, link string) [][]string {
+		var pages [][]string
+		for path != "" {
+			page := getJSON(t, h, path)
+			if page["type"] != "OrderedCollectionPage" || page["partOf"] != outbox {
+				t.Fatalf("GET %s: unexpected page: %v", path, page)
+			}
+
+			var ids []string
+			for _, item := range page["orderedItems"].([]any) {
+				id := item.(map[string]any)["id"].(string)
+				id = strings.TrimSuffix(strings.TrimPrefix(id, h.userURL("alice")+"/posts/"), "/activity")
+				ids = append(ids, id)
+			}
+			pages = append(pages, ids)
+
+			next, _ := page[link].(string)
+			path = strings.TrimPrefix(next, h.baseURL())
+			if len(pages) > 5 {
+				t.Fatalf("too many pages: %v", pages)
+			}
+		}
+		return pages
+	}
+
+	forward := walk("/@alice/outbox?max_id=8", "next")
+	if fmt.Sprint(forward) != "[[7 6 5] [4 3 2] [1]]" {
+		t.Errorf("unexpected pages by max_id: %v", forward)
+	}
+
+	backward := walk("/@alice/outbox?min_id=0", "prev")
+	if fmt.Sprint(backward) != "[[3 2 1] [6 5 4] [7]]" {
+		t.Errorf("unexpected pages by min_id: %v", backward)
+	}
+
+	page := getJSON(t, h, "/@alice/outbox?max_id=5")
+	if page["id"] != cursorURL(outbox, "max_id", "5") {
+		t.Errorf("unexpected id: %v", page["id"])
+	}
+	if page["prev"] != cursorURL(outbox, "min_id", "4") || page["next"] != cursorURL(outbox, "max_id", "2") {
+		t.Errorf("unexpected prev or next: %v, %v", page["prev"], page["next"])
+	}
+
+	for _, query := range []string{"max_id=abc", "min_id=-1"} {
+		req := httptest.NewRequest("GET", "/@alice/outbox?"+query, nil)
+		req.Header.Set("Accept", "application/activity+json")
+		if rec := serve(h, req); rec.Code != 400 {
+			t.Errorf("%s: expected 400 but got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestLogRequestForDebug_root(t *testing.T) {
+	defer func(path string) { RequestLogPath = path }(RequestLogPath)
+	RequestLogPath = filepath.Join(t.TempDir(), "request.log")
+
+	e := echo.New()
+	for _, path := range []string{"/", "/inbox"} {
+		req := httptest.NewRequest("POST", path, strings.NewReader("{}"))
+		logRequestForDebug(e.NewContext(req, httptest.NewRecorder()), "{}")
+	}
+
+	entries, err := readRequestLog(RequestLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Path != "/inbox" {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/labstack/echo"
+)
+
+// PostInboxMove handles a Move of a remote account that local users follow.
+// If FollowMoves is set, the followers unfollow the old account and follow the target, but only if the target lists the old account in alsoKnownAs.
+// Otherwise the Move is acknowledged and logged.
+func (h *Handler) PostInboxMove(c echo.Context, move *Activity) error {
+	old := move.Object.ID
+	target := idOf(move.Raw["target"])
+	if old == "" || target == "" {
+		return c.JSON(400, map[string]string{
+			"error": "Move needs object and target",
+		})
+	}
+	if old != move.Actor {
+		return c.JSON(400, map[string]string{
+			"error": "only the actor itself can be moved",
+		})
+	}
+
+	usernames := h.Following.Usernames(old)
+	if !h.FollowMoves || len(usernames) == 0 {
+		c.Logger().Printf("acknowledged Move from %s to %s without following it", old, target)
+		return c.JSON(202, map[string]string{
+			"status": "acknowledged",
+		})
+	}
+
+	actor, err := h.fetchActor(c.Request().Context(), target)
+	if err != nil {
+		return c.JSON(502, map[string]string{
+			"error": fmt.Sprintf("failed to fetch the target: %s", err),
+		})
+	}
+	known := false
+	for _, id := range actor.AlsoKnownAs {
+		known = known || id == old
+	}
+	if !known {
+		return c.JSON(422, map[string]string{
+			"error": "target does not list the actor in alsoKnownAs",
+		})
+	}
+
+	for _, username := range usernames {
+		go h.followMove(username, old, target)
+	}
+
+	return c.JSON(202, map[string]string{
+		"status": "accepted",
+	})
+}
+
+// followMove unfollows the old account and follows the target on behalf of the local user.
+func (h *Handler) followMove(username, old, target string) {
+	ctx := context.Background()
+
+	h.Following.Remove(username, old)
+	if inbox, _, err := h.resolveInbox(ctx, old); err != nil {
+		log.Printf("failed to resolve inbox of %s to undo the follow: %s", old, err)
+	} else {
+		actor := h.userURL(username)
+		undo := h.newActivity(username, map[string]any{
+			"@context": ActivityStreamsContext,
+			"type":     "Undo",
+			"actor":    actor,
+			"object": map[string]any{
+				"type":   "Follow",
+				"actor":  actor,
+				"object": old,
+			},
+		})
+		if err := h.deliver(username, inbox, undo); err != nil {
+			log.Printf("failed to undo the follow of %s by %s: %s", old, username, err)
+		}
+	}
+
+	if _, err := h.sendFollow(ctx, username, target); err != nil {
+		log.Printf("failed to follow %s moved from %s by %s: %s", target, old, username, err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"math/big"
+)
+
+// multicodecRSAPub is the multicodec prefix of an RSA public key in PKCS#1 DER form, as an unsigned varint of 0x1205.
+var multicodecRSAPub = []byte{0x85, 0x24}
+
+// encodeMultikey encodes an RSA public key as the publicKeyMultibase of a Multikey, as described in FEP-521a.
+func encodeMultikey(key *rsa.PublicKey) string {
+	raw := append(append([]byte{}, multicodecRSAPub...), x509.MarshalPKCS1PublicKey(key)...)
+	return "z" + encodeBase58BTC(raw)
+}
+
+const base58BTCAlphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// encodeBase58BTC encodes bytes in the Bitcoin flavour of base58, which keeps leading zero bytes as '1'.
+func encodeBase58BTC(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58BTCAlphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58BTCAlphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
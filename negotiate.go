@@ -0,0 +1,122 @@
+package main
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// Representation is a format that a page can be served in.
+type Representation int
+
+const (
+	RepresentationHTML Representation = iota
+	RepresentationActivity
+)
+
+// mediaTypes are the media types of each representation. The first one is the most specific.
+var mediaTypes = map[Representation][]string{
+	RepresentationHTML:     {"text/html"},
+	RepresentationActivity: {"application/activity+json", "application/ld+json"},
+}
+
+// acceptQuality returns the quality of the most specific media range in the Accept header that matches one of types.
+// The second value is the specificity of the match; 0 means no match.
+func acceptQuality(accept string, types []string) (float64, int) {
+	quality, specificity := 0.0, 0
+
+	for _, r := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(r))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+
+		for _, t := range types {
+			s := 0
+			switch {
+			case mediaRange == t:
+				s = 3
+			case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(t, strings.TrimSuffix(mediaRange, "*")):
+				s = 2
+			case mediaRange == "*/*":
+				s = 1
+			}
+			if s > specificity {
+				quality, specificity = q, s
+			}
+		}
+	}
+
+	return quality, specificity
+}
+
+// negotiate chooses the representation by the Accept header.
+// It returns false if neither is acceptable. An empty Accept header means HTML.
+func negotiate(accept string) (Representation, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return RepresentationHTML, true
+	}
+
+	htmlQ, _ := acceptQuality(accept, mediaTypes[RepresentationHTML])
+	activityQ, activityS := acceptQuality(accept, mediaTypes[RepresentationActivity])
+
+	switch {
+	case htmlQ <= 0 && activityQ <= 0:
+		return RepresentationHTML, false
+	case activityQ > htmlQ:
+		return RepresentationActivity, true
+	case activityQ == htmlQ && activityS == 3:
+		// Such as "application/activity+json, */*"; ActivityPub clients often list HTML or a wildcard as a fallback.
+		return RepresentationActivity, true
+	default:
+		return RepresentationHTML, true
+	}
+}
+
+// negotiateCollection is negotiate for collections, which are mostly fetched by servers.
+// Unlike negotiate, it chooses HTML only if HTML is preferred, such as by browsers; an empty Accept header or a bare wildcard means ActivityStreams JSON.
+func negotiateCollection(accept string) (Representation, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return RepresentationActivity, true
+	}
+
+	htmlQ, htmlS := acceptQuality(accept, mediaTypes[RepresentationHTML])
+	activityQ, activityS := acceptQuality(accept, mediaTypes[RepresentationActivity])
+
+	switch {
+	case htmlQ <= 0 && activityQ <= 0:
+		return RepresentationActivity, false
+	case htmlQ > activityQ, htmlQ == activityQ && htmlS > activityS:
+		return RepresentationHTML, true
+	default:
+		return RepresentationActivity, true
+	}
+}
+
+// isActivityContentType reports whether the Content-Type is application/activity+json,
+// or application/ld+json with the ActivityStreams profile, as ActivityPub requires for POSTs to inboxes.
+func isActivityContentType(contentType string) bool {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch mediaType {
+	case "application/activity+json":
+		return true
+	case "application/ld+json":
+		for _, p := range strings.Fields(params["profile"]) {
+			if p == ActivityStreamsContext {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		Accept     string
+		Repr       Representation
+		Acceptable bool
+	}{
+		{"", RepresentationHTML, true},
+		{"text/html", RepresentationHTML, true},
+		{"application/activity+json", RepresentationActivity, true},
+		{`application/ld+json; profile="https://www.w3.org/ns/activitystreams"`, RepresentationActivity, true},
+		{"application/activity+json, */*", RepresentationActivity, true},
+		{"text/html, application/activity+json;q=0.9", RepresentationHTML, true},
+		{"text/html;q=0.5, application/activity+json", RepresentationActivity, true},
+		{"*/*", RepresentationHTML, true},
+		{"application/xml", RepresentationHTML, false},
+		{"image/png, text/plain", RepresentationHTML, false},
+		{"text/html;q=0", RepresentationHTML, false},
+	}
+
+	for _, tt := range tests {
+		repr, ok := negotiate(tt.Accept)
+		if ok != tt.Acceptable || (ok && repr != tt.Repr) {
+			t.Errorf("negotiate(%q): expected %v, %v but got %v, %v", tt.Accept, tt.Repr, tt.Acceptable, repr, ok)
+		}
+	}
+}
+
+func TestNegotiateCollection(t *testing.T) {
+	tests := []struct {
+		Accept     string
+		Repr       Representation
+		Acceptable bool
+	}{
+		{"", RepresentationActivity, true},
+		{"*/*", RepresentationActivity, true},
+		{"application/activity+json", RepresentationActivity, true},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", RepresentationHTML, true},
+		{"application/xml", RepresentationActivity, false},
+	}
+
+	for _, tt := range tests {
+		repr, ok := negotiateCollection(tt.Accept)
+		if ok != tt.Acceptable || (ok && repr != tt.Repr) {
+			t.Errorf("negotiateCollection(%q): expected %v, %v but got %v, %v", tt.Accept, tt.Repr, tt.Acceptable, repr, ok)
+		}
+	}
+}
+
+func TestGetUser_strictAccept(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		h := newTestHandler(t, "alice")
+		h.StrictAccept = strict
+
+		req := httptest.NewRequest("GET", "/@alice", nil)
+		req.Header.Set("Accept", "application/xml")
+		rec := serve(h, req)
+
+		if strict {
+			if rec.Code != 406 {
+				t.Errorf("strict: expected 406 but got %d", rec.Code)
+			}
+			continue
+		}
+		if rec.Code != 200 || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("lenient: expected HTML but got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestIsActivityContentType(t *testing.T) {
+	tests := map[string]bool{
+		"application/activity+json":                                            true,
+		"application/activity+json; charset=utf-8":                             true,
+		`application/ld+json; profile="https://www.w3.org/ns/activitystreams"`: true,
+		"application/ld+json":                                                  false,
+		"application/json":                                                     false,
+		"":                                                                     false,
+	}
+	for contentType, want := range tests {
+		if got := isActivityContentType(contentType); got != want {
+			t.Errorf("isActivityContentType(%q): expected %v", contentType, want)
+		}
+	}
+}
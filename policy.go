@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// FollowDecision is the result of FollowPolicy.
+type FollowDecision int
+
+const (
+	// FollowAccept sends Accept and adds the actor to the followers.
+	FollowAccept FollowDecision = iota
+
+	// FollowReject sends Reject.
+	FollowReject
+
+	// FollowDefer keeps the follow request as pending without answering.
+	FollowDefer
+)
+
+func (d FollowDecision) String() string {
+	switch d {
+	case FollowAccept:
+		return "accept"
+	case FollowReject:
+		return "reject"
+	case FollowDefer:
+		return "defer"
+	default:
+		return "unknown"
+	}
+}
+
+// FollowPolicy decides how to answer an incoming Follow activity.
+type FollowPolicy interface {
+	Decide(follow *Activity) FollowDecision
+}
+
+// FollowPolicyFunc is an adapter to use an ordinary function as FollowPolicy.
+type FollowPolicyFunc func(follow *Activity) FollowDecision
+
+func (f FollowPolicyFunc) Decide(follow *Activity) FollowDecision {
+	return f(follow)
+}
+
+// AcceptAllFollows is the default FollowPolicy that accepts every follow request.
+var AcceptAllFollows = FollowPolicyFunc(func(*Activity) FollowDecision {
+	return FollowAccept
+})
+
+// RejectAllFollows is a FollowPolicy that rejects every follow request.
+var RejectAllFollows = FollowPolicyFunc(func(*Activity) FollowDecision {
+	return FollowReject
+})
+
+// ManualFollows is a FollowPolicy that defers every follow request, so that they are answered through the admin API.
+var ManualFollows = FollowPolicyFunc(func(*Activity) FollowDecision {
+	return FollowDefer
+})
+
+// lookupFollowPolicy finds the FollowPolicy selected by FOLLOW_POLICY.
+func lookupFollowPolicy(name string) (FollowPolicy, error) {
+	switch name {
+	case "", "accept":
+		return AcceptAllFollows, nil
+	case "reject":
+		return RejectAllFollows, nil
+	case "manual":
+		return ManualFollows, nil
+	default:
+		return nil, fmt.Errorf("unknown follow policy %q; it must be accept, reject or manual", name)
+	}
+}
+
+// The values of Handler.SelfFollow.
+const (
+	SelfFollowReject = "reject"
+	SelfFollowError  = "error"
+	SelfFollowAccept = "accept"
+)
+
+// normalizeID normalizes an IRI for comparison: the scheme and the host are lowercased, and the default port, the fragment and a trailing slash are removed.
+func normalizeID(id string) string {
+	u, err := url.Parse(id)
+	if err != nil {
+		return id
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "https" && u.Port() == "443") || (u.Scheme == "http" && u.Port() == "80") {
+		u.Host = u.Hostname()
+	}
+	u.Fragment = ""
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	return u.String()
+}
+
+// isSelfFollow reports whether the follow is sent by the followed local user.
+// The ids are compared after normalizeID and the lookup of the local user, so that the actor path and the casing of the username do not matter.
+func (h *Handler) isSelfFollow(username string, follow *Activity) bool {
+	name, ok := h.localUsername(normalizeID(follow.Actor))
+	return ok && name == username
+}
+
+// selfFollow returns how self-follows are answered.
+func (h *Handler) selfFollow() string {
+	if h.SelfFollow == "" {
+		return SelfFollowReject
+	}
+	return h.SelfFollow
+}
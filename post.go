@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// Post is a Note or Question written by a local user.
+type Post struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Content   string    `json:"content"`
+	Published time.Time `json:"published"`
+
+	// Mentions are the actor ids that the post is addressed to in addition to the followers.
+	Mentions []string `json:"mentions,omitempty"`
+
+	// Summary is the content warning of the post, and Sensitive marks the post and its media as sensitive.
+	Summary   string `json:"summary,omitempty"`
+	Sensitive bool   `json:"sensitive,omitempty"`
+
+	// Poll makes the post a Question. It is nil for a Note.
+	Poll *Poll `json:"poll,omitempty"`
+
+	// Deleted is when the post was deleted. It is zero while the post is alive.
+	Deleted time.Time `json:"deleted"`
+}
+
+// Poll is the options and results of a Question.
+type Poll struct {
+	Multiple bool         `json:"multiple"`
+	Options  []PollOption `json:"options"`
+	EndTime  time.Time    `json:"endTime"`
+
+	// Voters are the actor ids that have voted.
+	Voters []string `json:"voters"`
+
+	// Choices are the indexes of the options that each voter chose, so that an actor cannot vote for the same option twice.
+	Choices map[string][]int `json:"choices,omitempty"`
+}
+
+type PollOption struct {
+	Name  string `json:"name"`
+	Votes int    `json:"votes"`
+}
+
+// PostStore keeps local posts in memory. It is safe for concurrent use.
+// Posts are copied in and out of the store, so the returned posts can be read without locking.
+// Only the Poll is shared, and it must be accessed through Vote and pollSnapshot.
+type PostStore struct {
+	sync.RWMutex
+	posts  []*Post
+	lastID int64
+}
+
+// Add assigns a new id to the post and stores it.
+func (s *PostStore) Add(post *Post) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.lastID++
+	post.ID = strconv.FormatInt(s.lastID, 10)
+	stored := *post
+	s.posts = append(s.posts, &stored)
+}
+
+// Get returns the post, including deleted ones.
+func (s *PostStore) Get(username, id string) (*Post, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, p := range s.posts {
+		if p.Username == username && p.ID == id {
+			post := *p
+			return &post, true
+		}
+	}
+	return nil, false
+}
+
+// Delete marks the post as deleted, and returns it.
+// It fails if the post does not exist or is already deleted.
+func (s *PostStore) Delete(username, id string, now time.Time) (*Post, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, p := range s.posts {
+		if p.Username == username && p.ID == id && p.Deleted.IsZero() {
+			p.Deleted = now
+			post := *p
+			return &post, true
+		}
+	}
+	return nil, false
+}
+
+// List returns the alive posts of the user, newest first.
+func (s *PostStore) List(username string) []*Post {
+	s.RLock()
+	defer s.RUnlock()
+
+	var xs []*Post
+	for i := len(s.posts) - 1; i >= 0; i-- {
+		if s.posts[i].Username == username && s.posts[i].Deleted.IsZero() {
+			post := *s.posts[i]
+			xs = append(xs, &post)
+		}
+	}
+	return xs
+}
+
+// History returns the posts of the user including deleted ones, newest first.
+func (s *PostStore) History(username string) []*Post {
+	s.RLock()
+	defer s.RUnlock()
+
+	var xs []*Post
+	for i := len(s.posts) - 1; i >= 0; i-- {
+		if s.posts[i].Username == username {
+			post := *s.posts[i]
+			xs = append(xs, &post)
+		}
+	}
+	return xs
+}
+
+// LastPublished returns the published time of the newest alive post of the user.
+func (s *PostStore) LastPublished(username string) (time.Time, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for i := len(s.posts) - 1; i >= 0; i-- {
+		if s.posts[i].Username == username && s.posts[i].Deleted.IsZero() {
+			return s.posts[i].Published, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Usernames returns the users who have posts.
+func (s *PostStore) Usernames() []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	seen := make(map[string]bool)
+	var xs []string
+	for _, p := range s.posts {
+		if !seen[p.Username] {
+			seen[p.Username] = true
+			xs = append(xs, p.Username)
+		}
+	}
+	return xs
+}
+
+var (
+	errPollClosed   = errors.New("poll is closed")
+	errUnknownOpt   = errors.New("unknown poll option")
+	errAlreadyVoted = errors.New("already voted")
+)
+
+// Vote records a vote by the actor for the named option of the Question.
+// An actor can vote once on a single choice poll, and once for each option on a multiple choice poll.
+func (s *PostStore) Vote(post *Post, actor, option string, now time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+
+	poll := post.Poll
+	if now.After(poll.EndTime) {
+		return errPollClosed
+	}
+
+	index := -1
+	for i, o := range poll.Options {
+		if o.Name == option {
+			index = i
+		}
+	}
+	if index < 0 {
+		return errUnknownOpt
+	}
+
+	voted := false
+	for _, v := range poll.Voters {
+		voted = voted || v == actor
+	}
+	if voted && !poll.Multiple {
+		return errAlreadyVoted
+	}
+	for _, i := range poll.Choices[actor] {
+		if i == index {
+			return errAlreadyVoted
+		}
+	}
+
+	poll.Options[index].Votes++
+	if poll.Choices == nil {
+		poll.Choices = make(map[string][]int)
+	}
+	poll.Choices[actor] = append(poll.Choices[actor], index)
+	if !voted {
+		poll.Voters = append(poll.Voters, actor)
+	}
+	return nil
+}
+
+// pollSnapshot copies the poll of the post, so that it can be read while votes arrive.
+func (s *PostStore) pollSnapshot(post *Post) Poll {
+	s.RLock()
+	defer s.RUnlock()
+
+	poll := *post.Poll
+	poll.Options = append([]PollOption{}, poll.Options...)
+	poll.Voters = append([]string{}, poll.Voters...)
+	poll.Choices = make(map[string][]int, len(post.Poll.Choices))
+	for actor, choices := range post.Poll.Choices {
+		poll.Choices[actor] = append([]int{}, choices...)
+	}
+	return poll
+}
+
+// postURL returns the object id of the post.
+func (h *Handler) postURL(post *Post) string {
+	return h.userURL(post.Username) + "/posts/" + post.ID
+}
+
+// postContextURL returns the id of the conversation that the post starts.
+func (h *Handler) postContextURL(post *Post) string {
+	return h.postURL(post) + "/context"
+}
+
+// postObject builds the Note or Question of the post without @context.
+func (h *Handler) postObject(post *Post) map[string]any {
+	actor := h.userURL(post.Username)
+
+	object := map[string]any{
+		"id":           h.postURL(post),
+		"type":         "Note",
+		"published":    post.Published.UTC().Format(time.RFC3339),
+		"attributedTo": actor,
+		"to": []string{
+			PublicAudience,
+		},
+		"cc":      append([]string{actor + "/followers"}, post.Mentions...),
+		"content": post.Content,
+		"context": h.postContextURL(post),
+		"likes": map[string]any{
+			"type":       "Collection",
+			"totalItems": h.Reactions.Count(h.postURL(post), "Like"),
+		},
+		"shares": map[string]any{
+			"type":       "Collection",
+			"totalItems": h.Reactions.Count(h.postURL(post), "Announce"),
+		},
+	}
+
+	if post.Summary != "" {
+		object["summary"] = post.Summary
+	}
+	if post.Sensitive {
+		object["sensitive"] = true
+	}
+	h.addLanguageMaps(object, "content", "summary")
+
+	if len(post.Mentions) > 0 {
+		tags := make([]map[string]string, len(post.Mentions))
+		for i, m := range post.Mentions {
+			tags[i] = map[string]string{
+				"type": "Mention",
+				"href": m,
+			}
+		}
+		object["tag"] = tags
+	}
+
+	if post.Poll != nil {
+		poll := h.Posts.pollSnapshot(post)
+
+		options := make([]map[string]any, len(poll.Options))
+		for i, o := range poll.Options {
+			options[i] = map[string]any{
+				"type": "Note",
+				"name": o.Name,
+				"replies": map[string]any{
+					"type":       "Collection",
+					"totalItems": o.Votes,
+				},
+			}
+		}
+
+		object["type"] = "Question"
+		object["endTime"] = poll.EndTime.UTC().Format(time.RFC3339)
+		object["votersCount"] = len(poll.Voters)
+		if poll.Multiple {
+			object["anyOf"] = options
+		} else {
+			object["oneOf"] = options
+		}
+	}
+
+	return object
+}
+
+// tombstoneObject builds the Tombstone that replaces a deleted post, without @context.
+func (h *Handler) tombstoneObject(post *Post) map[string]any {
+	formerType := "Note"
+	if post.Poll != nil {
+		formerType = "Question"
+	}
+
+	return map[string]any{
+		"id":         h.postURL(post),
+		"type":       "Tombstone",
+		"formerType": formerType,
+		"deleted":    post.Deleted.UTC().Format(time.RFC3339),
+	}
+}
+
+// deleteActivity builds the Delete activity of a deleted post without @context.
+func (h *Handler) deleteActivity(post *Post) map[string]any {
+	actor := h.userURL(post.Username)
+
+	return map[string]any{
+		"id":     h.postURL(post) + "/delete",
+		"type":   "Delete",
+		"actor":  actor,
+		"to":     []string{PublicAudience},
+		"cc":     append([]string{actor + "/followers"}, post.Mentions...),
+		"object": h.tombstoneObject(post),
+	}
+}
+
+// outboxActivity builds the activity of the post in the outbox without @context, which is Delete if the post is deleted.
+func (h *Handler) outboxActivity(post *Post) map[string]any {
+	if !post.Deleted.IsZero() {
+		return h.deleteActivity(post)
+	}
+	return h.createActivity(post)
+}
+
+// createActivity builds the Create activity of the post without @context.
+func (h *Handler) createActivity(post *Post) map[string]any {
+	object := h.postObject(post)
+
+	return map[string]any{
+		"id":        h.postURL(post) + "/activity",
+		"type":      "Create",
+		"published": object["published"],
+		"actor":     object["attributedTo"],
+		"to":        object["to"],
+		"cc":        object["cc"],
+		"object":    object,
+	}
+}
+
+func (h *Handler) GetPost(c echo.Context) error {
+	repr, ok := negotiate(c.Request().Header.Get("Accept"))
+	if !ok && h.StrictAccept {
+		return c.JSON(406, map[string]string{
+			"error": "not acceptable",
+		})
+	}
+
+	post, ok := h.Posts.Get(c.Param("username"), c.Param("id"))
+	if !ok {
+		return c.JSON(404, map[string]string{
+			"error": "not found",
+		})
+	}
+
+	if repr == RepresentationHTML {
+		return h.postHTML(c, post)
+	}
+	if !post.Deleted.IsZero() {
+		return h.activityJSON(c, 410, withContext(h.tombstoneObject(post)))
+	}
+	return h.activityJSON(c, 200, withContext(h.postObject(post)))
+}
+
+// GetPostContext serves the conversation of the post, which is the post and the received notes in the same context, in the order received.
+func (h *Handler) GetPostContext(c echo.Context) error {
+	post, ok := h.Posts.Get(c.Param("username"), c.Param("id"))
+	if !ok {
+		return c.JSON(404, map[string]string{
+			"error": "not found",
+		})
+	}
+
+	id := h.postContextURL(post)
+	items := []string{h.postURL(post)}
+	for _, n := range h.Notes.List() {
+		if n.Context == id {
+			items = append(items, n.ID)
+		}
+	}
+
+	return h.activityJSON(c, 200, map[string]any{
+		"@context":     ActivityStreamsContext,
+		"id":           id,
+		"type":         "OrderedCollection",
+		"totalItems":   len(items),
+		"orderedItems": items,
+	})
+}
+
+var postTemplate = template.Must(template.New("post").Parse(`<!DOCTYPE html>
+<title>@{{.Username}}</title>
+<link rel="alternate" type="application/activity+json" href="{{.ID}}">
+<h1><a href="{{.Actor}}">@{{.Username}}</a></h1>
+{{- if .Deleted}}
+<p>this post has been deleted at <time>{{.Deleted}}</time>.</p>
+{{- else}}
+{{- if .Summary}}
+<details>
+<summary>{{.Summary}}</summary>
+{{.Content}}
+</details>
+{{- else}}
+{{.Content}}
+{{- end}}
+<p><a href="{{.ID}}"><time>{{.Published}}</time></a></p>
+{{- end}}
+`))
+
+// postHTML renders the post for browsers. Deleted posts are rendered as a notice with 410.
+// The content is sanitized by sanitizeHTML, while the ActivityStreams representation keeps it as posted.
+func (h *Handler) postHTML(c echo.Context, post *Post) error {
+	data := struct {
+		ID        string
+		Actor     string
+		Username  string
+		Summary   string
+		Content   template.HTML
+		Published string
+		Deleted   string
+	}{
+		ID:        h.postURL(post),
+		Actor:     h.userURL(post.Username),
+		Username:  post.Username,
+		Summary:   post.Summary,
+		Content:   template.HTML(sanitizeHTML(post.Content)),
+		Published: post.Published.UTC().Format(time.RFC3339),
+	}
+	code := 200
+	if !post.Deleted.IsZero() {
+		data.Deleted = post.Deleted.UTC().Format(time.RFC3339)
+		code = 410
+	}
+
+	var buf bytes.Buffer
+	if err := postTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+	return c.HTMLBlob(code, buf.Bytes())
+}
+
+// recordVote treats a Note replying to a local Question with a name as a vote. It reports whether the note was a vote.
+func (h *Handler) recordVote(actor string, note map[string]any) (bool, error) {
+	name, _ := note["name"].(string)
+	username, id, ok := h.localPostID(idOf(note["inReplyTo"]))
+	if name == "" || !ok {
+		return false, nil
+	}
+
+	post, ok := h.Posts.Get(username, id)
+	if !ok || post.Poll == nil || !post.Deleted.IsZero() {
+		return false, nil
+	}
+
+	return true, h.Posts.Vote(post, actor, name, time.Now())
+}
+
+// localPostID extracts the username and post id from an object id of a local post.
+func (h *Handler) localPostID(id string) (string, string, bool) {
+	rest, ok := h.trimActorPath(id)
+	if !ok {
+		return "", "", false
+	}
+	username, postID, ok := strings.Cut(rest, "/posts/")
+	if !ok || username == "" || postID == "" || strings.ContainsAny(postID, "/?#") {
+		return "", "", false
+	}
+	u, ok := h.lookupUser(username)
+	if !ok {
+		return "", "", false
+	}
+	return u.Name, postID, true
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPoll(multiple bool) (*PostStore, *Post) {
+	var s PostStore
+	post := &Post{
+		Username: "alice",
+		Poll: &Poll{
+			Multiple: multiple,
+			Options:  []PollOption{{Name: "yes"}, {Name: "no"}},
+			EndTime:  time.Now().Add(time.Hour),
+		},
+	}
+	s.Add(post)
+	return &s, post
+}
+
+func TestPostStore_Vote(t *testing.T) {
+	type vote struct {
+		Actor  string
+		Option string
+		Err    error
+	}
+
+	tests := []struct {
+		Name     string
+		Multiple bool
+		Votes    []vote
+		Tally    []int
+		Voters   int
+	}{
+		{
+			"single choice",
+			false,
+			[]vote{
+				{"https://remote.example/users/carol", "yes", nil},
+				{"https://remote.example/users/carol", "no", errAlreadyVoted},
+				{"https://remote.example/users/dave", "no", nil},
+			},
+			[]int{1, 1},
+			2,
+		},
+		{
+			"multiple choice",
+			true,
+			[]vote{
+				{"https://remote.example/users/carol", "yes", nil},
+				{"https://remote.example/users/carol", "no", nil},
+				{"https://remote.example/users/carol", "yes", errAlreadyVoted},
+				{"https://remote.example/users/dave", "yes", nil},
+			},
+			[]int{2, 1},
+			2,
+		},
+		{
+			"unknown option",
+			false,
+			[]vote{
+				{"https://remote.example/users/carol", "maybe", errUnknownOpt},
+			},
+			[]int{0, 0},
+			0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			s, post := newTestPoll(tt.Multiple)
+			for _, v := range tt.Votes {
+				if err := s.Vote(post, v.Actor, v.Option, time.Now()); !errors.Is(err, v.Err) {
+					t.Errorf("vote of %s for %s: expected %v but got %v", v.Actor, v.Option, v.Err, err)
+				}
+			}
+
+			poll := s.pollSnapshot(post)
+			for i, want := range tt.Tally {
+				if poll.Options[i].Votes != want {
+					t.Errorf("expected %d votes for %s but got %d", want, poll.Options[i].Name, poll.Options[i].Votes)
+				}
+			}
+			if len(poll.Voters) != tt.Voters {
+				t.Errorf("expected %d voters but got %d", tt.Voters, len(poll.Voters))
+			}
+		})
+	}
+}
+
+func TestPostStore_Vote_closed(t *testing.T) {
+	s, post := newTestPoll(false)
+	if err := s.Vote(post, "https://remote.example/users/carol", "yes", time.Now().Add(2*time.Hour)); !errors.Is(err, errPollClosed) {
+		t.Fatalf("expected %v but got %v", errPollClosed, err)
+	}
+}
+
+func TestGetPost_tombstone(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	h.AdminToken = "secret"
+	h.Posts.Add(&Post{Username: "alice", Content: "alive", Published: time.Now()})
+	h.Posts.Add(&Post{Username: "alice", Content: "deleted", Published: time.Now()})
+
+	req := httptest.NewRequest("DELETE", "/admin/posts/alice/2", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	if rec := serve(h, req); rec.Code != 200 {
+		t.Fatalf("failed to delete: %d %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		Path   string
+		Accept string
+		Code   int
+		Type   string
+	}{
+		{"/@alice/posts/1", "application/activity+json", 200, "Note"},
+		{"/@alice/posts/2", "application/activity+json", 410, "Tombstone"},
+		{"/@alice/posts/2", "text/html", 410, ""},
+		{"/@alice/posts/3", "application/activity+json", 404, ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.Path, nil)
+		req.Header.Set("Accept", tt.Accept)
+		rec := serve(h, req)
+		if rec.Code != tt.Code {
+			t.Errorf("GET %s as %s: expected %d but got %d", tt.Path, tt.Accept, tt.Code, rec.Code)
+			continue
+		}
+		if tt.Type == "" {
+			continue
+		}
+
+		var object map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &object); err != nil {
+			t.Fatal(err)
+		}
+		if object["type"] != tt.Type || object["id"] != "https://local.example"+tt.Path {
+			t.Errorf("GET %s: unexpected object: %v", tt.Path, object)
+		}
+		if tt.Type == "Tombstone" {
+			if object["formerType"] != "Note" || object["deleted"] == nil {
+				t.Errorf("GET %s: missing formerType or deleted: %v", tt.Path, object)
+			}
+		}
+	}
+
+	// Deleting again finds nothing to delete, and never-existed posts are not found either.
+	for _, path := range []string{"/admin/posts/alice/2", "/admin/posts/alice/3"} {
+		req := httptest.NewRequest("DELETE", path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		if rec := serve(h, req); rec.Code != 404 {
+			t.Errorf("DELETE %s: expected 404 but got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestGetPost_negotiate(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	h.Posts.Add(&Post{Username: "alice", Content: "<p>hello</p>", Summary: "greeting", Published: time.Now()})
+	id := h.userURL("alice") + "/posts/1"
+
+	req := httptest.NewRequest("GET", "/@alice/posts/1", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	rec := serve(h, req)
+	if rec.Code != 200 || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("unexpected response for a browser: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`<link rel="alternate" type="application/activity+json" href="` + id + `">`,
+		"<summary>greeting</summary>",
+		"<p>hello</p>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the HTML does not contain %s: %s", want, body)
+		}
+	}
+
+	for _, accept := range []string{"application/activity+json", `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`} {
+		req := httptest.NewRequest("GET", "/@alice/posts/1", nil)
+		req.Header.Set("Accept", accept)
+		rec := serve(h, req)
+		if rec.Code != 200 || strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("%s: unexpected response: %d %s", accept, rec.Code, rec.Header().Get("Content-Type"))
+			continue
+		}
+
+		var object map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &object); err != nil {
+			t.Fatal(err)
+		}
+		if object["type"] != "Note" || object["id"] != id || object["content"] != "<p>hello</p>" {
+			t.Errorf("%s: unexpected object: %v", accept, object)
+		}
+	}
+}
+
+func TestGetPost_sanitize(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	content := `<p>hello</p><script>alert("xss")</script><img src="x" onerror="alert(1)"><a href="javascript:alert(1)">link</a>`
+	h.Posts.Add(&Post{Username: "alice", Content: content, Published: time.Now()})
+
+	req := httptest.NewRequest("GET", "/@alice/posts/1", nil)
+	req.Header.Set("Accept", "text/html")
+	rec := serve(h, req)
+	if rec.Code != 200 {
+		t.Fatalf("expected 200 but got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "<p>hello</p>") {
+		t.Errorf("the content is not rendered: %s", body)
+	}
+	for _, unwanted := range []string{"<script", "alert", "onerror", "javascript:"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("the HTML contains %s: %s", unwanted, body)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/@alice/posts/1", nil)
+	req.Header.Set("Accept", "application/activity+json")
+	var object map[string]any
+	if err := json.Unmarshal(serve(h, req).Body.Bytes(), &object); err != nil {
+		t.Fatal(err)
+	}
+	if object["content"] != content {
+		t.Errorf("the ActivityStreams content is not kept as posted: %v", object["content"])
+	}
+}
+
+func TestGetPostContext(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	h.Posts.Add(&Post{Username: "alice", Content: "hello", Published: time.Now()})
+	h.Notes.Add(ReceivedNote{ID: "https://remote.example/notes/1", Context: "https://local.example/@alice/posts/1/context"})
+	h.Notes.Add(ReceivedNote{ID: "https://remote.example/notes/2", Context: "https://remote.example/contexts/1"})
+	h.Notes.Add(ReceivedNote{ID: "https://remote.example/notes/3"})
+
+	note := getJSON(t, h, "/@alice/posts/1")
+	doc := getJSON(t, h, "/@alice/posts/1/context")
+	if doc["id"] != note["context"] || doc["type"] != "OrderedCollection" {
+		t.Errorf("unexpected collection: %v", doc)
+	}
+	items, _ := json.Marshal(doc["orderedItems"])
+	if string(items) != `["https://local.example/@alice/posts/1","https://remote.example/notes/1"]` || doc["totalItems"] != float64(2) {
+		t.Errorf("unexpected items: %s", items)
+	}
+
+	req := httptest.NewRequest("GET", "/@alice/posts/2/context", nil)
+	req.Header.Set("Accept", "application/activity+json")
+	if rec := serve(h, req); rec.Code != 404 {
+		t.Errorf("expected 404 for an unknown post but got %d", rec.Code)
+	}
+}
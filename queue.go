@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultDeliveryWorkers is the number of delivery workers used when Handler.DeliveryWorkers is zero.
+const DefaultDeliveryWorkers = 4
+
+// DefaultFanOutBatchSize is the number of deliveries of one activity in flight at once when Handler.FanOutBatchSize is zero.
+const DefaultFanOutBatchSize = 16
+
+// RetryPolicy is the backoff schedule of failed deliveries.
+type RetryPolicy struct {
+	// Base is the delay before the first retry. It doubles on each attempt, up to Max.
+	Base time.Duration
+	Max  time.Duration
+
+	// MaxAttempts is the number of attempts including the first one. The delivery is dead-lettered after that.
+	MaxAttempts int
+
+	// Jitter is the fraction of the delay that is randomly subtracted, so that retries to a recovering remote spread out.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is used when Handler.Retry is zero.
+var DefaultRetryPolicy = RetryPolicy{
+	Base:        10 * time.Second,
+	Max:         10 * time.Minute,
+	MaxAttempts: 8,
+	Jitter:      0.2,
+}
+
+// Backoff returns the delay before retrying after the given number of failed attempts.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	d := p.Base
+	for i := 1; i < attempts && d < p.Max; i++ {
+		d *= 2
+	}
+	if d > p.Max {
+		d = p.Max
+	}
+
+	if p.Jitter > 0 {
+		d -= time.Duration(float64(d) * p.Jitter * rand.Float64())
+	}
+	return d
+}
+
+// delivery is an activity waiting in the delivery queue.
+type delivery struct {
+	Username string
+	Inbox    string
+	Activity map[string]any
+	Attempts int
+
+	// done is called once the first attempt finishes, whether it succeeded or not. It may be nil.
+	done func()
+
+	// id identifies the delivery in DeliveryTracker.
+	id int64
+}
+
+// MaxDeadLetters is the number of dead-lettered deliveries that DeliveryTracker keeps for inspection.
+const MaxDeadLetters = 100
+
+// The states of a delivery in DeliveryTracker.
+const (
+	DeliveryPending  = "pending"
+	DeliveryInFlight = "in-flight"
+	DeliveryRetrying = "retrying"
+	DeliveryDead     = "dead"
+)
+
+// DeliveryState is a snapshot of a delivery for /debug/queue.
+type DeliveryState struct {
+	ID           int64      `json:"id"`
+	State        string     `json:"state"`
+	Username     string     `json:"username"`
+	Inbox        string     `json:"inbox"`
+	ActivityID   any        `json:"activityId"`
+	ActivityType any        `json:"activityType"`
+	Attempts     int        `json:"attempts"`
+	NextRetry    *time.Time `json:"nextRetry,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// DeliveryTracker keeps the state of the queued deliveries, and the last MaxDeadLetters dead-lettered ones. It is safe for concurrent use.
+type DeliveryTracker struct {
+	sync.RWMutex
+	lastID int64
+	active map[int64]*DeliveryState
+	dead   []DeliveryState
+}
+
+// track registers a new delivery as pending.
+func (t *DeliveryTracker) track(d *delivery) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.active == nil {
+		t.active = make(map[int64]*DeliveryState)
+	}
+	t.lastID++
+	d.id = t.lastID
+	t.active[d.id] = &DeliveryState{
+		ID:           d.id,
+		State:        DeliveryPending,
+		Username:     d.Username,
+		Inbox:        d.Inbox,
+		ActivityID:   d.Activity["id"],
+		ActivityType: d.Activity["type"],
+		UpdatedAt:    time.Now(),
+	}
+}
+
+// update changes the state of an active delivery. A failed attempt is recorded with its error.
+func (t *DeliveryTracker) update(d *delivery, state string, nextRetry time.Time, err error) {
+	t.Lock()
+	defer t.Unlock()
+
+	s, ok := t.active[d.id]
+	if !ok {
+		return
+	}
+	s.State = state
+	s.Attempts = d.Attempts
+	s.NextRetry = nil
+	if !nextRetry.IsZero() {
+		s.NextRetry = &nextRetry
+	}
+	s.UpdatedAt = time.Now()
+	if err != nil {
+		s.LastError = err.Error()
+	}
+
+	if state == DeliveryDead {
+		delete(t.active, d.id)
+		t.dead = append(t.dead, *s)
+		if len(t.dead) > MaxDeadLetters {
+			t.dead = t.dead[len(t.dead)-MaxDeadLetters:]
+		}
+	}
+}
+
+// done forgets a delivery that succeeded.
+func (t *DeliveryTracker) done(d *delivery) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.active, d.id)
+}
+
+// List returns the active deliveries ordered by id, followed by the dead-lettered ones, oldest first.
+func (t *DeliveryTracker) List() []DeliveryState {
+	t.RLock()
+	defer t.RUnlock()
+
+	states := make([]DeliveryState, 0, len(t.active)+len(t.dead))
+	for _, s := range t.active {
+		states = append(states, *s)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].ID < states[j].ID
+	})
+	return append(states, t.dead...)
+}
+
+func (h *Handler) retryPolicy() RetryPolicy {
+	if h.Retry == (RetryPolicy{}) {
+		return DefaultRetryPolicy
+	}
+	return h.Retry
+}
+
+func (h *Handler) fanOutBatchSize() int {
+	if h.FanOutBatchSize > 0 {
+		return h.FanOutBatchSize
+	}
+	return DefaultFanOutBatchSize
+}
+
+func (h *Handler) deliveryWorkers() int {
+	if h.DeliveryWorkers > 0 {
+		return h.DeliveryWorkers
+	}
+	return DefaultDeliveryWorkers
+}
+
+// enqueue schedules an activity of the user to be delivered to the inbox in background.
+// The workers are started on the first call. done is called when the first attempt finishes, and may be nil.
+func (h *Handler) enqueue(username, inbox string, activity map[string]any, done func()) {
+	h.startQueue.Do(func() {
+		h.queue = make(chan *delivery)
+		for i := 0; i < h.deliveryWorkers(); i++ {
+			go h.deliveryWorker()
+		}
+	})
+
+	d := &delivery{
+		Username: username,
+		Inbox:    inbox,
+		Activity: activity,
+		done:     done,
+	}
+	h.Deliveries.track(d)
+	h.Receipts.queued(d)
+	h.queue <- d
+}
+
+// deliveryWorker delivers the queued activities, and schedules a retry on failure.
+func (h *Handler) deliveryWorker() {
+	policy := h.retryPolicy()
+
+	for d := range h.queue {
+		h.Deliveries.update(d, DeliveryInFlight, time.Time{}, nil)
+		status, err := h.deliverWithStatus(d.Username, d.Inbox, d.Activity)
+		if d.done != nil {
+			d.done()
+			d.done = nil
+		}
+		if err == nil {
+			h.Deliveries.done(d)
+			h.Receipts.attempted(d, ReceiptDelivered, status, nil)
+			continue
+		}
+		d.Attempts++
+
+		if d.Attempts >= policy.MaxAttempts || errors.Is(err, errUserSuspended) {
+			log.Printf("dead-lettered delivery of %s %s from %s to %s after %d attempts: %s", d.Activity["type"], d.Activity["id"], d.Username, d.Inbox, d.Attempts, err)
+			h.Deliveries.update(d, DeliveryDead, time.Time{}, err)
+			h.Receipts.attempted(d, DeliveryDead, status, err)
+			continue
+		}
+
+		wait := policy.Backoff(d.Attempts)
+		log.Printf("failed to deliver %s to %s (attempt %d/%d), retrying in %s: %s", d.Activity["id"], d.Inbox, d.Attempts, policy.MaxAttempts, wait, err)
+		h.Deliveries.update(d, DeliveryRetrying, time.Now().Add(wait), err)
+		h.Receipts.attempted(d, DeliveryRetrying, status, err)
+
+		d := d
+		time.AfterFunc(wait, func() {
+			h.Deliveries.update(d, DeliveryPending, time.Time{}, nil)
+			h.queue <- d
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{Base: time.Second, Max: 5 * time.Second, MaxAttempts: 10}
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := p.Backoff(attempts); got != want {
+			t.Errorf("Backoff(%d): expected %s but got %s", attempts, want, got)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.Backoff(3); got < 2*time.Second || got > 4*time.Second {
+			t.Fatalf("Backoff(3) with jitter is out of range: %s", got)
+		}
+	}
+}
+
+// newFlakyInbox starts an inbox that fails the first failures requests with 503, and counts the requests.
+func newFlakyInbox(t *testing.T, h *Handler, failures int32) (*httptest.Server, *int32) {
+	var requests int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= failures {
+			w.WriteHeader(503)
+			return
+		}
+		w.WriteHeader(202)
+	}))
+	t.Cleanup(srv.Close)
+	h.Client = srv.Client()
+	return srv, &requests
+}
+
+// waitDelivery waits until the tracker has no active deliveries, and returns the dead-lettered ones.
+func waitDelivery(t *testing.T, h *Handler) []DeliveryState {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		states := h.Deliveries.List()
+		active := false
+		for _, s := range states {
+			active = active || s.State != DeliveryDead
+		}
+		if !active {
+			return states
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("deliveries did not finish: %+v", h.Deliveries.List())
+	return nil
+}
+
+func TestEnqueue_retry(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	h.Retry = RetryPolicy{Base: 10 * time.Millisecond, Max: 40 * time.Millisecond, MaxAttempts: 5, Jitter: 0.5}
+	srv, requests := newFlakyInbox(t, h, 2)
+
+	h.enqueue("alice", srv.URL+"/inbox", map[string]any{"id": "https://local.example/@alice/activities/1", "type": "Create"}, nil)
+
+	if dead := waitDelivery(t, h); len(dead) != 0 {
+		t.Fatalf("unexpected dead letters: %+v", dead)
+	}
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("expected 3 attempts but got %d", n)
+	}
+}
+
+func TestEnqueue_deadLetter(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	h.Retry = RetryPolicy{Base: 10 * time.Millisecond, Max: 10 * time.Millisecond, MaxAttempts: 3}
+	srv, requests := newFlakyInbox(t, h, 100)
+
+	h.enqueue("alice", srv.URL+"/inbox", map[string]any{"id": "https://local.example/@alice/activities/1", "type": "Create"}, nil)
+
+	dead := waitDelivery(t, h)
+	if len(dead) != 1 || dead[0].Attempts != 3 || dead[0].LastError == "" {
+		t.Fatalf("unexpected dead letters: %+v", dead)
+	}
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("expected 3 attempts but got %d", n)
+	}
+}
+
+func TestFanOut_batchSize(t *testing.T) {
+	const followers = 30
+
+	h := newTestHandler(t, "alice")
+	h.DeliveryWorkers = followers
+	h.FanOutBatchSize = 3
+
+	var inFlight, peak, received int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&received, 1)
+		w.WriteHeader(202)
+	}))
+	t.Cleanup(srv.Close)
+	h.Client = srv.Client()
+
+	// Every follower has its own inbox, so that none of them are merged into a shared inbox.
+	h.Actors = ActorResolverFunc(func(ctx context.Context, id string) (*remoteActor, error) {
+		return &remoteActor{ID: id, Inbox: id + "/inbox"}, nil
+	})
+	for i := 0; i < followers; i++ {
+		h.Followers.Add("alice", fmt.Sprintf("%s/users/follower%d", srv.URL, i))
+	}
+
+	h.Posts.Add(&Post{Username: "alice", Content: "hello", Published: time.Now()})
+	h.fanOut("alice", withContext(h.createActivity(h.Posts.List("alice")[0])))
+	if dead := waitDelivery(t, h); len(dead) != 0 {
+		t.Fatalf("unexpected dead letters: %+v", dead)
+	}
+
+	if n := atomic.LoadInt32(&received); n != followers {
+		t.Errorf("expected %d deliveries but got %d", followers, n)
+	}
+	if p := atomic.LoadInt32(&peak); p > 3 {
+		t.Errorf("expected at most 3 concurrent deliveries but got %d", p)
+	}
+}
+
+func TestEnqueue_suspended(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	h.Retry = RetryPolicy{Base: 10 * time.Millisecond, Max: 10 * time.Millisecond, MaxAttempts: 5}
+	srv, requests := newFlakyInbox(t, h, 0)
+	h.Users[0].Suspended = true
+
+	h.enqueue("alice", srv.URL+"/inbox", map[string]any{"id": "https://local.example/@alice/activities/1", "type": "Create"}, nil)
+
+	// The delivery is dead-lettered at once instead of retried.
+	dead := waitDelivery(t, h)
+	if len(dead) != 1 || dead[0].Attempts != 1 || dead[0].LastError != errUserSuspended.Error() {
+		t.Fatalf("unexpected dead letters: %+v", dead)
+	}
+	if n := atomic.LoadInt32(requests); n != 0 {
+		t.Errorf("expected no requests but got %d", n)
+	}
+}
+
+func TestFanOut_suspended(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	remote := newFakeRemote(t, h)
+	h.Followers.Add("alice", remote.actor("carol"))
+	h.Posts.Add(&Post{Username: "alice", Content: "hello", Published: time.Now()})
+	h.Users[0].Suspended = true
+
+	h.fanOut("alice", withContext(h.createActivity(h.Posts.List("alice")[0])))
+	if states := h.Deliveries.List(); len(states) != 0 {
+		t.Errorf("deliveries of a suspended user are queued: %+v", states)
+	}
+	if err := h.deliver("alice", remote.actor("carol")+"/inbox", map[string]any{"type": "Create"}); !errors.Is(err, errUserSuspended) {
+		t.Errorf("expected %v but got %v", errUserSuspended, err)
+	}
+
+	// Lifting the suspension resumes the delivery.
+	h.Users[0].Suspended = false
+	h.fanOut("alice", withContext(h.createActivity(h.Posts.List("alice")[0])))
+	waitDelivery(t, h)
+	if received := remote.Received(); len(received) != 1 {
+		t.Errorf("expected one delivery after the suspension is lifted but got %v", received)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaxPrunedFollowers is the number of pruned followers that ReachabilityTracker keeps for inspection.
+const MaxPrunedFollowers = 100
+
+// FollowerReachability is the state of a follower that failed the reachability check, for /debug/reachability.
+type FollowerReachability struct {
+	Actor     string     `json:"actor"`
+	Usernames []string   `json:"usernames"`
+	Failures  int        `json:"failures"`
+	FailingAt time.Time  `json:"failingAt"`
+	CheckedAt time.Time  `json:"checkedAt"`
+	LastError string     `json:"lastError"`
+	PrunedAt  *time.Time `json:"prunedAt,omitempty"`
+}
+
+// ReachabilityTracker counts the consecutive failures of the reachability check of each follower,
+// and keeps the last MaxPrunedFollowers pruned ones. It is safe for concurrent use.
+type ReachabilityTracker struct {
+	sync.RWMutex
+	failing map[string]*FollowerReachability
+	pruned  []FollowerReachability
+}
+
+// fail records a failed check and returns the number of consecutive failures.
+func (t *ReachabilityTracker) fail(actor string, usernames []string, err error, now time.Time) int {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.failing == nil {
+		t.failing = make(map[string]*FollowerReachability)
+	}
+	r, ok := t.failing[actor]
+	if !ok {
+		r = &FollowerReachability{Actor: actor, FailingAt: now}
+		t.failing[actor] = r
+	}
+	r.Usernames = usernames
+	r.Failures++
+	r.CheckedAt = now
+	r.LastError = err.Error()
+	return r.Failures
+}
+
+// succeed forgets the failures of the actor.
+func (t *ReachabilityTracker) succeed(actor string) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.failing, actor)
+}
+
+// prune moves the failing actor to the pruned ones.
+func (t *ReachabilityTracker) prune(actor string, now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+
+	r, ok := t.failing[actor]
+	if !ok {
+		return
+	}
+	delete(t.failing, actor)
+	r.PrunedAt = &now
+	t.pruned = append(t.pruned, *r)
+	if len(t.pruned) > MaxPrunedFollowers {
+		t.pruned = t.pruned[len(t.pruned)-MaxPrunedFollowers:]
+	}
+}
+
+// forget drops the failures of the actors that are no longer followers.
+func (t *ReachabilityTracker) forget(followers []string) {
+	t.Lock()
+	defer t.Unlock()
+
+	keep := make(map[string]bool, len(followers))
+	for _, f := range followers {
+		keep[f] = true
+	}
+	for actor := range t.failing {
+		if !keep[actor] {
+			delete(t.failing, actor)
+		}
+	}
+}
+
+// List returns the failing followers ordered by the actor id, and the pruned ones, oldest first.
+func (t *ReachabilityTracker) List() (failing, pruned []FollowerReachability) {
+	t.RLock()
+	defer t.RUnlock()
+
+	failing = []FollowerReachability{}
+	for _, r := range t.failing {
+		failing = append(failing, *r)
+	}
+	sort.Slice(failing, func(i, j int) bool {
+		return failing[i].Actor < failing[j].Actor
+	})
+	return failing, append([]FollowerReachability{}, t.pruned...)
+}
+
+// probeFollower checks that the actor of a follower still exists, which is what its inbox is found by.
+// Network errors, 404, 410 and server errors are failures. Other responses such as 401 of authorized fetch mean the server is up.
+func (h *Handler) probeFollower(ctx context.Context, actor string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", actor, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/activity+json")
+
+	resp, err := h.client().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == 404 || resp.StatusCode == 410 || resp.StatusCode >= 500 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// checkReachability probes every follower once. The followers that failed PruneUnreachableAfter times in a row are removed from every local user.
+func (h *Handler) checkReachability(ctx context.Context) {
+	followers := h.Followers.Actors()
+	h.Reachability.forget(followers)
+
+	for _, actor := range followers {
+		err := h.probeFollower(ctx, actor)
+		if err == nil {
+			h.Reachability.succeed(actor)
+			continue
+		}
+
+		usernames := h.Followers.Usernames(actor)
+		failures := h.Reachability.fail(actor, usernames, err, time.Now())
+		log.Printf("follower %s of %v is unreachable %d times in a row: %s", actor, usernames, failures, err)
+
+		if h.PruneUnreachableAfter > 0 && failures >= h.PruneUnreachableAfter {
+			for _, username := range usernames {
+				h.Followers.Remove(username, actor)
+			}
+			h.Reachability.prune(actor, time.Now())
+			log.Printf("pruned unreachable follower %s of %v", actor, usernames)
+		}
+	}
+}
+
+// runReachabilityCheck runs checkReachability every ReachabilityInterval, forever.
+func (h *Handler) runReachabilityCheck() {
+	ticker := time.NewTicker(h.ReachabilityInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.checkReachability(context.Background())
+	}
+}
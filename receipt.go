@@ -0,0 +1,174 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// ReceiptDelivered is the state of an InboxReceipt that the inbox accepted. The other states are the ones of DeliveryTracker.
+const ReceiptDelivered = "delivered"
+
+// DefaultReceiptRetention is how long delivery receipts are kept when ReceiptStore.Retention is zero.
+const DefaultReceiptRetention = 24 * time.Hour
+
+// InboxReceipt is the outcome of the delivery of a note to an inbox.
+// Status is the HTTP status of the last attempt, or zero if it is not attempted yet or failed without a response.
+type InboxReceipt struct {
+	Inbox       string     `json:"inbox"`
+	State       string     `json:"state"`
+	Status      int        `json:"status,omitempty"`
+	Attempts    int        `json:"attempts"`
+	QueuedAt    time.Time  `json:"queuedAt"`
+	AttemptedAt *time.Time `json:"attemptedAt,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+}
+
+// DeliveryReceipt lists where a note of a local user was delivered to, for /debug/receipts.
+// A note may be delivered by several activities such as Create, Update and Delete; Activities are their ids in the order sent.
+type DeliveryReceipt struct {
+	Note       string          `json:"note"`
+	Username   string          `json:"username"`
+	Activities []string        `json:"activities"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	Inboxes    []*InboxReceipt `json:"inboxes"`
+}
+
+// ReceiptStore keeps the delivery receipts of notes by the note id, and drops the ones older than Retention. It is safe for concurrent use.
+type ReceiptStore struct {
+	sync.RWMutex
+
+	// Retention is how long a receipt is kept after the first delivery of the note. DefaultReceiptRetention is used if zero.
+	Retention time.Duration
+
+	receipts map[string]*DeliveryReceipt
+}
+
+func (s *ReceiptStore) retention() time.Duration {
+	if s.Retention > 0 {
+		return s.Retention
+	}
+	return DefaultReceiptRetention
+}
+
+// expire drops the receipts older than the retention. The caller must hold the write lock.
+func (s *ReceiptStore) expire(now time.Time) {
+	for id, r := range s.receipts {
+		if now.Sub(r.CreatedAt) > s.retention() {
+			delete(s.receipts, id)
+		}
+	}
+}
+
+// inbox finds or adds the receipt of the inbox for the delivery. The caller must hold the write lock.
+func (s *ReceiptStore) inbox(d *delivery, now time.Time) *InboxReceipt {
+	note, activity := receiptNoteID(d.Activity), idOf(d.Activity)
+
+	r, ok := s.receipts[note]
+	if !ok {
+		r = &DeliveryReceipt{
+			Note:       note,
+			Username:   d.Username,
+			Activities: []string{},
+			CreatedAt:  now,
+			Inboxes:    []*InboxReceipt{},
+		}
+		s.receipts[note] = r
+	}
+	if n := len(r.Activities); activity != "" && (n == 0 || r.Activities[n-1] != activity) {
+		r.Activities = append(r.Activities, activity)
+	}
+
+	for _, i := range r.Inboxes {
+		if i.Inbox == d.Inbox {
+			return i
+		}
+	}
+	i := &InboxReceipt{Inbox: d.Inbox}
+	r.Inboxes = append(r.Inboxes, i)
+	return i
+}
+
+// queued records that the delivery is queued. A later activity of the same note restarts the receipt of the inbox.
+func (s *ReceiptStore) queued(d *delivery) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	if s.receipts == nil {
+		s.receipts = make(map[string]*DeliveryReceipt)
+	}
+	s.expire(now)
+
+	*s.inbox(d, now) = InboxReceipt{
+		Inbox:    d.Inbox,
+		State:    DeliveryPending,
+		QueuedAt: now,
+	}
+}
+
+// attempted records the outcome of an attempt of the delivery, and the state it is in afterwards.
+func (s *ReceiptStore) attempted(d *delivery, state string, status int, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	if _, ok := s.receipts[receiptNoteID(d.Activity)]; !ok {
+		// Expired while the delivery was retried.
+		return
+	}
+
+	i := s.inbox(d, now)
+	i.State = state
+	i.Status = status
+	i.Attempts++
+	i.AttemptedAt = &now
+	i.LastError = ""
+	if err != nil {
+		i.LastError = err.Error()
+	}
+}
+
+// List returns the receipts of the user, newest first. If note is not empty, only its receipt is returned.
+func (s *ReceiptStore) List(username, note string) []DeliveryReceipt {
+	s.Lock()
+	defer s.Unlock()
+
+	s.expire(time.Now())
+
+	receipts := []DeliveryReceipt{}
+	for _, r := range s.receipts {
+		if r.Username != username || (note != "" && r.Note != note) {
+			continue
+		}
+		c := *r
+		c.Activities = append([]string{}, r.Activities...)
+		c.Inboxes = make([]*InboxReceipt, len(r.Inboxes))
+		for j, i := range r.Inboxes {
+			i := *i
+			c.Inboxes[j] = &i
+		}
+		receipts = append(receipts, c)
+	}
+	sort.Slice(receipts, func(i, j int) bool {
+		return receipts[i].CreatedAt.After(receipts[j].CreatedAt)
+	})
+	return receipts
+}
+
+// receiptNoteID returns the id of the object that the activity is about, which the receipts are keyed by.
+// Activities without an object id fall back to their own id.
+func receiptNoteID(activity map[string]any) string {
+	if id := idOf(activity["object"]); id != "" {
+		return id
+	}
+	return idOf(activity)
+}
+
+// GetDebugReceipts lists the delivery receipts of the notes of the user, which tell whether each follower inbox received them.
+// The id query parameter selects the receipt of a note.
+func (h *Handler) GetDebugReceipts(c echo.Context) error {
+	return c.JSON(200, h.Receipts.List(c.Param("username"), c.QueryParam("id")))
+}
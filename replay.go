@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// loggedRequest is an entry of the request log written by logRequestForDebug.
+// Body is the activity, or a JSON string of the raw body if it could not be parsed.
+type loggedRequest struct {
+	Datetime string          `json:"datetime"`
+	Remote   string          `json:"remote"`
+	Method   string          `json:"method"`
+	Path     string          `json:"path"`
+	Headers  http.Header     `json:"headers"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// rawBody returns the request body as received.
+func (r loggedRequest) rawBody() []byte {
+	var s string
+	if json.Unmarshal(r.Body, &s) == nil {
+		return []byte(s)
+	}
+	return r.Body
+}
+
+// readRequestLog reads every entry of the request log, in the order received.
+func readRequestLog(path string) ([]loggedRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []loggedRequest
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 0; scanner.Scan(); line++ {
+		var entry loggedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %d of the request log: %w", line+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// PostAdminReplay feeds logged requests into the inbox again, without verifying their signatures, to reproduce a bug without the original sender.
+// The entries are selected by the index query parameter, which is the zero-based line number of the request log, or by the id of the activity.
+// Both may be repeated. The response lists what the inbox responded to each of them.
+func (h *Handler) PostAdminReplay(c echo.Context) error {
+	indexes, ids := c.QueryParams()["index"], c.QueryParams()["id"]
+	if len(indexes) == 0 && len(ids) == 0 {
+		return c.JSON(400, map[string]string{
+			"error": "index or id is required",
+		})
+	}
+
+	entries, err := readRequestLog(RequestLogPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return c.JSON(404, map[string]string{
+			"error": "request log not found",
+		})
+	} else if err != nil {
+		c.Logger().Printf("failed to read the request log: %s", err)
+		return c.JSON(500, map[string]string{
+			"error": "internal server error",
+		})
+	}
+
+	var selected []int
+	for _, s := range indexes {
+		i, err := strconv.Atoi(s)
+		if err != nil || i < 0 || i >= len(entries) {
+			return c.JSON(400, map[string]string{
+				"error": fmt.Sprintf("invalid index: %q", s),
+			})
+		}
+		selected = append(selected, i)
+	}
+	for _, id := range ids {
+		found := false
+		for i, entry := range entries {
+			var activity struct {
+				ID string `json:"id"`
+			}
+			if json.Unmarshal(entry.Body, &activity) == nil && activity.ID == id {
+				selected = append(selected, i)
+				found = true
+			}
+		}
+		if !found {
+			return c.JSON(404, map[string]string{
+				"error": fmt.Sprintf("no logged activity has the id: %q", id),
+			})
+		}
+	}
+
+	results := make([]map[string]any, len(selected))
+	for i, index := range selected {
+		results[i] = h.replay(c, index, entries[index])
+	}
+	return c.JSON(200, results)
+}
+
+// replay dispatches a logged request as if it was received by the inbox of its path, and reports the response.
+func (h *Handler) replay(c echo.Context, index int, entry loggedRequest) map[string]any {
+	result := map[string]any{
+		"index":    index,
+		"datetime": entry.Datetime,
+	}
+
+	username, ok := h.inboxUsername(entry.Path)
+	if !ok {
+		result["error"] = fmt.Sprintf("not an inbox of this host: %s", entry.Path)
+		return result
+	}
+
+	req, err := http.NewRequestWithContext(h.withFetchBudget(c.Request().Context()), entry.Method, entry.Path, bytes.NewReader(entry.rawBody()))
+	if err != nil {
+		result["error"] = err.Error()
+		return result
+	}
+	req.Header = entry.Headers
+	req.Host = h.Hostname
+	req.RemoteAddr = entry.Remote
+
+	activity, _, err := readActivity(req, h.maxJSONDepth(), h.maxBodySize())
+	if err == nil && len(activity.Type) == 0 {
+		err = errors.New("missing activity type")
+	}
+	if err != nil {
+		result["error"] = err.Error()
+		return result
+	}
+	result["id"] = activity.ID
+	result["type"] = activity.Type
+
+	rec := httptest.NewRecorder()
+	rc := c.Echo().NewContext(req, rec)
+	rc.SetParamNames("username")
+	rc.SetParamValues(username)
+	if err := h.dispatchActivity(rc, activity); err != nil {
+		result["error"] = err.Error()
+		return result
+	}
+	c.Logger().Printf("replayed %s from %s at line %d of the request log: %d", strings.Join(activity.Type, ", "), activity.Actor, index+1, rec.Code)
+
+	result["status"] = rec.Code
+	if body := bytes.TrimSpace(rec.Body.Bytes()); json.Valid(body) {
+		result["response"] = json.RawMessage(body)
+	} else {
+		result["response"] = string(body)
+	}
+	return result
+}
+
+// inboxUsername returns the user of the inbox at the path, or an empty string for the shared inbox.
+func (h *Handler) inboxUsername(path string) (string, bool) {
+	rest, ok := strings.CutSuffix(path, "/inbox")
+	if !ok {
+		return "", false
+	}
+	if rest == "" {
+		return "", true
+	}
+	for _, p := range h.actorPaths() {
+		if name, ok := strings.CutPrefix(rest, p); ok {
+			if u, ok := h.lookupUser(name); ok && !strings.Contains(name, "/") {
+				return u.Name, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPostAdminReplay(t *testing.T) {
+	defer func(path string) { RequestLogPath = path }(RequestLogPath)
+	RequestLogPath = "testdata/request.log"
+
+	note := "https://local.example/@alice/posts/1"
+
+	tests := []struct {
+		Name    string
+		Query   string
+		Code    int
+		Results []map[string]any
+	}{
+		{
+			"by index",
+			"index=0",
+			200,
+			[]map[string]any{{"index": float64(0), "status": float64(200), "type": []any{"Like"}}},
+		},
+		{
+			"by id",
+			"id=https://remote.example/users/dave/reactions/1",
+			200,
+			[]map[string]any{{"index": float64(2), "status": float64(202), "type": []any{"EmojiReact"}}},
+		},
+		{
+			"unparseable body and unknown inbox",
+			"index=1&index=3",
+			200,
+			[]map[string]any{{"index": float64(1), "error": errMalformedJSON.Error()}, {"index": float64(3), "error": "not an inbox of this host: /@nobody/inbox"}},
+		},
+		{"no selection", "", 400, nil},
+		{"index out of range", "index=4", 400, nil},
+		{"unknown id", "id=https://remote.example/unknown", 404, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := newTestHandler(t, "alice")
+			h.AdminToken = "secret"
+
+			rec := postAdmin(t, h, "/admin/replay?"+tt.Query, nil)
+			if rec.Code != tt.Code {
+				t.Fatalf("expected %d but got %d: %s", tt.Code, rec.Code, rec.Body)
+			}
+			if tt.Code != 200 {
+				return
+			}
+
+			var results []map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != len(tt.Results) {
+				t.Fatalf("unexpected results: %v", results)
+			}
+			for i, want := range tt.Results {
+				for key, value := range want {
+					if b, _ := json.Marshal(results[i][key]); string(b) != mustMarshal(t, value) {
+						t.Errorf("result %d: expected %s to be %v but got %v", i, key, value, results[i][key])
+					}
+				}
+			}
+
+			// The signatures in the log are not valid, so the reactions are recorded only if verification was skipped.
+			likes, emojis := h.Reactions.Count(note, "Like"), h.Reactions.Count(note, "EmojiReact")
+			if (likes == 1) != (tt.Name == "by index") || (emojis == 1) != (tt.Name == "by id") {
+				t.Errorf("unexpected reactions: %d likes and %d emoji reactions", likes, emojis)
+			}
+		})
+	}
+}
+
+func mustMarshal(t *testing.T, v any) string {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestReadRequestLog(t *testing.T) {
+	entries, err := readRequestLog("testdata/request.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries but got %d", len(entries))
+	}
+
+	// Bodies that could not be parsed are logged as a JSON string of the raw body.
+	if raw := string(entries[1].rawBody()); raw != `{"type":"Create"` {
+		t.Errorf("unexpected raw body: %s", raw)
+	}
+	var activity map[string]any
+	if err := json.Unmarshal(entries[0].rawBody(), &activity); err != nil || activity["type"] != "Like" {
+		t.Errorf("unexpected body: %s", entries[0].rawBody())
+	}
+	if entries[0].Headers.Get("User-Agent") != "Mastodon/4.2.0" || entries[0].Path != "/@alice/inbox" {
+		t.Errorf("unexpected entry: %+v", entries[0])
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// sanitizeAllowedElements are the elements that sanitizeHTML keeps, which are what Mastodon allows in statuses.
+// Other elements are unwrapped: the tags are dropped and the children are kept.
+var sanitizeAllowedElements = map[atom.Atom]bool{
+	atom.P: true, atom.Br: true, atom.Span: true, atom.A: true, atom.Del: true,
+	atom.Pre: true, atom.Code: true, atom.Blockquote: true,
+	atom.Em: true, atom.Strong: true, atom.B: true, atom.I: true, atom.U: true,
+	atom.Ul: true, atom.Ol: true, atom.Li: true,
+}
+
+// sanitizeDroppedElements are the elements that sanitizeHTML drops with their content.
+var sanitizeDroppedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Iframe: true, atom.Object: true,
+	atom.Embed: true, atom.Template: true, atom.Noscript: true, atom.Textarea: true,
+	atom.Title: true, atom.Head: true,
+}
+
+// sanitizeURLSchemes are the schemes allowed in href.
+var sanitizeURLSchemes = map[string]bool{"http": true, "https": true}
+
+// sanitizeClass keeps the classes of microformats and Mastodon, such as h-card, u-url, mention, hashtag, invisible and ellipsis.
+func sanitizeClass(class string) string {
+	var kept []string
+	for _, c := range strings.Fields(class) {
+		switch {
+		case c == "mention", c == "hashtag", c == "invisible", c == "ellipsis":
+		case strings.HasPrefix(c, "h-"), strings.HasPrefix(c, "p-"), strings.HasPrefix(c, "u-"), strings.HasPrefix(c, "dt-"), strings.HasPrefix(c, "e-"):
+		default:
+			continue
+		}
+		kept = append(kept, c)
+	}
+	return strings.Join(kept, " ")
+}
+
+// sanitizeAttrs filters the attributes of an allowed element.
+// Links keep only http(s) href, and always get rel="nofollow noopener noreferrer" and target="_blank".
+func sanitizeAttrs(n *html.Node) []html.Attribute {
+	var attrs []html.Attribute
+	for _, a := range n.Attr {
+		if a.Namespace != "" {
+			continue
+		}
+		switch {
+		case a.Key == "class" && (n.DataAtom == atom.A || n.DataAtom == atom.Span):
+			if a.Val = sanitizeClass(a.Val); a.Val == "" {
+				continue
+			}
+		case a.Key == "href" && n.DataAtom == atom.A:
+			u, err := url.Parse(strings.TrimSpace(a.Val))
+			if err != nil || !sanitizeURLSchemes[strings.ToLower(u.Scheme)] {
+				continue
+			}
+			a.Val = u.String()
+		case a.Key == "start" && n.DataAtom == atom.Ol, a.Key == "reversed" && n.DataAtom == atom.Ol, a.Key == "value" && n.DataAtom == atom.Li:
+		default:
+			continue
+		}
+		attrs = append(attrs, a)
+	}
+	if n.DataAtom == atom.A {
+		attrs = append(attrs,
+			html.Attribute{Key: "rel", Val: "nofollow noopener noreferrer"},
+			html.Attribute{Key: "target", Val: "_blank"},
+		)
+	}
+	return attrs
+}
+
+// sanitizeNodes renders the children of n into buf, keeping only the allowed elements and attributes.
+func sanitizeNodes(buf *bytes.Buffer, n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			buf.WriteString(html.EscapeString(c.Data))
+		case html.ElementNode:
+			if sanitizeDroppedElements[c.DataAtom] {
+				continue
+			}
+			if !sanitizeAllowedElements[c.DataAtom] {
+				sanitizeNodes(buf, c)
+				continue
+			}
+
+			buf.WriteString("<" + c.Data)
+			for _, a := range sanitizeAttrs(c) {
+				buf.WriteString(" " + a.Key + `="` + html.EscapeString(a.Val) + `"`)
+			}
+			buf.WriteString(">")
+			if c.DataAtom == atom.Br {
+				continue
+			}
+			sanitizeNodes(buf, c)
+			buf.WriteString("</" + c.Data + ">")
+		}
+	}
+}
+
+// sanitizeHTML cleans the content of a note for HTML pages, with a policy like Mastodon's:
+// formatting elements, http(s) links and microformats classes are kept, scripts and styles are removed, and the other elements are unwrapped.
+// Only the HTML pages use it; ActivityStreams documents keep the content as is.
+func sanitizeHTML(s string) string {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(s), body)
+	if err != nil {
+		return html.EscapeString(s)
+	}
+
+	root := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+
+	var buf bytes.Buffer
+	sanitizeNodes(&buf, root)
+	return buf.String()
+}
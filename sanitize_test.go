@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output string
+	}{
+		{"<p>hello<br>world</p>", "<p>hello<br>world</p>"},
+		{"<p>hi</p><script>alert(1)</script>", "<p>hi</p>"},
+		{"<style>p{color:red}</style><p>styled</p>", "<p>styled</p>"},
+		{`<p onclick="alert(1)" style="color:red">text</p>`, "<p>text</p>"},
+		{`<img src="x" onerror="alert(1)">`, ""},
+		{"<div><h1>title</h1></div>", "title"},
+		{"1 &lt; 2 &amp; <b>3</b>", "1 &lt; 2 &amp; <b>3</b>"},
+		{
+			`<a href="https://remote.example/@carol" class="u-url mention evil">@carol</a>`,
+			`<a href="https://remote.example/@carol" class="u-url mention" rel="nofollow noopener noreferrer" target="_blank">@carol</a>`,
+		},
+		{`<a href="javascript:alert(1)">click</a>`, `<a rel="nofollow noopener noreferrer" target="_blank">click</a>`},
+		{`<a href=" JavaScript:alert(1)" rel="opener">click</a>`, `<a rel="nofollow noopener noreferrer" target="_blank">click</a>`},
+		{`<span class="invisible">https://</span>`, `<span class="invisible">https://</span>`},
+		{`<ol start="3" type="a"><li value="5">five</li></ol>`, `<ol start="3"><li value="5">five</li></ol>`},
+		{"<p>unclosed <em>emphasis", "<p>unclosed <em>emphasis</em></p>"},
+		{`<svg><script>alert(1)</script></svg>`, ""},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeHTML(tt.Input); got != tt.Output {
+			t.Errorf("%s\nexpected: %s\n     got: %s", tt.Input, tt.Output, got)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultSelfCheckTimeout bounds the whole self-check of a host when SELF_CHECK_TIMEOUT is not set.
+const DefaultSelfCheckTimeout = 30 * time.Second
+
+// selfCheckUsername is the account used for the self-check when any username is accepted.
+const selfCheckUsername = "selfcheck"
+
+// selfCheck fetches our own WebFinger and actor through the public hostname, to catch reverse proxy and hostname mistakes.
+func (h *Handler) selfCheck(ctx context.Context) error {
+	username := selfCheckUsername
+	if len(h.Users) > 0 {
+		username = h.Users[0].Name
+	}
+
+	if err := h.selfVerify(username); err != nil {
+		return fmt.Errorf("signature: %w", err)
+	}
+
+	resource := fmt.Sprintf("acct:%s@%s", username, h.Hostname)
+	var finger struct {
+		Links []struct {
+			Rel  string `json:"rel"`
+			Type string `json:"type"`
+			Href string `json:"href"`
+		} `json:"links"`
+	}
+	if err := h.fetchJSON(ctx, h.baseURL()+"/.well-known/webfinger?resource="+url.QueryEscape(resource), "application/jrd+json", &finger); err != nil {
+		return fmt.Errorf("webfinger: %w", err)
+	}
+
+	var self string
+	for _, l := range finger.Links {
+		if l.Rel == "self" && l.Type == "application/activity+json" {
+			self = l.Href
+		}
+	}
+	if self != h.userURL(username) {
+		return fmt.Errorf("webfinger: self link is %q, expected %q", self, h.userURL(username))
+	}
+
+	var actor struct {
+		ID        string `json:"id"`
+		PublicKey struct {
+			PublicKeyPem string `json:"publicKeyPem"`
+		} `json:"publicKey"`
+	}
+	if err := h.fetchJSON(ctx, self, "application/activity+json", &actor); err != nil {
+		return fmt.Errorf("actor: %w", err)
+	}
+	if actor.ID != self {
+		return fmt.Errorf("actor: id is %q, expected %q", actor.ID, self)
+	}
+	if actor.PublicKey.PublicKeyPem == "" {
+		return errors.New("actor: publicKeyPem is missing")
+	}
+	if _, err := parsePublicKeyPEM(actor.PublicKey.PublicKeyPem); err != nil {
+		return fmt.Errorf("actor: %w", err)
+	}
+
+	return nil
+}
+
+// selfVerify signs a sample activity as the user in the same way as deliveries, and verifies it with the inbox's verifier using the local public key.
+// It catches a signer that does not match the verifier, such as a wrong covered header set or digest, before any remote server rejects it.
+func (h *Handler) selfVerify(username string) error {
+	actor := h.userURL(username)
+	lookup := func(ctx context.Context, keyID string) (*rsa.PublicKey, string, error) {
+		keyPEM, err := h.Keys.PublicKeyPEM(username)
+		if err != nil {
+			return nil, "", err
+		}
+		key, err := parsePublicKeyPEM(keyPEM)
+		return key, actor, err
+	}
+
+	activity := func(object string) []byte {
+		body, _ := json.Marshal(map[string]any{
+			"@context": ActivityStreamsContext,
+			"id":       actor + "#self-verify",
+			"type":     "Like",
+			"actor":    actor,
+			"object":   object,
+		})
+		return body
+	}
+	body := activity(actor)
+
+	req, err := h.newSignedRequest(username, actor+"/inbox", body)
+	if err != nil {
+		return err
+	}
+
+	key, err := h.verifyRequestWith(req, body, lookup)
+	if err == nil {
+		err = checkKeyOwner(actor, key)
+	}
+	if err != nil {
+		return fmt.Errorf("own signature does not verify: %w", err)
+	}
+
+	signed := h.SignedHeaders
+	if signed == nil {
+		signed = DefaultSignedHeaders
+	}
+	expected := signed
+	if h.SignatureFormat == SignatureFormatRFC9421 {
+		expected = rfc9421Components(signed)
+	}
+	if strings.Join(key.Headers, " ") != strings.Join(expected, " ") {
+		return fmt.Errorf("signature covers %q, expected %q", key.Headers, expected)
+	}
+
+	// The digest must bind the signature to the body, so the same headers with another body have to be rejected.
+	for _, name := range signed {
+		if name == "digest" {
+			if _, err := h.verifyRequestWith(req, activity(actor+"/tampered"), lookup); signatureErrorCode(err) != DigestMismatch {
+				return fmt.Errorf("tampered body is not rejected by the digest: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// runSelfCheck runs the self-check of the host and logs the result. Failures are only warned.
+func (h *Handler) runSelfCheck(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := h.selfCheck(ctx); err != nil {
+		log.Printf("WARNING: self-check of %s failed: %s", h.Hostname, err)
+		return
+	}
+	log.Printf("self-check of %s passed", h.Hostname)
+}
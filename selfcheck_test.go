@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+)
+
+func TestSelfVerify(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Headers []string
+		Format  string
+		OK      bool
+	}{
+		{"default", nil, "", true},
+		{"with content-type", []string{"(request-target)", "host", "date", "digest", "content-type"}, "", true},
+		{"RFC 9421", nil, SignatureFormatRFC9421, true},
+
+		// The inbox requires digest for requests with a body, so the self-check has to catch a configuration without it.
+		{"without digest", []string{"(request-target)", "host", "date"}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := newTestHandler(t, "alice")
+			h.SignedHeaders = tt.Headers
+			h.SignatureFormat = tt.Format
+
+			if err := h.selfVerify("alice"); (err == nil) != tt.OK {
+				t.Errorf("unexpected result: %v", err)
+			}
+		})
+	}
+}
+
+// mismatchedKeyStore signs with one key but publishes another, like a signer that is broken.
+type mismatchedKeyStore struct {
+	StaticKeyStore
+	published *rsa.PrivateKey
+}
+
+func (s *mismatchedKeyStore) PublicKeyPEM(username string) (string, error) {
+	return encodePublicKeyPEM(&s.published.PublicKey)
+}
+
+func TestSelfVerify_brokenSigner(t *testing.T) {
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := newTestHandler(t, "alice")
+	h.Keys = &mismatchedKeyStore{StaticKeyStore: StaticKeyStore{Key: testKey(t)}, published: other}
+
+	if err := h.selfVerify("alice"); err == nil || !strings.Contains(err.Error(), "does not verify") {
+		t.Errorf("expected the broken signer to be caught but got %v", err)
+	}
+}
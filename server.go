@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// The default timeouts of the server. They can be changed by SERVER_READ_TIMEOUT, SERVER_WRITE_TIMEOUT and SERVER_IDLE_TIMEOUT.
+// ReadHeaderTimeout is short, so that slow clients cannot hold connections to the public inbox.
+const (
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultReadTimeout       = 30 * time.Second
+	DefaultWriteTimeout      = 60 * time.Second
+	DefaultIdleTimeout       = 120 * time.Second
+)
+
+// newServer makes the HTTP server with the timeouts from the environment variables.
+// HTTP/2 is served over cleartext (h2c) too, because TLS is terminated by the reverse proxy. DISABLE_HTTP2 turns it off.
+func newServer(handler http.Handler) *http.Server {
+	s := &http.Server{
+		ReadHeaderTimeout: DefaultReadHeaderTimeout,
+		ReadTimeout:       DefaultReadTimeout,
+		WriteTimeout:      DefaultWriteTimeout,
+		IdleTimeout:       DefaultIdleTimeout,
+	}
+	if d, err := time.ParseDuration(os.Getenv("SERVER_READ_TIMEOUT")); err == nil {
+		s.ReadTimeout = d
+		if d < s.ReadHeaderTimeout {
+			s.ReadHeaderTimeout = d
+		}
+	}
+	if d, err := time.ParseDuration(os.Getenv("SERVER_WRITE_TIMEOUT")); err == nil {
+		s.WriteTimeout = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("SERVER_IDLE_TIMEOUT")); err == nil {
+		s.IdleTimeout = d
+	}
+
+	if os.Getenv("DISABLE_HTTP2") != "" {
+		s.Handler = handler
+	} else {
+		s.Handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: s.IdleTimeout})
+	}
+	return s
+}
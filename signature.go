@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxClockSkew is how far in the future a signature's created time or Date header can be.
+const maxClockSkew = 5 * time.Minute
+
+// maxSignatureAge is how old a Date header can be, or the created time of a signature that does not cover Date.
+const maxSignatureAge = 12 * time.Hour
+
+// SignatureErrorCode tells the sender which check of the signature verification failed.
+type SignatureErrorCode string
+
+const (
+	SignatureMissing     SignatureErrorCode = "signature_missing"
+	SignatureMalformed   SignatureErrorCode = "signature_malformed"
+	UnsupportedAlgorithm SignatureErrorCode = "unsupported_algorithm"
+	StaleDate            SignatureErrorCode = "stale_date"
+	SignedHeaderMissing  SignatureErrorCode = "signed_header_missing"
+	DigestMismatch       SignatureErrorCode = "digest_mismatch"
+	KeyFetchFailed       SignatureErrorCode = "key_fetch_failed"
+	SignatureInvalid     SignatureErrorCode = "signature_invalid"
+	ActorMismatch        SignatureErrorCode = "actor_mismatch"
+)
+
+// signatureError is a failure of the signature verification with its code.
+type signatureError struct {
+	Code SignatureErrorCode
+	Err  error
+}
+
+func (e *signatureError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *signatureError) Unwrap() error {
+	return e.Err
+}
+
+// signatureErrorCode returns the code of the verification failure. SignatureInvalid is used for errors without a code.
+func signatureErrorCode(err error) SignatureErrorCode {
+	var e *signatureError
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return SignatureInvalid
+}
+
+// signatureParams is a parsed draft-cavage HTTP Signature header, or a signature of RFC 9421.
+// Headers are the covered component identifiers for RFC 9421.
+type signatureParams struct {
+	KeyID     string
+	Algorithm string
+	Headers   []string
+	Signature []byte
+	Created   time.Time
+	Expires   time.Time
+
+	// Input is the covered components with the signature parameters as in Signature-Input, which is signed as @signature-params.
+	// It is empty for draft-cavage.
+	Input string
+}
+
+// parseSignatureHeader parses the value of a Signature header such as `keyId="...",headers="...",signature="..."`.
+func parseSignatureHeader(header string) (*signatureParams, error) {
+	var p signatureParams
+
+	for _, field := range splitSignatureFields(header) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed signature parameter: %q", field)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+
+		switch key {
+		case "keyId":
+			p.KeyID = value
+		case "algorithm":
+			p.Algorithm = value
+		case "headers":
+			p.Headers = strings.Fields(strings.ToLower(value))
+		case "signature":
+			sig, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("malformed signature: %w", err)
+			}
+			p.Signature = sig
+		case "created", "expires":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("malformed %s parameter: %w", key, err)
+			}
+			if key == "created" {
+				p.Created = time.Unix(n, 0)
+			} else {
+				p.Expires = time.Unix(n, 0)
+			}
+		}
+	}
+
+	if p.KeyID == "" {
+		return nil, errors.New("keyId is missing")
+	}
+	if len(p.Signature) == 0 {
+		return nil, errors.New("signature is missing")
+	}
+	if len(p.Headers) == 0 {
+		p.Headers = []string{"date"}
+		if !p.Created.IsZero() {
+			p.Headers = []string{"(created)"}
+		}
+	}
+
+	return &p, nil
+}
+
+// splitSignatureFields splits a Signature header by commas, ignoring commas inside of quotes.
+func splitSignatureFields(header string) []string {
+	var fields []string
+	quoted := false
+	start := 0
+	for i, c := range header {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			fields = append(fields, header[start:i])
+			start = i + 1
+		}
+	}
+	return append(fields, header[start:])
+}
+
+// buildSigningString reconstructs the string that the sender should have signed.
+func buildSigningString(r *http.Request, p *signatureParams) (string, error) {
+	lines := make([]string, 0, len(p.Headers))
+
+	for _, name := range p.Headers {
+		var value string
+
+		switch name {
+		case "(request-target)":
+			value = strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "(created)":
+			if p.Created.IsZero() {
+				return "", errors.New("(created) is signed but created parameter is missing")
+			}
+			value = strconv.FormatInt(p.Created.Unix(), 10)
+		case "(expires)":
+			if p.Expires.IsZero() {
+				return "", errors.New("(expires) is signed but expires parameter is missing")
+			}
+			value = strconv.FormatInt(p.Expires.Unix(), 10)
+		case "host":
+			value = r.Host
+		default:
+			values := r.Header.Values(name)
+			if len(values) == 0 {
+				return "", fmt.Errorf("signed header is missing: %s", name)
+			}
+			value = strings.Join(values, ", ")
+		}
+
+		lines = append(lines, name+": "+value)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// checkSignatureTime rejects signatures that are expired, too old or too far in the future.
+// The age is checked by the Date header if it is signed, and by the created parameter otherwise; a signature with neither could be replayed forever.
+func checkSignatureTime(r *http.Request, p *signatureParams, now time.Time) error {
+	if !p.Expires.IsZero() && p.Expires.Before(now) {
+		return errors.New("signature has expired")
+	}
+	if !p.Created.IsZero() && p.Created.After(now.Add(maxClockSkew)) {
+		return errors.New("signature is created in the future")
+	}
+
+	if !covers(p.Headers, "date") {
+		if p.Created.IsZero() {
+			return errors.New("signature covers neither Date header nor created time")
+		}
+		if p.Created.Before(now.Add(-maxSignatureAge)) {
+			return errors.New("signature is created too long ago")
+		}
+		return nil
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("malformed Date header: %w", err)
+	}
+	if date.After(now.Add(maxClockSkew)) || date.Before(now.Add(-maxSignatureAge)) {
+		return errors.New("Date header is out of the acceptable range")
+	}
+	return nil
+}
+
+// covers reports whether the signature covers any of the headers or components.
+func covers(headers []string, names ...string) bool {
+	for _, h := range headers {
+		for _, n := range names {
+			if h == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkDigestSigned rejects a signature of a request with a body that does not cover its digest, because the body could be swapped otherwise.
+// draft-cavage has to cover Digest, and RFC 9421 either Content-Digest or Digest.
+func checkDigestSigned(r *http.Request, p *signatureParams) error {
+	if r.Method == "GET" || r.Method == "HEAD" {
+		return nil
+	}
+	if p.Input == "" && !covers(p.Headers, "digest") {
+		return errors.New("digest must be signed for requests with a body")
+	}
+	if p.Input != "" && !covers(p.Headers, "content-digest", "digest") {
+		return errors.New("content-digest must be signed for requests with a body")
+	}
+	return nil
+}
+
+// checkDigest verifies the Digest header against the request body, if the header is present.
+// checkDigestSigned ensures that it is present for requests with a body. The body must be canonicalized in the same way as the sender did.
+func checkDigest(r *http.Request, body []byte) error {
+	header := r.Header.Get("Digest")
+	if header == "" {
+		return nil
+	}
+
+	for _, d := range strings.Split(header, ",") {
+		algo, value, ok := strings.Cut(strings.TrimSpace(d), "=")
+		if !ok || !strings.EqualFold(algo, "SHA-256") {
+			continue
+		}
+		sum := sha256.Sum256(body)
+		if value != base64.StdEncoding.EncodeToString(sum[:]) {
+			return errors.New("digest mismatch")
+		}
+		return nil
+	}
+
+	return errors.New("unsupported digest algorithm")
+}
+
+// fetchPublicKey fetches the PEM encoded public key identified by keyID, and returns it with the id of its owner.
+func (h *Handler) fetchPublicKey(ctx context.Context, keyID string) (*rsa.PublicKey, string, error) {
+	var doc struct {
+		ID           string `json:"id"`
+		Owner        string `json:"owner"`
+		PublicKeyPem string `json:"publicKeyPem"`
+		PublicKey    struct {
+			Owner        string `json:"owner"`
+			PublicKeyPem string `json:"publicKeyPem"`
+		} `json:"publicKey"`
+	}
+	if err := h.fetchJSON(ctx, keyID, "application/activity+json", &doc); err != nil {
+		return nil, "", err
+	}
+
+	// The keyId may point to either the actor that has the key, or the key document itself.
+	keyPEM, owner := doc.PublicKey.PublicKeyPem, doc.PublicKey.Owner
+	if keyPEM == "" {
+		keyPEM, owner = doc.PublicKeyPem, doc.Owner
+	} else if owner == "" {
+		owner = doc.ID
+	}
+
+	key, err := parsePublicKeyPEM(keyPEM)
+	return key, owner, err
+}
+
+// parsePublicKeyPEM parses an RSA public key in either PKIX or PKCS#1 form.
+func parsePublicKeyPEM(keyPEM string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		if k, ok := key.(*rsa.PublicKey); ok {
+			return k, nil
+		}
+		return nil, errors.New("public key is not RSA")
+	}
+
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
+
+// verifiedKey describes the key that signed a request.
+type verifiedKey struct {
+	ID    string
+	Owner string
+
+	// Headers and SigningString are what the signature covers, for debugging failed verifications.
+	// They never contain the signature itself.
+	Headers       []string
+	SigningString string
+}
+
+// publicKeyLookup finds the public key identified by keyID, and returns it with the id of its owner.
+type publicKeyLookup func(ctx context.Context, keyID string) (*rsa.PublicKey, string, error)
+
+// verifyRequest verifies the HTTP Signature of an incoming request and returns the key that signed it.
+// The returned ID is set even on failure if the Signature header could be parsed.
+func (h *Handler) verifyRequest(r *http.Request, body []byte) (verifiedKey, error) {
+	return h.verifyRequestWith(r, body, h.fetchPublicKey)
+}
+
+// verifyRequestWith is verifyRequest that looks up the public key with lookup instead of fetching it.
+func (h *Handler) verifyRequestWith(r *http.Request, body []byte, lookup publicKeyLookup) (verifiedKey, error) {
+	header := r.Header.Get("Signature")
+	if header == "" {
+		return verifiedKey{}, &signatureError{SignatureMissing, errors.New("Signature header is missing")}
+	}
+
+	// RFC 9421 is used if Signature-Input is present, and draft-cavage otherwise.
+	var p *signatureParams
+	var err error
+	build := buildSigningString
+	if input := strings.Join(r.Header.Values("Signature-Input"), ", "); input != "" {
+		p, err = parseSignatureInput(input, strings.Join(r.Header.Values("Signature"), ", "))
+		build = buildSignatureBase
+	} else {
+		p, err = parseSignatureHeader(header)
+	}
+	if err != nil {
+		return verifiedKey{}, &signatureError{SignatureMalformed, err}
+	}
+	signer := verifiedKey{ID: p.KeyID, Headers: p.Headers}
+
+	switch p.Algorithm {
+	case "", "hs2019", "rsa-sha256", "rsa-v1_5-sha256", "rsa-pss-sha512":
+	default:
+		return signer, &signatureError{UnsupportedAlgorithm, fmt.Errorf("unsupported algorithm: %s", p.Algorithm)}
+	}
+
+	if err := checkDigestSigned(r, p); err != nil {
+		return signer, &signatureError{SignedHeaderMissing, err}
+	}
+	signingString, err := build(r, p)
+	if err != nil {
+		return signer, &signatureError{SignedHeaderMissing, err}
+	}
+	signer.SigningString = signingString
+
+	if err := checkSignatureTime(r, p, time.Now()); err != nil {
+		return signer, &signatureError{StaleDate, err}
+	}
+
+	digested, err := h.canonicalizer().Canonicalize(body)
+	if err != nil {
+		return signer, &signatureError{DigestMismatch, fmt.Errorf("failed to canonicalize body: %w", err)}
+	}
+	if err := checkDigest(r, digested); err != nil {
+		return signer, &signatureError{DigestMismatch, err}
+	}
+	if err := checkContentDigest(r, digested); err != nil {
+		return signer, &signatureError{DigestMismatch, err}
+	}
+
+	key, owner, err := lookup(r.Context(), p.KeyID)
+	if err != nil {
+		return signer, &signatureError{KeyFetchFailed, fmt.Errorf("failed to fetch public key: %w", err)}
+	}
+
+	if err := verifyRSA(key, p.Algorithm, signingString, p.Signature); err != nil {
+		return signer, &signatureError{SignatureInvalid, errors.New("signature is invalid")}
+	}
+
+	signer.Owner = owner
+	return signer, nil
+}
+
+// checkKeyOwner ensures that the activity's actor is the one who signed the request.
+func checkKeyOwner(actor string, key verifiedKey) error {
+	actorHost := hostOf(actor)
+	if actorHost == "" {
+		return &signatureError{ActorMismatch, fmt.Errorf("invalid actor: %q", actor)}
+	}
+	keyHost := hostOf(key.ID)
+	if keyHost == "" {
+		return &signatureError{ActorMismatch, fmt.Errorf("invalid keyId: %q", key.ID)}
+	}
+
+	if actorHost != keyHost {
+		return &signatureError{ActorMismatch, fmt.Errorf("actor host %q does not match keyId host %q", actorHost, keyHost)}
+	}
+	if key.Owner != actor {
+		return &signatureError{ActorMismatch, fmt.Errorf("key is owned by %q, not by actor %q", key.Owner, actor)}
+	}
+
+	return nil
+}
+
+// DefaultSignedHeaders are the headers that outgoing signatures cover when Handler.SignedHeaders is nil, which is what Mastodon sends.
+var DefaultSignedHeaders = []string{"(request-target)", "host", "date", "digest"}
+
+// parseSignedHeaders parses a space or comma separated list of the headers to sign, such as "(request-target) host date digest content-type".
+// It must include (request-target) and date, without which the signature could be replayed for other requests.
+func parseSignedHeaders(s string) ([]string, error) {
+	headers := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+
+	for _, required := range []string{"(request-target)", "date"} {
+		found := false
+		for _, h := range headers {
+			found = found || h == required
+		}
+		if !found {
+			return nil, fmt.Errorf("%s must be signed", required)
+		}
+	}
+	return headers, nil
+}
+
+// signRequest signs an outgoing request in the draft-cavage format that Mastodon expects.
+// The body is what the Digest covers; usually the same bytes as the request body.
+// The signature covers the headers, or DefaultSignedHeaders if nil. digest is skipped for requests without a body.
+func signRequest(r *http.Request, keyID string, key *rsa.PrivateKey, body []byte, signed []string) error {
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	r.Host = r.URL.Host
+
+	if signed == nil {
+		signed = DefaultSignedHeaders
+	}
+	hasBody := r.Method != "GET" && r.Method != "HEAD"
+
+	var headers []string
+	for _, h := range signed {
+		if h == "digest" {
+			if !hasBody {
+				continue
+			}
+			sum := sha256.Sum256(body)
+			r.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+		}
+		headers = append(headers, h)
+	}
+
+	p := &signatureParams{KeyID: keyID, Algorithm: "rsa-sha256", Headers: headers}
+	signingString, err := buildSigningString(r, p)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256([]byte(signingString))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Signature", fmt.Sprintf(
+		`keyId="%s",algorithm="%s",headers="%s",signature="%s"`,
+		keyID,
+		p.Algorithm,
+		strings.Join(headers, " "),
+		base64.StdEncoding.EncodeToString(sig),
+	))
+	return nil
+}
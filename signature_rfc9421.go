@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The values of Handler.SignatureFormat.
+const (
+	SignatureFormatCavage  = "cavage"
+	SignatureFormatRFC9421 = "rfc9421"
+)
+
+// rfc9421Label is the label of the signatures that we send.
+const rfc9421Label = "sig1"
+
+// parseSignatureInput parses the Signature-Input and Signature headers of RFC 9421, such as
+// `sig1=("@method" "@target-uri");created=1700000000;keyid="..."` and `sig1=:base64:`.
+// The first signature in Signature-Input that has its value in Signature is used.
+func parseSignatureInput(input, signature string) (*signatureParams, error) {
+	values := make(map[string]string)
+	for _, m := range splitStructuredField(signature, ',') {
+		label, value, ok := strings.Cut(m, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed Signature member: %q", m)
+		}
+		values[label] = value
+	}
+
+	for _, m := range splitStructuredField(input, ',') {
+		label, inner, ok := strings.Cut(m, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed Signature-Input member: %q", m)
+		}
+		value, ok := values[label]
+		if !ok {
+			continue
+		}
+
+		p, err := parseSignatureInputMember(inner)
+		if err != nil {
+			return nil, fmt.Errorf("signature %s: %w", label, err)
+		}
+		if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			return nil, fmt.Errorf("signature %s is not a byte sequence", label)
+		}
+		if p.Signature, err = base64.StdEncoding.DecodeString(value[1 : len(value)-1]); err != nil {
+			return nil, fmt.Errorf("malformed signature %s: %w", label, err)
+		}
+		return p, nil
+	}
+
+	return nil, errors.New("no signature in Signature-Input has its value in Signature")
+}
+
+// parseSignatureInputMember parses an inner list of the covered components with the signature parameters.
+func parseSignatureInputMember(inner string) (*signatureParams, error) {
+	p := signatureParams{Input: inner}
+
+	if !strings.HasPrefix(inner, "(") {
+		return nil, errors.New("covered components must be an inner list")
+	}
+	end := strings.IndexByte(inner, ')')
+	if end < 0 {
+		return nil, errors.New("inner list is not closed")
+	}
+	for _, item := range strings.Fields(inner[1:end]) {
+		if len(item) < 2 || item[0] != '"' || item[len(item)-1] != '"' {
+			return nil, fmt.Errorf("unsupported component identifier: %s", item)
+		}
+		p.Headers = append(p.Headers, item[1:len(item)-1])
+	}
+
+	for _, param := range splitStructuredField(inner[end+1:], ';')[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		value = strings.Trim(value, `"`)
+
+		switch key {
+		case "keyid":
+			p.KeyID = value
+		case "alg":
+			p.Algorithm = value
+		case "created", "expires":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("malformed %s parameter: %w", key, err)
+			}
+			if key == "created" {
+				p.Created = time.Unix(n, 0)
+			} else {
+				p.Expires = time.Unix(n, 0)
+			}
+		}
+	}
+
+	if p.KeyID == "" {
+		return nil, errors.New("keyid is missing")
+	}
+	return &p, nil
+}
+
+// splitStructuredField splits a structured field value of RFC 8941 by sep, ignoring the separators inside of strings and inner lists.
+// The parts are trimmed of spaces. The first part is kept even if empty, so that the parameters after an inner list can be split.
+func splitStructuredField(s string, sep byte) []string {
+	var parts []string
+	quoted, depth, start := false, 0, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+		case c == ')' && !quoted:
+			depth--
+		case c == sep && !quoted && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// requestScheme guesses the scheme of a request. Incoming requests are assumed to be https behind the reverse proxy, unless X-Forwarded-Proto tells otherwise.
+func requestScheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return r.URL.Scheme
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		return proto
+	}
+	return "https"
+}
+
+// buildSignatureBase reconstructs the signature base of RFC 9421 that the sender should have signed.
+// Components with parameters such as ;sf or ;req are not supported.
+func buildSignatureBase(r *http.Request, p *signatureParams) (string, error) {
+	lines := make([]string, 0, len(p.Headers)+1)
+
+	for _, name := range p.Headers {
+		var value string
+
+		switch name {
+		case "@method":
+			value = r.Method
+		case "@target-uri":
+			value = requestScheme(r) + "://" + r.Host + r.URL.RequestURI()
+		case "@authority":
+			value = strings.ToLower(r.Host)
+		case "@scheme":
+			value = requestScheme(r)
+		case "@request-target":
+			value = r.URL.RequestURI()
+		case "@path":
+			value = r.URL.EscapedPath()
+			if value == "" {
+				value = "/"
+			}
+		case "@query":
+			value = "?" + r.URL.RawQuery
+		default:
+			if strings.HasPrefix(name, "@") {
+				return "", fmt.Errorf("unsupported derived component: %s", name)
+			}
+			values := r.Header.Values(name)
+			if len(values) == 0 {
+				return "", fmt.Errorf("signed header is missing: %s", name)
+			}
+			for i, v := range values {
+				values[i] = strings.TrimSpace(v)
+			}
+			value = strings.Join(values, ", ")
+		}
+
+		lines = append(lines, strconv.Quote(name)+": "+value)
+	}
+
+	lines = append(lines, `"@signature-params": `+p.Input)
+	return strings.Join(lines, "\n"), nil
+}
+
+// checkContentDigest verifies the Content-Digest header of RFC 9530 against the request body, if the header is present.
+func checkContentDigest(r *http.Request, body []byte) error {
+	header := strings.Join(r.Header.Values("Content-Digest"), ", ")
+	if header == "" {
+		return nil
+	}
+
+	for _, d := range splitStructuredField(header, ',') {
+		algo, value, _ := strings.Cut(d, "=")
+		var h hash.Hash
+		switch algo {
+		case "sha-256":
+			h = sha256.New()
+		case "sha-512":
+			h = sha512.New()
+		default:
+			continue
+		}
+		h.Write(body)
+		if value != ":"+base64.StdEncoding.EncodeToString(h.Sum(nil))+":" {
+			return errors.New("content digest mismatch")
+		}
+		return nil
+	}
+
+	return errors.New("unsupported content digest algorithm")
+}
+
+// verifyRSA verifies the signature over the signing string with the algorithm of either format.
+func verifyRSA(key *rsa.PublicKey, algorithm, signingString string, signature []byte) error {
+	if algorithm == "rsa-pss-sha512" {
+		hash := sha512.Sum512([]byte(signingString))
+		return rsa.VerifyPSS(key, crypto.SHA512, hash[:], signature, nil)
+	}
+	hash := sha256.Sum256([]byte(signingString))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+}
+
+// rfc9421Components converts the draft-cavage header names into the RFC 9421 components that cover the same things.
+// (created) is dropped, because the created parameter is always sent.
+func rfc9421Components(headers []string) []string {
+	var components []string
+	for _, h := range headers {
+		switch h {
+		case "(request-target)":
+			components = append(components, "@method", "@target-uri")
+		case "host":
+			components = append(components, "@authority")
+		case "digest":
+			components = append(components, "content-digest")
+		case "(created)":
+		default:
+			components = append(components, h)
+		}
+	}
+	return components
+}
+
+// signRequestRFC9421 is signRequest that emits the Signature-Input and Signature headers of RFC 9421 instead of draft-cavage.
+// The signed headers are the same names as signRequest, and converted by rfc9421Components.
+func signRequestRFC9421(r *http.Request, keyID string, key *rsa.PrivateKey, body []byte, signed []string) error {
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	r.Host = r.URL.Host
+
+	if signed == nil {
+		signed = DefaultSignedHeaders
+	}
+	hasBody := r.Method != "GET" && r.Method != "HEAD"
+
+	var quoted []string
+	p := &signatureParams{KeyID: keyID, Algorithm: "rsa-v1_5-sha256"}
+	for _, c := range rfc9421Components(signed) {
+		if c == "content-digest" {
+			if !hasBody {
+				continue
+			}
+			sum := sha256.Sum256(body)
+			r.Header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+		}
+		p.Headers = append(p.Headers, c)
+		quoted = append(quoted, strconv.Quote(c))
+	}
+	p.Input = fmt.Sprintf(`(%s);created=%d;keyid=%s;alg="%s"`, strings.Join(quoted, " "), time.Now().Unix(), strconv.Quote(keyID), p.Algorithm)
+
+	base, err := buildSignatureBase(r, p)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256([]byte(base))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Signature-Input", rfc9421Label+"="+p.Input)
+	r.Header.Set("Signature", rfc9421Label+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newRFC9421Post builds a POST signed in RFC 9421 by hand, independently of signRequestRFC9421, with the algorithm.
+// The label is not the sig1 that we send, because any label is allowed.
+func newRFC9421Post(t *testing.T, url, body, algorithm string) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Now().UTC().Format(http.TimeFormat)
+	sum := sha256.Sum256([]byte(body))
+	digest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	req.Header.Set("Date", date)
+	req.Header.Set("Content-Type", "application/activity+json")
+	req.Header.Set("Content-Digest", digest)
+
+	params := fmt.Sprintf(`("@method" "@target-uri" "@authority" "content-digest" "date");created=%d;keyid="%s";alg="%s"`, time.Now().Unix(), testRemoteKeyID, algorithm)
+	base := strings.Join([]string{
+		`"@method": POST`,
+		`"@target-uri": ` + url,
+		`"@authority": ` + req.URL.Host,
+		`"content-digest": ` + digest,
+		`"date": ` + date,
+		`"@signature-params": ` + params,
+	}, "\n")
+
+	var sig []byte
+	if algorithm == "rsa-pss-sha512" {
+		hash := sha512.Sum512([]byte(base))
+		sig, err = rsa.SignPSS(rand.Reader, testKey(t), crypto.SHA512, hash[:], nil)
+	} else {
+		hash := sha256.Sum256([]byte(base))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, testKey(t), crypto.SHA256, hash[:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Signature-Input", "mysig="+params)
+	req.Header.Set("Signature", "mysig=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	return req
+}
+
+func TestVerifyRequest_rfc9421(t *testing.T) {
+	h := newTestHandler(t)
+	body := `{"type":"Create"}`
+
+	for _, algorithm := range []string{"rsa-v1_5-sha256", "rsa-pss-sha512"} {
+		t.Run(algorithm, func(t *testing.T) {
+			req := newRFC9421Post(t, "https://local.example/inbox", body, algorithm)
+			key, err := h.verifyRequestWith(req, []byte(body), testLookup(t))
+			if err != nil {
+				t.Fatalf("failed to verify: %s", err)
+			}
+			if key.ID != testRemoteKeyID || strings.Join(key.Headers, " ") != "@method @target-uri @authority content-digest date" {
+				t.Errorf("unexpected key: %+v", key)
+			}
+
+			if _, err := h.verifyRequestWith(req, []byte(`{"type":"Delete"}`), testLookup(t)); signatureErrorCode(err) != DigestMismatch {
+				t.Errorf("expected %s for another body but got %v", DigestMismatch, err)
+			}
+
+			req.Header.Set("Date", time.Now().Add(time.Second).UTC().Format(http.TimeFormat))
+			if _, err := h.verifyRequestWith(req, []byte(body), testLookup(t)); signatureErrorCode(err) != SignatureInvalid {
+				t.Errorf("expected %s for another date but got %v", SignatureInvalid, err)
+			}
+		})
+	}
+}
+
+func TestPostInbox_rfc9421(t *testing.T) {
+	h := newTestHandler(t, "alice")
+	remote := newFakeRemote(t, h)
+	actor := remote.actor("carol")
+
+	body := fmt.Sprintf(`{"@context":"https://www.w3.org/ns/activitystreams","id":"%s/follows/1","type":"Follow","actor":"%s","object":"%s"}`, actor, actor, h.userURL("alice"))
+	req, err := http.NewRequest("POST", "https://"+h.Hostname+"/@alice/inbox", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/activity+json")
+	if err := signRequestRFC9421(req, actor+"#main-key", testKey(t), []byte(body), nil); err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get("Signature-Input") == "" || req.Header.Get("Content-Digest") == "" || req.Header.Get("Digest") != "" {
+		t.Fatalf("unexpected headers for RFC 9421: %v", req.Header)
+	}
+
+	if rec := serve(h, req); rec.Code >= 300 {
+		t.Fatalf("the RFC 9421 request is rejected: %d %s", rec.Code, rec.Body)
+	}
+	if followers := h.Followers.List("alice"); len(followers) != 1 || followers[0] != actor {
+		t.Errorf("unexpected followers: %v", followers)
+	}
+}
+
+func TestParseSignatureInput(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Input     string
+		Signature string
+		KeyID     string
+		Headers   string
+		Err       bool
+	}{
+		{
+			"simple",
+			`sig1=("@method" "@target-uri");created=1700000000;keyid="https://remote.example/key";alg="rsa-v1_5-sha256"`,
+			`sig1=:AQID:`,
+			"https://remote.example/key",
+			"@method @target-uri",
+			false,
+		},
+		{
+			"keyid with a comma and a semicolon",
+			`sig1=("date");keyid="https://remote.example/a,b;c"`,
+			`sig1=:AQID:`,
+			"https://remote.example/a,b;c",
+			"date",
+			false,
+		},
+		{
+			"the second label has the value",
+			`sig1=("date");keyid="one", sig2=("@method");keyid="two"`,
+			`sig2=:AQID:`,
+			"two",
+			"@method",
+			false,
+		},
+		{"no matching value", `sig1=("date");keyid="one"`, `sig2=:AQID:`, "", "", true},
+		{"missing keyid", `sig1=("date");created=1700000000`, `sig1=:AQID:`, "", "", true},
+		{"not an inner list", `sig1="date";keyid="one"`, `sig1=:AQID:`, "", "", true},
+		{"not a byte sequence", `sig1=("date");keyid="one"`, `sig1=AQID`, "", "", true},
+		{"malformed created", `sig1=("date");keyid="one";created=yesterday`, `sig1=:AQID:`, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			p, err := parseSignatureInput(tt.Input, tt.Signature)
+			if (err != nil) != tt.Err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if p.KeyID != tt.KeyID || strings.Join(p.Headers, " ") != tt.Headers || string(p.Signature) != "\x01\x02\x03" {
+				t.Errorf("unexpected params: %+v", p)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signWithTimes signs the request in draft-cavage with the created and expires parameters, which signRequest never sends.
+// Zero times are omitted.
+func signWithTimes(t *testing.T, r *http.Request, body []byte, headers []string, created, expires time.Time) {
+	t.Helper()
+
+	r.Host = r.URL.Host
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	sum := sha256.Sum256(body)
+	r.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+
+	p := &signatureParams{KeyID: testRemoteKeyID, Algorithm: "hs2019", Headers: headers, Created: created, Expires: expires}
+	signingString, err := buildSigningString(r, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256([]byte(signingString))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, testKey(t), crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := fmt.Sprintf(`keyId="%s",algorithm="hs2019",headers="%s"`, p.KeyID, strings.Join(headers, " "))
+	if !created.IsZero() {
+		header += fmt.Sprintf(",created=%d", created.Unix())
+	}
+	if !expires.IsZero() {
+		header += fmt.Sprintf(",expires=%d", expires.Unix())
+	}
+	r.Header.Set("Signature", header+`,signature="`+base64.StdEncoding.EncodeToString(sig)+`"`)
+}
+
+func TestVerifyRequest_createdAndExpires(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"type":"Create"}`)
+
+	tests := []struct {
+		Name    string
+		Headers []string
+		Created time.Time
+		Expires time.Time
+		Code    SignatureErrorCode
+	}{
+		{"created and expires", []string{"(request-target)", "host", "(created)", "(expires)", "digest"}, now, now.Add(time.Hour), ""},
+		{"created only", []string{"(request-target)", "host", "(created)", "digest"}, now, time.Time{}, ""},
+		{"expired", []string{"(request-target)", "host", "(created)", "(expires)", "digest"}, now.Add(-2 * time.Hour), now.Add(-time.Hour), StaleDate},
+		{"created in the future", []string{"(request-target)", "host", "(created)", "digest"}, now.Add(time.Hour), time.Time{}, StaleDate},
+		{"created too long ago", []string{"(request-target)", "host", "(created)", "digest"}, now.Add(-maxSignatureAge - time.Minute), time.Time{}, StaleDate},
+		{"old created with fresh date", []string{"(request-target)", "host", "date", "(created)", "digest"}, now.Add(-maxSignatureAge - time.Minute), time.Time{}, ""},
+		{"neither date nor created", []string{"(request-target)", "host", "digest"}, time.Time{}, time.Time{}, StaleDate},
+	}
+
+	h := newTestHandler(t)
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "https://local.example/inbox", strings.NewReader(string(body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			signWithTimes(t, req, body, tt.Headers, tt.Created, tt.Expires)
+
+			_, err = h.verifyRequestWith(req, body, testLookup(t))
+			if tt.Code == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if code := signatureErrorCode(err); err == nil || code != tt.Code {
+				t.Fatalf("expected %s but got %v (%s)", tt.Code, err, code)
+			}
+		})
+	}
+}
+
+func TestVerifyRequest_digestRequired(t *testing.T) {
+	h := newTestHandler(t)
+	body := `{"type":"Create"}`
+
+	t.Run("cavage without digest", func(t *testing.T) {
+		req := newSignedPost(t, testRemoteKeyID, "https://local.example/inbox", body, []string{"(request-target)", "host", "date"})
+		_, err := h.verifyRequestWith(req, []byte(body), testLookup(t))
+		if code := signatureErrorCode(err); err == nil || code != SignedHeaderMissing {
+			t.Fatalf("expected %s but got %v (%s)", SignedHeaderMissing, err, code)
+		}
+	})
+
+	t.Run("cavage with swapped body", func(t *testing.T) {
+		req := newSignedPost(t, testRemoteKeyID, "https://local.example/inbox", body, nil)
+		_, err := h.verifyRequestWith(req, []byte(`{"type":"Delete"}`), testLookup(t))
+		if code := signatureErrorCode(err); err == nil || code != DigestMismatch {
+			t.Fatalf("expected %s but got %v (%s)", DigestMismatch, err, code)
+		}
+	})
+
+	t.Run("rfc9421 without content-digest", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "https://local.example/inbox", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := signRequestRFC9421(req, testRemoteKeyID, testKey(t), []byte(body), []string{"(request-target)", "host", "date"}); err != nil {
+			t.Fatal(err)
+		}
+		_, err = h.verifyRequestWith(req, []byte(body), testLookup(t))
+		if code := signatureErrorCode(err); err == nil || code != SignedHeaderMissing {
+			t.Fatalf("expected %s but got %v (%s)", SignedHeaderMissing, err, code)
+		}
+	})
+
+	t.Run("GET without digest", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "https://local.example/@alice", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := signRequest(req, testRemoteKeyID, testKey(t), nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h.verifyRequestWith(req, nil, testLookup(t)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+}
+
+func TestVerifyRequest_roundTrip(t *testing.T) {
+	h := newTestHandler(t)
+	body := `{"type":"Create"}`
+
+	sign := map[string]func(*http.Request) error{
+		SignatureFormatCavage: func(r *http.Request) error {
+			return signRequest(r, testRemoteKeyID, testKey(t), []byte(body), nil)
+		},
+		SignatureFormatRFC9421: func(r *http.Request) error {
+			return signRequestRFC9421(r, testRemoteKeyID, testKey(t), []byte(body), nil)
+		},
+	}
+
+	for format, sign := range sign {
+		t.Run(format, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "https://local.example/@alice/inbox", strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sign(req); err != nil {
+				t.Fatal(err)
+			}
+
+			key, err := h.verifyRequestWith(req, []byte(body), testLookup(t))
+			if err != nil {
+				t.Fatalf("failed to verify: %s", err)
+			}
+			if key.ID != testRemoteKeyID || key.Owner != testRemoteActor {
+				t.Errorf("unexpected key: %+v", key)
+			}
+
+			req.Host = "other.example"
+			if _, err := h.verifyRequestWith(req, []byte(body), testLookup(t)); signatureErrorCode(err) != SignatureInvalid {
+				t.Errorf("expected %s for another host but got %v", SignatureInvalid, err)
+			}
+		})
+	}
+}
+
+func TestParseSignedHeaders(t *testing.T) {
+	tests := []struct {
+		Input string
+		Want  []string
+		OK    bool
+	}{
+		{"(request-target) host date digest", []string{"(request-target)", "host", "date", "digest"}, true},
+		{"(Request-Target),Host, Date,Digest,Content-Type", []string{"(request-target)", "host", "date", "digest", "content-type"}, true},
+		{"(request-target) date", []string{"(request-target)", "date"}, true},
+		{"host date digest", nil, false},
+		{"(request-target) host digest", nil, false},
+		{"", nil, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSignedHeaders(tt.Input)
+		if (err == nil) != tt.OK {
+			t.Errorf("%q: unexpected error: %v", tt.Input, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.Want) {
+			t.Errorf("%q: expected %q but got %q", tt.Input, tt.Want, got)
+		}
+	}
+}
+
+func TestSignRequest_signedHeaders(t *testing.T) {
+	h := newTestHandler(t)
+	body := `{"type":"Create"}`
+
+	tests := []struct {
+		Method  string
+		Signed  []string
+		Covered string
+	}{
+		{"POST", nil, "(request-target) host date digest"},
+		{"POST", []string{"(request-target)", "host", "date", "digest", "content-type"}, "(request-target) host date digest content-type"},
+		{"GET", nil, "(request-target) host date"},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.Method, "https://local.example/@alice/inbox", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/activity+json")
+		if err := signRequest(req, testRemoteKeyID, testKey(t), []byte(body), tt.Signed); err != nil {
+			t.Fatal(err)
+		}
+
+		params, err := parseSignatureHeader(req.Header.Get("Signature"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if covered := strings.Join(params.Headers, " "); covered != tt.Covered {
+			t.Errorf("%s %q: expected to cover %q but got %q", tt.Method, tt.Signed, tt.Covered, covered)
+		}
+
+		if tt.Method == "GET" {
+			continue
+		}
+		if _, err := h.verifyRequestWith(req, []byte(body), testLookup(t)); err != nil {
+			t.Errorf("%s %q: failed to verify: %s", tt.Method, tt.Signed, err)
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		if _, err := h.verifyRequestWith(req, []byte(body), testLookup(t)); (err == nil) == strings.Contains(tt.Covered, "content-type") {
+			t.Errorf("%s %q: unexpected result after changing Content-Type: %v", tt.Method, tt.Signed, err)
+		}
+	}
+}
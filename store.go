@@ -0,0 +1,824 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReceivedNote is a Note or Question delivered from a remote server.
+type ReceivedNote struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Actor      string    `json:"actor"`
+	Content    string    `json:"content"`
+	Summary    string    `json:"summary,omitempty"`
+	Sensitive  bool      `json:"sensitive"`
+	Published  string    `json:"published,omitempty"`
+	ReceivedAt time.Time `json:"receivedAt"`
+
+	// Public is true if the note was addressed to the public collection.
+	Public bool `json:"public"`
+
+	// Options are the choices of a Question with their vote counts.
+	Options []PollOption `json:"options,omitempty"`
+
+	// Attachments are the media attached to the note.
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// Tags are the mentions, hashtags and custom emojis of the note.
+	Tags []Tag `json:"tags,omitempty"`
+
+	// Context is the id of the conversation that the note belongs to, from either context or the OStatus conversation.
+	Context string `json:"context,omitempty"`
+}
+
+// Attachment is a media descriptor of a received note.
+type Attachment struct {
+	Type      string `json:"type,omitempty"`
+	MediaType string `json:"mediaType,omitempty"`
+	URL       string `json:"url"`
+
+	// Name is the alt text.
+	Name string `json:"name,omitempty"`
+}
+
+// receivedAttachments reads the attachment field, which may be missing, a single object, or an array.
+// Entries without a URL are skipped.
+func receivedAttachments(object map[string]any) []Attachment {
+	var xs []any
+	switch x := object["attachment"].(type) {
+	case []any:
+		xs = x
+	case map[string]any:
+		xs = []any{x}
+	}
+
+	var attachments []Attachment
+	for _, x := range xs {
+		o, ok := x.(map[string]any)
+		if !ok {
+			continue
+		}
+		a := Attachment{
+			Type: typeOf(o),
+			URL:  linkHref(o["url"]),
+		}
+		a.MediaType, _ = o["mediaType"].(string)
+		a.Name, _ = o["name"].(string)
+		if a.URL != "" {
+			attachments = append(attachments, a)
+		}
+	}
+	return attachments
+}
+
+// Tag is an entry of the tag field of a received note, such as Mention, Hashtag, or Emoji.
+type Tag struct {
+	Type string `json:"type,omitempty"`
+	Name string `json:"name,omitempty"`
+
+	// Href is the link of a Mention or a Hashtag, or the image of an Emoji.
+	Href string `json:"href,omitempty"`
+}
+
+// receivedTags reads the tag field, which may be missing, a single object, or an array.
+// Entries with neither a name nor a link are skipped.
+func receivedTags(object map[string]any) []Tag {
+	var xs []any
+	switch x := object["tag"].(type) {
+	case []any:
+		xs = x
+	case map[string]any:
+		xs = []any{x}
+	}
+
+	var tags []Tag
+	for _, x := range xs {
+		o, ok := x.(map[string]any)
+		if !ok {
+			continue
+		}
+		t := Tag{
+			Type: typeOf(o),
+			Href: linkHref(o["href"]),
+		}
+		t.Name, _ = o["name"].(string)
+		if icon, ok := o["icon"].(map[string]any); ok && t.Href == "" {
+			t.Href = linkHref(icon["url"])
+		}
+		if t.Name != "" || t.Href != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// linkHref returns the URL of a url field, which is either a string, a Link object, or an array of them.
+func linkHref(v any) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case map[string]any:
+		href, _ := x["href"].(string)
+		return href
+	case []any:
+		for _, y := range x {
+			if href := linkHref(y); href != "" {
+				return href
+			}
+		}
+	}
+	return ""
+}
+
+// receivedPollOptions reads the oneOf or anyOf options of a Question.
+func receivedPollOptions(object map[string]any) []PollOption {
+	xs, ok := object["oneOf"].([]any)
+	if !ok {
+		xs, _ = object["anyOf"].([]any)
+	}
+
+	var options []PollOption
+	for _, x := range xs {
+		o, ok := x.(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _ := o["name"].(string)
+		option := PollOption{Name: name}
+		if replies, ok := o["replies"].(map[string]any); ok {
+			n, _ := replies["totalItems"].(float64)
+			option.Votes = int(n)
+		}
+		options = append(options, option)
+	}
+	return options
+}
+
+// DefaultReceivedLimit is the number of received items of each type that are kept, when the limit of the type is not configured.
+// Beyond the limit, the least recently used item is evicted: reading an item through the store counts as using it, as well as receiving it.
+const DefaultReceivedLimit = 10000
+
+// ReceivedTypes are the types of the received items that can be limited by RECEIVED_LIMITS.
+// Note also limits Questions and the direct messages of each user.
+var ReceivedTypes = []string{"Note", "Like", "Announce", "EmojiReact"}
+
+// receivedLimit returns the limit, or DefaultReceivedLimit if it is not positive.
+func receivedLimit(limit int) int {
+	if limit > 0 {
+		return limit
+	}
+	return DefaultReceivedLimit
+}
+
+// parseReceivedLimits parses RECEIVED_LIMITS, a comma separated list of the limits per type such as "Note=1000,Like=500".
+func parseReceivedLimits(s string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, x := range strings.Split(s, ",") {
+		if x = strings.TrimSpace(x); x == "" {
+			continue
+		}
+		typ, value, _ := strings.Cut(x, "=")
+		known := false
+		for _, t := range ReceivedTypes {
+			known = known || t == typ
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown type %q; it must be one of %s", typ, strings.Join(ReceivedTypes, ", "))
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("limit of %s must be a positive integer: %q", typ, value)
+		}
+		limits[typ] = n
+	}
+	return limits, nil
+}
+
+// receivedItems keeps received items in the order received, and evicts the least recently used ones beyond a limit.
+// The zero value is ready to use. The caller must lock it.
+type receivedItems[T any] struct {
+	items []*receivedItem[T]
+
+	// recent is the items from the least recently used.
+	recent list.List
+}
+
+type receivedItem[T any] struct {
+	value T
+	elem  *list.Element
+}
+
+// add appends the value, and evicts the least recently used items beyond the limit.
+func (r *receivedItems[T]) add(v T, limit int) {
+	item := &receivedItem[T]{value: v}
+	item.elem = r.recent.PushBack(item)
+	r.items = append(r.items, item)
+
+	for r.recent.Len() > limit {
+		r.remove(r.recent.Remove(r.recent.Front()).(*receivedItem[T]))
+	}
+}
+
+// remove deletes the item from items. Without reads it is the earliest one, which is removed without copying.
+func (r *receivedItems[T]) remove(item *receivedItem[T]) {
+	for i, x := range r.items {
+		if x != item {
+			continue
+		}
+		if i == 0 {
+			r.items[0] = nil
+			r.items = r.items[1:]
+		} else {
+			copy(r.items[i:], r.items[i+1:])
+			r.items[len(r.items)-1] = nil
+			r.items = r.items[:len(r.items)-1]
+		}
+		return
+	}
+}
+
+// use marks the item as the most recently used.
+func (r *receivedItems[T]) use(item *receivedItem[T]) {
+	r.recent.MoveToBack(item.elem)
+}
+
+// list returns a copy of the values in the order received, and marks all of them used.
+func (r *receivedItems[T]) list() []T {
+	xs := make([]T, 0, len(r.items))
+	for _, item := range r.items {
+		r.use(item)
+		xs = append(xs, item.value)
+	}
+	return xs
+}
+
+// NoteStore keeps received notes in memory.
+//
+// Like the other stores, it is safe for concurrent use and its zero value is ready to use.
+// The slices returned by List are copies that the caller may keep.
+type NoteStore struct {
+	sync.Mutex
+	notes receivedItems[ReceivedNote]
+
+	// Limit is the number of notes to keep. The least recently used ones are evicted beyond it. DefaultReceivedLimit is used if zero.
+	Limit int
+}
+
+func (s *NoteStore) Add(note ReceivedNote) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.notes.add(note, receivedLimit(s.Limit))
+}
+
+// List returns a copy of the stored notes, oldest first. All of them count as used.
+func (s *NoteStore) List() []ReceivedNote {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.notes.list()
+}
+
+// Timeline returns up to limit public notes, newest first. The returned ones count as used.
+func (s *NoteStore) Timeline(limit int) []ReceivedNote {
+	s.Lock()
+	defer s.Unlock()
+
+	notes := []ReceivedNote{}
+	for i := len(s.notes.items) - 1; i >= 0 && len(notes) < limit; i-- {
+		if item := s.notes.items[i]; item.value.Public {
+			s.notes.use(item)
+			notes = append(notes, item.value)
+		}
+	}
+	return notes
+}
+
+// DirectMessageStore keeps received direct messages per local recipient. It is safe for concurrent use.
+type DirectMessageStore struct {
+	sync.Mutex
+	messages map[string]*receivedItems[ReceivedNote]
+
+	// Limit is the number of messages to keep for each user. The least recently used ones are evicted beyond it. DefaultReceivedLimit is used if zero.
+	Limit int
+}
+
+func (s *DirectMessageStore) Add(username string, note ReceivedNote) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.messages == nil {
+		s.messages = make(map[string]*receivedItems[ReceivedNote])
+	}
+	if s.messages[username] == nil {
+		s.messages[username] = &receivedItems[ReceivedNote]{}
+	}
+	s.messages[username].add(note, receivedLimit(s.Limit))
+}
+
+// List returns a copy of the direct messages to the user, oldest first. All of them count as used.
+func (s *DirectMessageStore) List(username string) []ReceivedNote {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.messages[username] == nil {
+		return []ReceivedNote{}
+	}
+	return s.messages[username].list()
+}
+
+// FollowStore keeps actor ids that follow, or are followed by, each local user. It is safe for concurrent use.
+type FollowStore struct {
+	sync.RWMutex
+	actors map[string][]string
+}
+
+// Add appends the actor to the user's list unless it is already there.
+func (s *FollowStore) Add(username, actor string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.actors == nil {
+		s.actors = make(map[string][]string)
+	}
+	for _, a := range s.actors[username] {
+		if a == actor {
+			return
+		}
+	}
+	s.actors[username] = append(s.actors[username], actor)
+}
+
+func (s *FollowStore) Remove(username, actor string) {
+	s.Lock()
+	defer s.Unlock()
+
+	xs := s.actors[username]
+	for i, a := range xs {
+		if a == actor {
+			s.actors[username] = append(xs[:i:i], xs[i+1:]...)
+			return
+		}
+	}
+}
+
+// List returns a copy of the user's list in the order they were added.
+func (s *FollowStore) List(username string) []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	return append([]string{}, s.actors[username]...)
+}
+
+// Actors returns every actor in the lists of any user, sorted and without duplicates.
+func (s *FollowStore) Actors() []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	seen := make(map[string]bool)
+	var actors []string
+	for _, xs := range s.actors {
+		for _, a := range xs {
+			if !seen[a] {
+				seen[a] = true
+				actors = append(actors, a)
+			}
+		}
+	}
+	sort.Strings(actors)
+	return actors
+}
+
+// Usernames returns the local users whose list has the actor.
+func (s *FollowStore) Usernames(actor string) []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	var usernames []string
+	for username, actors := range s.actors {
+		for _, a := range actors {
+			if a == actor {
+				usernames = append(usernames, username)
+				break
+			}
+		}
+	}
+	return usernames
+}
+
+// PendingFollow is a follow request waiting for approval.
+type PendingFollow struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	Actor      string    `json:"actor"`
+	FollowID   string    `json:"followId"`
+	ReceivedAt time.Time `json:"receivedAt"`
+
+	Follow *Activity `json:"-"`
+}
+
+// PendingFollowStore keeps follow requests that are not answered yet. It is safe for concurrent use.
+// The stored activities must not be modified.
+//
+// The requests are kept in memory and lost on restart, unless Path is set.
+type PendingFollowStore struct {
+	sync.RWMutex
+	follows []PendingFollow
+	lastID  int64
+
+	// Path is the file where the requests are saved on every change, and restored from by Load.
+	Path string
+}
+
+// savedPendingFollows is the file of PendingFollowStore. The last id is kept so that the ids of answered requests are not reused.
+type savedPendingFollows struct {
+	LastID  int64                `json:"lastId"`
+	Follows []savedPendingFollow `json:"follows"`
+}
+
+// savedPendingFollow is PendingFollow with the follow activity, which PendingFollow does not marshal.
+type savedPendingFollow struct {
+	PendingFollow
+	Activity map[string]any `json:"activity"`
+}
+
+// Load restores the requests saved in Path. It does nothing if Path is empty or the file does not exist yet.
+func (s *PendingFollowStore) Load() error {
+	if s.Path == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var saved savedPendingFollows
+	if err := json.Unmarshal(raw, &saved); err != nil {
+		return fmt.Errorf("%s: %w", s.Path, err)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.follows = nil
+	s.lastID = saved.LastID
+	for _, x := range saved.Follows {
+		activity, err := json.Marshal(x.Activity)
+		if err != nil {
+			return err
+		}
+		var follow Activity
+		if err := json.Unmarshal(activity, &follow); err != nil {
+			return fmt.Errorf("%s: follow %s: %w", s.Path, x.ID, err)
+		}
+		x.Follow = &follow
+		s.follows = append(s.follows, x.PendingFollow)
+	}
+	return nil
+}
+
+// save writes the requests to Path, if it is set. The caller must hold the lock.
+// Failures are only logged, so that the requests are still answered from memory.
+func (s *PendingFollowStore) save() {
+	if s.Path == "" {
+		return
+	}
+
+	saved := savedPendingFollows{LastID: s.lastID, Follows: make([]savedPendingFollow, len(s.follows))}
+	for i, p := range s.follows {
+		saved.Follows[i] = savedPendingFollow{PendingFollow: p, Activity: p.Follow.Raw}
+	}
+	raw, err := json.Marshal(saved)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.Path), 0700)
+	}
+	if err == nil {
+		err = replaceFileAtomic(s.Path, raw, 0600)
+	}
+	if err != nil {
+		log.Printf("failed to save the pending follow requests to %s: %s", s.Path, err)
+	}
+}
+
+// Add stores the follow request to the user with a new id.
+func (s *PendingFollowStore) Add(username string, follow *Activity, receivedAt time.Time) PendingFollow {
+	s.Lock()
+	defer s.Unlock()
+
+	s.lastID++
+	p := PendingFollow{
+		ID:         strconv.FormatInt(s.lastID, 10),
+		Username:   username,
+		Actor:      follow.Actor,
+		FollowID:   follow.ID,
+		ReceivedAt: receivedAt,
+		Follow:     follow,
+	}
+	s.follows = append(s.follows, p)
+	s.save()
+	return p
+}
+
+// List returns a copy of the pending follow requests to every user, oldest first.
+func (s *PendingFollowStore) List() []PendingFollow {
+	s.RLock()
+	defer s.RUnlock()
+
+	return append([]PendingFollow{}, s.follows...)
+}
+
+// Restore puts back a request that was taken, keeping its id.
+func (s *PendingFollowStore) Restore(p PendingFollow) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.follows = append(s.follows, p)
+	s.save()
+}
+
+// Take removes the pending follow request and returns it.
+func (s *PendingFollowStore) Take(id string) (PendingFollow, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, p := range s.follows {
+		if p.ID == id {
+			s.follows = append(s.follows[:i:i], s.follows[i+1:]...)
+			s.save()
+			return p, true
+		}
+	}
+	return PendingFollow{}, false
+}
+
+// OutgoingFollow is a Follow sent by a local user that is not answered yet.
+type OutgoingFollow struct {
+	Username string    `json:"username"`
+	Actor    string    `json:"actor"`
+	FollowID string    `json:"followId"`
+	SentAt   time.Time `json:"sentAt"`
+}
+
+// OutgoingFollowStore keeps the Follows sent by local users until they are accepted or rejected. It is safe for concurrent use.
+type OutgoingFollowStore struct {
+	sync.RWMutex
+	follows []OutgoingFollow
+}
+
+func (s *OutgoingFollowStore) Add(f OutgoingFollow) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.follows = append(s.follows, f)
+}
+
+// List returns a copy of the unanswered follows of every user, oldest first.
+func (s *OutgoingFollowStore) List() []OutgoingFollow {
+	s.RLock()
+	defer s.RUnlock()
+
+	return append([]OutgoingFollow{}, s.follows...)
+}
+
+// Take removes the follow sent to the actor with the activity id, and returns it.
+func (s *OutgoingFollowStore) Take(followID, actor string) (OutgoingFollow, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, f := range s.follows {
+		if f.FollowID == followID && f.Actor == actor {
+			s.follows = append(s.follows[:i:i], s.follows[i+1:]...)
+			return f, true
+		}
+	}
+	return OutgoingFollow{}, false
+}
+
+// ActorCache keeps the remote actor documents fetched for delivery. It is safe for concurrent use.
+type ActorCache struct {
+	sync.RWMutex
+	actors map[string]cachedActor
+}
+
+type cachedActor struct {
+	actor     *remoteActor
+	fetchedAt time.Time
+}
+
+// Get returns the actor if it was fetched within ttl.
+func (s *ActorCache) Get(id string, ttl time.Duration, now time.Time) (*remoteActor, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	c, ok := s.actors[id]
+	if !ok || now.Sub(c.fetchedAt) > ttl {
+		return nil, false
+	}
+	return c.actor, true
+}
+
+func (s *ActorCache) Put(id string, actor *remoteActor, now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.actors == nil {
+		s.actors = make(map[string]cachedActor)
+	}
+	s.actors[id] = cachedActor{actor: actor, fetchedAt: now}
+}
+
+// Reaction is a Like, Announce or EmojiReact received via the inbox.
+type Reaction struct {
+	Type       string    `json:"type"`
+	ID         string    `json:"id"`
+	Actor      string    `json:"actor"`
+	Object     string    `json:"object"`
+	ReceivedAt time.Time `json:"receivedAt"`
+
+	// Content is the emoji of an EmojiReact, either a unicode emoji or a shortcode such as ":blobcat:".
+	// Emoji is the custom emoji that the shortcode refers to, or nil for unicode emojis.
+	Content string `json:"content,omitempty"`
+	Emoji   *Tag   `json:"emoji,omitempty"`
+
+	// Embedded is the object if the activity embedded it instead of referencing it by IRI.
+	Embedded map[string]any `json:"embedded,omitempty"`
+}
+
+// ReactionStore keeps reactions by the object id. It is safe for concurrent use.
+type ReactionStore struct {
+	sync.Mutex
+	reactions map[string][]*storedReaction
+
+	// recent is the reactions of each type from the least recently used, to evict them.
+	recent map[string]*list.List
+
+	// Limits are the number of reactions to keep for each type such as "Like". The least recently used ones are evicted beyond it.
+	// DefaultReceivedLimit is used for the types without a limit. It must not be modified after the store is used.
+	Limits map[string]int
+}
+
+type storedReaction struct {
+	Reaction
+	elem *list.Element
+}
+
+// Add stores the reaction unless the actor has already reacted to the object with the same type and the same emoji.
+func (s *ReactionStore) Add(r Reaction) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.reactions == nil {
+		s.reactions = make(map[string][]*storedReaction)
+		s.recent = make(map[string]*list.List)
+	}
+	for _, x := range s.reactions[r.Object] {
+		if x.sameAs(r) {
+			return
+		}
+	}
+	if s.recent[r.Type] == nil {
+		s.recent[r.Type] = list.New()
+	}
+	recent := s.recent[r.Type]
+
+	stored := &storedReaction{Reaction: r}
+	stored.elem = recent.PushBack(stored)
+	s.reactions[r.Object] = append(s.reactions[r.Object], stored)
+
+	if recent.Len() > receivedLimit(s.Limits[r.Type]) {
+		s.remove(recent.Remove(recent.Front()).(*storedReaction))
+	}
+}
+
+// sameAs reports whether the reactions are by the same actor to the same object, with the same type and the same emoji.
+func (r Reaction) sameAs(x Reaction) bool {
+	return r.Type == x.Type && r.Actor == x.Actor && r.Object == x.Object && r.Content == x.Content
+}
+
+// remove deletes the reaction from the reactions to its object. The caller must hold the lock.
+func (s *ReactionStore) remove(r *storedReaction) {
+	xs := s.reactions[r.Object]
+	for i, x := range xs {
+		if x == r {
+			xs = append(xs[:i:i], xs[i+1:]...)
+			break
+		}
+	}
+	if len(xs) == 0 {
+		delete(s.reactions, r.Object)
+	} else {
+		s.reactions[r.Object] = xs
+	}
+}
+
+// use marks the reaction as the most recently used. The caller must hold the lock.
+func (s *ReactionStore) use(r *storedReaction) {
+	s.recent[r.Type].MoveToBack(r.elem)
+}
+
+// Count returns the number of reactions of the type to the object. The counted reactions count as used.
+func (s *ReactionStore) Count(object, typ string) int {
+	s.Lock()
+	defer s.Unlock()
+
+	n := 0
+	for _, r := range s.reactions[object] {
+		if r.Type == typ {
+			s.use(r)
+			n++
+		}
+	}
+	return n
+}
+
+// ReactionCounts is the number of each kind of reactions to an object.
+type ReactionCounts struct {
+	Likes     int            `json:"likes"`
+	Announces int            `json:"announces"`
+	Emojis    map[string]int `json:"emojis"`
+}
+
+// Counts returns the number of reactions to every object, with the EmojiReacts counted by the emoji.
+// All the reactions count as used.
+func (s *ReactionStore) Counts() map[string]ReactionCounts {
+	s.Lock()
+	defer s.Unlock()
+
+	counts := make(map[string]ReactionCounts, len(s.reactions))
+	for object, rs := range s.reactions {
+		c := ReactionCounts{Emojis: map[string]int{}}
+		for _, r := range rs {
+			s.use(r)
+			switch r.Type {
+			case "Like":
+				c.Likes++
+			case "Announce":
+				c.Announces++
+			case "EmojiReact":
+				c.Emojis[r.Content]++
+			}
+		}
+		counts[object] = c
+	}
+	return counts
+}
+
+// List returns a copy of the reactions to every object, grouped by the object id. All of them count as used.
+func (s *ReactionStore) List() map[string][]Reaction {
+	s.Lock()
+	defer s.Unlock()
+
+	xs := make(map[string][]Reaction, len(s.reactions))
+	for k, rs := range s.reactions {
+		for _, r := range rs {
+			s.use(r)
+			xs[k] = append(xs[k], r.Reaction)
+		}
+	}
+	return xs
+}
+
+// ActivityStore keeps activities sent by local users, so that their ids can be dereferenced.
+// It is safe for concurrent use. The stored activities must not be modified after Add.
+type ActivityStore struct {
+	sync.RWMutex
+	activities map[string]map[string]any
+	lastID     int64
+}
+
+// Add assigns a new unique id to the activity and stores it. The id of the activity is set to idBase + "/" + id
+// before the activity becomes visible to Get, and id is returned.
+func (s *ActivityStore) Add(username string, activity map[string]any, idBase string) string {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.activities == nil {
+		s.activities = make(map[string]map[string]any)
+	}
+	s.lastID++
+	id := strconv.FormatInt(s.lastID, 10)
+	activity["id"] = idBase + "/" + id
+	s.activities[username+"/"+id] = activity
+	return id
+}
+
+func (s *ActivityStore) Get(username, id string) (map[string]any, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	activity, ok := s.activities[username+"/"+id]
+	return activity, ok
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseReceivedLimits(t *testing.T) {
+	limits, err := parseReceivedLimits(" Note=1000, Like=500 ,")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(limits) != 2 || limits["Note"] != 1000 || limits["Like"] != 500 {
+		t.Errorf("unexpected limits: %v", limits)
+	}
+
+	for _, s := range []string{"Follow=10", "Note=0", "Note=x", "Note"} {
+		if _, err := parseReceivedLimits(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestNoteStore_limit(t *testing.T) {
+	s := NoteStore{Limit: 3}
+	for i := 0; i < 5; i++ {
+		s.Add(ReceivedNote{ID: fmt.Sprintf("note%d", i), Public: i == 2})
+	}
+	if got := noteIDs(s.List()); got != "[note2 note3 note4]" {
+		t.Fatalf("unexpected notes: %s", got)
+	}
+
+	// Reading note2 through the timeline keeps it, and the least recently used note3 is evicted instead.
+	if got := noteIDs(s.Timeline(10)); got != "[note2]" {
+		t.Fatalf("unexpected timeline: %s", got)
+	}
+	s.Add(ReceivedNote{ID: "note5"})
+	if got := noteIDs(s.List()); got != "[note2 note4 note5]" {
+		t.Errorf("unexpected notes after reading note2: %s", got)
+	}
+}
+
+func noteIDs(notes []ReceivedNote) string {
+	ids := make([]string, len(notes))
+	for i, n := range notes {
+		ids[i] = n.ID
+	}
+	return fmt.Sprint(ids)
+}
+
+func TestDirectMessageStore_limit(t *testing.T) {
+	s := DirectMessageStore{Limit: 2}
+	for i := 0; i < 3; i++ {
+		s.Add("alice", ReceivedNote{ID: fmt.Sprintf("dm%d", i)})
+	}
+	s.Add("bob", ReceivedNote{ID: "dm-bob"})
+
+	if got := noteIDs(s.List("alice")); got != "[dm1 dm2]" {
+		t.Errorf("unexpected messages to alice: %s", got)
+	}
+	if xs := s.List("bob"); len(xs) != 1 {
+		t.Errorf("messages to bob are evicted by the ones to alice: %v", xs)
+	}
+	if xs := s.List("carol"); xs == nil || len(xs) != 0 {
+		t.Errorf("unexpected messages to carol: %#v", xs)
+	}
+}
+
+func TestReactionStore_limit(t *testing.T) {
+	s := ReactionStore{Limits: map[string]int{"Like": 2}}
+	s.Add(Reaction{Type: "Like", Actor: "a", Object: "note1"})
+	s.Add(Reaction{Type: "Announce", Actor: "a", Object: "note1"})
+	s.Add(Reaction{Type: "Like", Actor: "b", Object: "note1"})
+	s.Add(Reaction{Type: "Like", Actor: "a", Object: "note1"}) // duplicate, ignored
+	s.Add(Reaction{Type: "Like", Actor: "c", Object: "note2"})
+
+	if n := s.Count("note1", "Like"); n != 1 {
+		t.Errorf("expected 1 like of note1 but got %d", n)
+	}
+	if n := s.Count("note1", "Announce"); n != 1 {
+		t.Errorf("announces are evicted by likes: %d", n)
+	}
+	if n := s.Count("note2", "Like"); n != 1 {
+		t.Errorf("expected 1 like of note2 but got %d", n)
+	}
+
+	counts := s.Counts()
+	if c := counts["note1"]; c.Likes != 1 || c.Announces != 1 {
+		t.Errorf("unexpected counts of note1: %+v", c)
+	}
+	if c := counts["note2"]; c.Likes != 1 {
+		t.Errorf("unexpected counts of note2: %+v", c)
+	}
+	for _, r := range s.List()["note1"] {
+		if r.Type == "Like" && r.Actor != "b" {
+			t.Errorf("the earliest like is not evicted: %+v", r)
+		}
+	}
+
+	// Counting the likes of note2 makes the like of note1 the least recently used one.
+	s.Count("note2", "Like")
+	s.Add(Reaction{Type: "Like", Actor: "d", Object: "note3"})
+	if _, ok := s.List()["note1"]; !ok {
+		t.Fatal("the announce of note1 is dropped")
+	}
+	if c := s.Counts()["note1"]; c.Likes != 0 || c.Announces != 1 {
+		t.Errorf("unexpected counts of note1 after evicting its likes: %+v", c)
+	}
+	if n := s.Count("note2", "Like"); n != 1 {
+		t.Errorf("the recently counted like of note2 is evicted: %d", n)
+	}
+}
+
+func TestStores_concurrent(t *testing.T) {
+	const workers, rounds = 8, 50
+
+	var (
+		messages  DirectMessageStore
+		follows   FollowStore
+		notes     NoteStore
+		pending   PendingFollowStore
+		outgoing  OutgoingFollowStore
+		reactions ReactionStore
+		activity  ActivityStore
+	)
+	posts, poll := newTestPoll(true)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < rounds; i++ {
+				actor := fmt.Sprintf("https://remote.example/users/%d-%d", w, i)
+
+				follows.Add("alice", actor)
+				follows.List("alice")
+				follows.Actors()
+				follows.Usernames(actor)
+				follows.Remove("alice", actor)
+
+				post := &Post{Username: "alice", Content: "hello"}
+				posts.Add(post)
+				posts.Get("alice", post.ID)
+				posts.List("alice")
+				posts.Vote(poll, actor, "yes", time.Now())
+				posts.pollSnapshot(poll)
+				posts.Delete("alice", post.ID, time.Now())
+				posts.History("alice")
+
+				notes.Add(ReceivedNote{ID: actor + "/note"})
+				notes.List()
+				notes.Timeline(10)
+
+				messages.Add("alice", ReceivedNote{ID: actor + "/dm"})
+				messages.List("alice")
+
+				p := pending.Add("alice", &Activity{ID: actor + "/follow", Actor: actor}, time.Now())
+				pending.List()
+				pending.Take(p.ID)
+
+				outgoing.Add(OutgoingFollow{Username: "alice", Actor: actor, FollowID: actor + "/follow"})
+				outgoing.List()
+				outgoing.Take(actor+"/follow", actor)
+
+				reactions.Add(Reaction{Type: "Like", Actor: actor, Object: "note1"})
+				reactions.Count("note1", "Like")
+				reactions.Counts()
+				reactions.List()
+
+				id := activity.Add("alice", map[string]any{"type": "Create"}, "https://local.example/@alice/activities")
+				activity.Get("alice", id)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if xs := follows.List("alice"); len(xs) != 0 {
+		t.Errorf("%d followers are left after removing all", len(xs))
+	}
+	if xs := pending.List(); len(xs) != 0 {
+		t.Errorf("%d pending follows are left after taking all", len(xs))
+	}
+	if xs := outgoing.List(); len(xs) != 0 {
+		t.Errorf("%d outgoing follows are left after taking all", len(xs))
+	}
+	if xs := posts.List("alice"); len(xs) != 1 {
+		t.Errorf("expected only the poll to be left but got %d posts", len(xs))
+	}
+	if n := reactions.Count("note1", "Like"); n != workers*rounds {
+		t.Errorf("expected %d likes but got %d", workers*rounds, n)
+	}
+	if n := posts.pollSnapshot(poll).Options[0].Votes; n != workers*rounds {
+		t.Errorf("expected %d votes but got %d", workers*rounds, n)
+	}
+}
+
+func TestPendingFollowStore_persist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local.example", "pending-follows.json")
+	s := PendingFollowStore{Path: path}
+	if err := s.Load(); err != nil {
+		t.Fatalf("failed to load a file that does not exist yet: %s", err)
+	}
+
+	for _, name := range []string{"carol", "dave", "erin"} {
+		var follow Activity
+		raw := fmt.Sprintf(`{"id":"https://remote.example/users/%s/follows/1","type":"Follow","actor":"https://remote.example/users/%s","object":"https://local.example/@alice"}`, name, name)
+		if err := json.Unmarshal([]byte(raw), &follow); err != nil {
+			t.Fatal(err)
+		}
+		s.Add("alice", &follow, time.Now())
+	}
+	taken, _ := s.Take("2")
+	s.Take("3")
+	s.Restore(taken)
+
+	restored := PendingFollowStore{Path: path}
+	if err := restored.Load(); err != nil {
+		t.Fatal(err)
+	}
+	follows := restored.List()
+	if len(follows) != 2 || follows[0].ID != "1" || follows[1].ID != "2" {
+		t.Fatalf("unexpected follows: %+v", follows)
+	}
+	for _, p := range follows {
+		if p.Follow == nil || p.Follow.ID != p.FollowID || p.Follow.Actor != p.Actor || p.Follow.Object.ID != "https://local.example/@alice" {
+			t.Errorf("the follow activity is not restored: %+v", p.Follow)
+		}
+	}
+
+	// The ids of the restored requests are not reused.
+	if p := restored.Add("alice", follows[0].Follow, time.Now()); p.ID != "4" {
+		t.Errorf("expected the next id to be 4 but got %s", p.ID)
+	}
+}
+
+func TestNewHandler_pendingFollows(t *testing.T) {
+	t.Setenv("KEYS_DIR", t.TempDir())
+	t.Setenv("FOLLOW_POLICY", "manual")
+
+	h, err := newHandler(HostConfig{Hostname: "local.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.PendingFollows.Add("alice", &Activity{ID: "https://remote.example/follows/1", Actor: "https://remote.example/users/carol"}, time.Now())
+
+	restarted, err := newHandler(HostConfig{Hostname: "local.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if follows := restarted.PendingFollows.List(); len(follows) != 1 || follows[0].Actor != "https://remote.example/users/carol" {
+		t.Errorf("the pending follows are not kept across restarts: %+v", follows)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// stopwatch measures the durations of consecutive steps, such as parsing and verifying an inbox activity.
+type stopwatch struct {
+	last  time.Time
+	steps []string
+}
+
+func newStopwatch() *stopwatch {
+	return &stopwatch{last: time.Now()}
+}
+
+// Lap records the duration since the previous lap as the named step.
+func (s *stopwatch) Lap(name string) {
+	now := time.Now()
+	s.steps = append(s.steps, fmt.Sprintf("%s=%s", name, now.Sub(s.last)))
+	s.last = now
+}
+
+// String returns the steps like "parse=1ms verify=200ms".
+func (s *stopwatch) String() string {
+	return strings.Join(s.steps, " ")
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// defaultAccountCreated is the creation time of accounts that do not configure it.
+var defaultAccountCreated = time.Date(2023, 8, 14, 20, 38, 0, 0, time.FixedZone("JST", 9*60*60))
+
+// User is a local account.
+type User struct {
+	Name string `json:"name"`
+
+	// Type is the actor type, one of ActorTypes. "Person" is used if empty.
+	Type string `json:"type"`
+
+	// Published is when the account was created. defaultAccountCreated is used if zero.
+	Published time.Time `json:"published"`
+
+	// AlsoKnownAs are the other actor ids of this account, which remote servers check before accepting a Move to it.
+	AlsoKnownAs []string `json:"alsoKnownAs"`
+
+	// HideNetwork hides the followers and following collections, like the "hide network" option of Mastodon.
+	// HideNetworkCount serves only totalItems, and HideNetworkForbidden responds 403. They are shown if empty.
+	HideNetwork string `json:"hideNetwork"`
+
+	// Links replace the url of the actor with an array of Link objects, such as the profile page in other media types.
+	// The url is the actor id as a string if empty, which is what Mastodon expects.
+	Links []ActorLink `json:"links"`
+
+	// HideCounts omits totalItems from the followers and following collections, while their pages are served as usual.
+	HideCounts bool `json:"hideCounts"`
+
+	// Emojis are the custom emojis used in the name or the summary, such as :sandbox: for {"shortcode": "sandbox"}.
+	// Hashtags are the featured hashtags of the account, without #. Both are emitted in the tag field of the actor,
+	// and Hashtags are also served as the featuredTags collection, which the actor advertises if FeaturedTags is set.
+	Emojis   []CustomEmoji `json:"emojis"`
+	Hashtags []string      `json:"hashtags"`
+
+	// Fields are the profile metadata rows, emitted as the PropertyValue attachments of the actor like Mastodon.
+	Fields []ProfileField `json:"fields"`
+
+	// Discoverable is emitted as the discoverable flag of the actor, which Mastodon uses to list the account in the directory and the search.
+	// It is omitted if nil.
+	Discoverable *bool `json:"discoverable"`
+
+	// Suspended models a suspended account: the actor is served as Handler.SuspendedActor says, and nothing is delivered from it.
+	Suspended bool `json:"suspended"`
+}
+
+// ProfileField is a row of the profile metadata. Value may contain HTML, such as a link with rel="me".
+type ProfileField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ActorLink is an entry of the url of a local actor.
+type ActorLink struct {
+	Href      string `json:"href"`
+	MediaType string `json:"mediaType"`
+}
+
+// CustomEmoji is a custom emoji of a local user.
+type CustomEmoji struct {
+	Shortcode string `json:"shortcode"`
+	URL       string `json:"url"`
+	MediaType string `json:"mediaType"`
+}
+
+// The values of User.HideNetwork.
+const (
+	HideNetworkCount     = "count"
+	HideNetworkForbidden = "forbidden"
+)
+
+// hideNetwork returns how the followers and following collections of the user are hidden, or an empty string if they are shown.
+func (h *Handler) hideNetwork(username string) string {
+	u, ok := h.lookupUser(username)
+	if !ok {
+		return ""
+	}
+	return u.HideNetwork
+}
+
+// The values of Handler.SuspendedActor.
+const (
+	SuspendedActorMinimal   = "minimal"
+	SuspendedActorForbidden = "forbidden"
+)
+
+// errUserSuspended is returned by deliver for the activities of suspended users.
+var errUserSuspended = errors.New("the user is suspended")
+
+// isSuspended reports whether the local user is suspended.
+func (h *Handler) isSuspended(username string) bool {
+	u, ok := h.lookupUser(username)
+	return ok && u.Suspended
+}
+
+func (h *Handler) suspendedActor() string {
+	if h.SuspendedActor == "" {
+		return SuspendedActorMinimal
+	}
+	return h.SuspendedActor
+}
+
+// minimalActor builds the actor of a suspended user like Mastodon does: the profile is blanked and the suspended flag is set,
+// while the key and the endpoints are kept so that remote servers can still verify and process its past activities.
+func (h *Handler) minimalActor(username string) (map[string]any, error) {
+	actor := h.userURL(username)
+	user, _ := h.lookupUser(username)
+
+	publicKey, err := h.Keys.PublicKeyPEM(username)
+	if err != nil {
+		return nil, err
+	}
+	h.KeyRotations.observe(username, publicKey, time.Now())
+
+	return map[string]any{
+		"@context": []any{
+			ActivityStreamsContext,
+			SecurityContext,
+			map[string]string{
+				"toot":      "http://joinmastodon.org/ns#",
+				"suspended": "toot:suspended",
+			},
+		},
+		"id":                actor,
+		"type":              user.actorType(),
+		"name":              "",
+		"preferredUsername": username,
+		"summary":           "",
+		"published":         user.published().Format(time.RFC3339),
+		"url":               actor,
+		"inbox":             actor + "/inbox",
+		"outbox":            actor + "/outbox",
+		"followers":         actor + "/followers",
+		"following":         actor + "/following",
+		"endpoints": map[string]string{
+			"sharedInbox": h.baseURL() + "/inbox",
+		},
+		"publicKey": map[string]string{
+			"id":           actor + "#main-key",
+			"owner":        actor,
+			"publicKeyPem": publicKey,
+		},
+		"suspended": true,
+	}, nil
+}
+
+// ActorTypes are the allowed values of User.Type, which are the actor types of ActivityStreams.
+var ActorTypes = []string{"Person", "Service", "Application", "Group", "Organization"}
+
+// validActorType reports whether t is one of ActorTypes.
+func validActorType(t string) bool {
+	for _, a := range ActorTypes {
+		if a == t {
+			return true
+		}
+	}
+	return false
+}
+
+// actorType returns the actor type of the user.
+func (u *User) actorType() string {
+	if u.Type == "" {
+		return "Person"
+	}
+	return u.Type
+}
+
+func (u *User) published() time.Time {
+	if u.Published.IsZero() {
+		return defaultAccountCreated
+	}
+	return u.Published
+}
+
+// lastStatusAt returns when the user posted last, or when the account was created if there are no posts.
+func (h *Handler) lastStatusAt(u *User) time.Time {
+	if t, ok := h.Posts.LastPublished(u.Name); ok {
+		return t
+	}
+	return u.published()
+}
+
+// knownUsers returns the configured users, or the users who have posts if any username is accepted.
+func (h *Handler) knownUsers() []*User {
+	if len(h.Users) > 0 {
+		return h.Users
+	}
+
+	var users []*User
+	for _, name := range h.Posts.Usernames() {
+		u, _ := h.lookupUser(name)
+		users = append(users, u)
+	}
+	return users
+}
+
+// lookupUser finds the local user, ignoring case. The Name of the returned user is the canonical casing.
+// If no users are configured, any username is accepted as a debug account, normalized to lowercase.
+func (h *Handler) lookupUser(username string) (*User, bool) {
+	if len(h.Users) == 0 {
+		return &User{Name: strings.ToLower(username)}, username != ""
+	}
+	for _, u := range h.Users {
+		if strings.EqualFold(u.Name, username) {
+			return u, true
+		}
+	}
+	return nil, false
+}
+
+// requireUser is a middleware that responds 404 unless the :username parameter is a local user.
+// The parameter is replaced with the canonical username, so that handlers do not need to care about the casing.
+func (h *Handler) requireUser(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		u, ok := h.lookupUser(c.Param("username"))
+		if !ok {
+			return c.JSON(404, map[string]string{
+				"error": "not found",
+			})
+		}
+
+		values := c.ParamValues()
+		for i, name := range c.ParamNames() {
+			if name == "username" && i < len(values) {
+				values[i] = u.Name
+			}
+		}
+		c.SetParamValues(values...)
+
+		return next(c)
+	}
+}
+
+// VirtualHosts dispatches requests to the echo instance of the host in the Host header.
+type VirtualHosts map[string]*echo.Echo
+
+func (v VirtualHosts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e, ok := v[r.Host]
+	if !ok {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(404)
+		w.Write([]byte(`{"error":"unknown host"}` + "\n"))
+		return
+	}
+	e.ServeHTTP(w, r)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	hosts := make(VirtualHosts)
+	for host, user := range map[string]string{"alpha.example": "alice", "beta.example": "bob"} {
+		h := newTestHandler(t, user)
+		h.Hostname = host
+		e := echo.New()
+		h.RegisterRoutes(e)
+		hosts[host] = e
+	}
+
+	get := func(host, path string) (int, map[string]any) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Host = host
+		req.Header.Set("Accept", "application/activity+json")
+		rec := httptest.NewRecorder()
+		hosts.ServeHTTP(rec, req)
+
+		var doc map[string]any
+		json.Unmarshal(rec.Body.Bytes(), &doc)
+		return rec.Code, doc
+	}
+
+	tests := []struct {
+		Host string
+		Path string
+		Code int
+		ID   string
+	}{
+		{"alpha.example", "/@alice", 200, "https://alpha.example/@alice"},
+		{"beta.example", "/@bob", 200, "https://beta.example/@bob"},
+		{"beta.example", "/@bob/outbox", 200, "https://beta.example/@bob/outbox"},
+		{"alpha.example", "/@bob", 404, ""},
+		{"beta.example", "/@alice/followers", 404, ""},
+		{"gamma.example", "/@alice", 404, ""},
+	}
+	for _, tt := range tests {
+		code, doc := get(tt.Host, tt.Path)
+		if code != tt.Code {
+			t.Errorf("%s%s: expected %d but got %d", tt.Host, tt.Path, tt.Code, code)
+			continue
+		}
+		if tt.ID != "" && doc["id"] != tt.ID {
+			t.Errorf("%s%s: unexpected id: %v", tt.Host, tt.Path, doc["id"])
+		}
+	}
+
+	code, doc := get("beta.example", "/.well-known/webfinger?resource=acct:bob@beta.example")
+	if code != 200 {
+		t.Fatalf("expected 200 for webfinger but got %d", code)
+	}
+	if doc["subject"] != "acct:bob@beta.example" {
+		t.Errorf("unexpected subject: %v", doc["subject"])
+	}
+	if code, _ := get("beta.example", "/.well-known/webfinger?resource=acct:alice@alpha.example"); code != 404 {
+		t.Errorf("expected 404 for a user of another host but got %d", code)
+	}
+}
+
+func TestLastStatusAt(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	h := newTestHandler(t)
+	h.Users = []*User{{Name: "alice", Published: created}, {Name: "bob"}}
+
+	if got := h.lastStatusAt(h.Users[0]); !got.Equal(created) {
+		t.Errorf("expected the creation time without posts but got %s", got)
+	}
+	if got := h.lastStatusAt(h.Users[1]); !got.Equal(defaultAccountCreated) {
+		t.Errorf("expected the default creation time but got %s", got)
+	}
+
+	posted := time.Now().Add(-time.Hour).Truncate(time.Second)
+	h.Posts.Add(&Post{Username: "alice", Content: "old", Published: posted.Add(-time.Hour)})
+	h.Posts.Add(&Post{Username: "alice", Content: "new", Published: posted})
+	if got := h.lastStatusAt(h.Users[0]); !got.Equal(posted) {
+		t.Errorf("expected the time of the last post but got %s", got)
+	}
+
+	req := httptest.NewRequest("GET", "/nodeinfo/2.1", nil)
+	rec := serve(h, req)
+	var doc struct {
+		Usage struct {
+			Users map[string]int `json:"users"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to decode nodeinfo: %s: %s", err, rec.Body)
+	}
+	if u := doc.Usage.Users; u["total"] != 2 || u["activeMonth"] != 1 || u["activeHalfyear"] != 1 {
+		t.Errorf("unexpected users: %v", u)
+	}
+}
+
+func TestLookupUser_caseAndTrailingSlash(t *testing.T) {
+	h := newTestHandler(t, "Alice")
+	actor := "https://local.example/@Alice"
+
+	for _, path := range []string{"/@Alice", "/@alice", "/@ALICE/", "/@alice/outbox/", "/@aLiCe/followers"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", "application/activity+json")
+		rec := serve(h, req)
+		if rec.Code != 200 {
+			t.Errorf("GET %s: expected 200 but got %d", path, rec.Code)
+			continue
+		}
+
+		var doc map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if id, _ := doc["id"].(string); id != actor && !strings.HasPrefix(id, actor+"/") {
+			t.Errorf("GET %s: the id does not use the stored casing: %s", path, id)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/.well-known/webfinger?resource=acct:ALICE@local.example", nil)
+	rec := serve(h, req)
+	if rec.Code != 200 {
+		t.Fatalf("expected 200 for webfinger but got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"href":"`+actor+`"`) {
+		t.Errorf("webfinger does not link the canonical actor: %s", rec.Body)
+	}
+
+	if rec := serve(h, httptest.NewRequest("GET", "/@bob", nil)); rec.Code != 404 {
+		t.Errorf("expected 404 for an unknown user but got %d", rec.Code)
+	}
+
+	// Any username is accepted in lowercase if no users are configured.
+	if u, ok := newTestHandler(t).lookupUser("Carol"); !ok || u.Name != "carol" {
+		t.Errorf("unexpected user: %+v, %v", u, ok)
+	}
+}
+
+func TestGetWebFinger_actorPaths(t *testing.T) {
+	for _, usersPath := range []bool{false, true} {
+		h := newTestHandler(t, "alice")
+		h.UsersPath = usersPath
+		canonical, alternate := "https://local.example/@alice", "https://local.example/users/alice"
+		if usersPath {
+			canonical, alternate = alternate, canonical
+		}
+
+		rec := serve(h, httptest.NewRequest("GET", "/.well-known/webfinger?resource=acct:alice@local.example", nil))
+		var doc struct {
+			Aliases []string `json:"aliases"`
+			Links   []struct {
+				Rel  string `json:"rel"`
+				Type string `json:"type"`
+				Href string `json:"href"`
+			} `json:"links"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("failed to decode: %s: %s", err, rec.Body)
+		}
+
+		self := 0
+		for _, l := range doc.Links {
+			if l.Rel != "self" {
+				continue
+			}
+			self++
+			if l.Type != "application/activity+json" || l.Href != canonical {
+				t.Errorf("usersPath=%v: unexpected self link: %+v", usersPath, l)
+			}
+		}
+		if self != 1 {
+			t.Errorf("usersPath=%v: expected one self link but got %d", usersPath, self)
+		}
+		if len(doc.Aliases) == 0 || doc.Aliases[0] != canonical {
+			t.Errorf("usersPath=%v: the canonical id is not the first alias: %q", usersPath, doc.Aliases)
+		}
+		found := false
+		for _, a := range doc.Aliases {
+			found = found || a == alternate
+		}
+		if !found {
+			t.Errorf("usersPath=%v: the alternate URL is not an alias: %q", usersPath, doc.Aliases)
+		}
+
+		// Both forms serve the actor with the canonical id.
+		for _, path := range []string{"/@alice", "/users/alice"} {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("Accept", "application/activity+json")
+			var actor map[string]any
+			if err := json.Unmarshal(serve(h, req).Body.Bytes(), &actor); err != nil {
+				t.Fatal(err)
+			}
+			if actor["id"] != canonical {
+				t.Errorf("usersPath=%v: GET %s: unexpected id: %v", usersPath, path, actor["id"])
+			}
+		}
+	}
+}
+
+func TestUserActor_links(t *testing.T) {
+	h := newTestHandler(t)
+	h.Users = []*User{
+		{Name: "alice"},
+		{Name: "bob", Links: []ActorLink{
+			{Href: "https://local.example/@bob", MediaType: "text/html"},
+			{Href: "https://local.example/@bob.rss", MediaType: "application/rss+xml"},
+		}},
+	}
+
+	if url := getJSON(t, h, "/@alice")["url"]; url != h.userURL("alice") {
+		t.Errorf("expected the url to be the actor id as a string but got %#v", url)
+	}
+
+	links, ok := getJSON(t, h, "/@bob")["url"].([]any)
+	if !ok || len(links) != 2 {
+		t.Fatalf("unexpected url: %#v", links)
+	}
+	for i, want := range h.Users[1].Links {
+		link, _ := links[i].(map[string]any)
+		if link["type"] != "Link" || link["href"] != want.Href || link["mediaType"] != want.MediaType {
+			t.Errorf("unexpected link: %v", link)
+		}
+	}
+}
+
+func TestUserActor_type(t *testing.T) {
+	h := newTestHandler(t)
+	h.Users = []*User{{Name: "alice"}, {Name: "bot", Type: "Service"}}
+
+	if typ := getJSON(t, h, "/@alice")["type"]; typ != "Person" {
+		t.Errorf("expected Person by default but got %v", typ)
+	}
+	if typ := getJSON(t, h, "/@bot")["type"]; typ != "Service" {
+		t.Errorf("expected Service but got %v", typ)
+	}
+}
+
+func TestLoadConfig_type(t *testing.T) {
+	tests := []struct {
+		Type string
+		OK   bool
+	}{
+		{"", true},
+		{"Person", true},
+		{"Service", true},
+		{"Application", true},
+		{"Group", true},
+		{"Organization", true},
+		{"service", false},
+		{"Bot", false},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "config.json")
+		conf := fmt.Sprintf(`{"hosts": [{"hostname": "local.example", "users": [{"name": "alice", "type": %q}]}]}`, tt.Type)
+		if err := os.WriteFile(path, []byte(conf), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := loadConfig(path); (err == nil) != tt.OK {
+			t.Errorf("type %q: unexpected result: %v", tt.Type, err)
+		}
+	}
+}
+
+func TestLoadConfig_example(t *testing.T) {
+	conf, err := loadConfig("config.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types := make(map[string]string)
+	for _, host := range conf.Hosts {
+		for _, u := range host.Users {
+			types[u.Name] = u.actorType()
+		}
+	}
+	if types["bob"] != "Person" || types["bot"] != "Service" {
+		t.Errorf("unexpected actor types: %v", types)
+	}
+}
+
+func TestUserActor_fields(t *testing.T) {
+	website := `<a href="https://alpha.example.com" rel="me nofollow noopener" target="_blank">alpha.example.com</a>`
+	h := newTestHandler(t)
+	h.Users = []*User{
+		{Name: "alice"},
+		{Name: "bob", Fields: []ProfileField{{Name: "Website", Value: website}, {Name: "Pronouns", Value: "they/them"}}},
+	}
+
+	if attachment, ok := getJSON(t, h, "/@alice")["attachment"]; ok {
+		t.Errorf("unexpected attachment of a user without fields: %v", attachment)
+	}
+
+	actor := getJSON(t, h, "/@bob")
+	attachment, _ := actor["attachment"].([]any)
+	if len(attachment) != 2 {
+		t.Fatalf("unexpected attachment: %v", actor["attachment"])
+	}
+	for i, want := range h.Users[1].Fields {
+		got, _ := attachment[i].(map[string]any)
+		if len(got) != 3 || got["type"] != "PropertyValue" || got["name"] != want.Name || got["value"] != want.Value {
+			t.Errorf("unexpected attachment: %v", got)
+		}
+	}
+
+	// Mastodon reads PropertyValue and value by the terms of schema.org.
+	found := false
+	for _, c := range actor["@context"].([]any) {
+		if m, ok := c.(map[string]any); ok && m["PropertyValue"] == "schema:PropertyValue" && m["value"] == "schema:value" && m["schema"] == "http://schema.org#" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("the context does not define PropertyValue: %v", actor["@context"])
+	}
+}
+
+func TestUserActor_featuredTags(t *testing.T) {
+	h := newTestHandler(t, "alice")
+
+	// The featuredTags term is defined only where the property is emitted.
+	hasTerm := func(actor map[string]any) bool {
+		for _, c := range actor["@context"].([]any) {
+			if m, ok := c.(map[string]any); ok && m["featuredTags"] != nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	actor := getJSON(t, h, "/@alice")
+	if v, ok := actor["featuredTags"]; ok || hasTerm(actor) {
+		t.Errorf("featuredTags is emitted without FeaturedTags: %v", v)
+	}
+
+	h.FeaturedTags = true
+	actor = getJSON(t, h, "/@alice")
+	if v := actor["featuredTags"]; v != "https://local.example/@alice/collections/tags" || !hasTerm(actor) {
+		t.Errorf("unexpected featuredTags: %v in %v", v, actor["@context"])
+	}
+}
+
+func TestGetUserActor_suspended(t *testing.T) {
+	yes := true
+	h := newTestHandler(t)
+	fields := []ProfileField{{Name: "Pronouns", Value: "they/them"}}
+	h.Users = []*User{{Name: "alice", Fields: fields, Discoverable: &yes}, {Name: "bob", Fields: fields, Suspended: true}}
+
+	if actor := getJSON(t, h, "/@alice"); actor["discoverable"] != true || actor["suspended"] != nil {
+		t.Errorf("unexpected flags of alice: discoverable=%v suspended=%v", actor["discoverable"], actor["suspended"])
+	}
+
+	for _, mode := range []string{"", SuspendedActorMinimal, SuspendedActorForbidden} {
+		t.Run(fmt.Sprintf("%q", mode), func(t *testing.T) {
+			h.SuspendedActor = mode
+
+			req := httptest.NewRequest("GET", "/@bob", nil)
+			req.Header.Set("Accept", "application/activity+json")
+			rec := serve(h, req)
+			if mode == SuspendedActorForbidden {
+				if rec.Code != 403 {
+					t.Errorf("expected 403 but got %d", rec.Code)
+				}
+				return
+			}
+
+			var actor map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &actor); rec.Code != 200 || err != nil {
+				t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body)
+			}
+			if actor["suspended"] != true || actor["attachment"] != nil || actor["id"] != h.userURL("bob") {
+				t.Errorf("unexpected minimal actor: %v", actor)
+			}
+			// The key is kept, so that the past activities can still be verified.
+			if key, _ := actor["publicKey"].(map[string]any); key["id"] != h.userURL("bob")+"#main-key" || key["publicKeyPem"] == "" {
+				t.Errorf("unexpected key: %v", actor["publicKey"])
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// webhookEvent is the body posted to WebhookURL for each activity received by the inbox.
+type webhookEvent struct {
+	Type       []string  `json:"type"`
+	ID         string    `json:"id,omitempty"`
+	Actor      string    `json:"actor"`
+	Object     string    `json:"object,omitempty"`
+	Inbox      string    `json:"inbox"`
+	Verified   bool      `json:"verified"`
+	Error      string    `json:"error,omitempty"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// fireWebhook posts the event to WebhookURL in background. It does nothing if WebhookURL is empty.
+func (h *Handler) fireWebhook(event webhookEvent) {
+	if h.WebhookURL == "" {
+		return
+	}
+
+	go func() {
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("failed to encode webhook event: %s", err)
+			return
+		}
+
+		resp, err := h.client().Post(h.WebhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("failed to fire webhook: %s", err)
+			return
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			log.Printf("webhook responded with unexpected status code: %d", resp.StatusCode)
+		}
+	}()
+}
+
+// inboxEvent builds the webhook event of an activity received by the inbox. verifyErr is nil if the signature was valid.
+func inboxEvent(r *http.Request, activity *Activity, verifyErr error) webhookEvent {
+	event := webhookEvent{
+		Type:       activity.Type,
+		ID:         activity.ID,
+		Actor:      activity.Actor,
+		Object:     activity.Object.ID,
+		Inbox:      r.URL.Path,
+		Verified:   verifyErr == nil,
+		ReceivedAt: time.Now(),
+	}
+	if verifyErr != nil {
+		event.Error = verifyErr.Error()
+	}
+	return event
+}